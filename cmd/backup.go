@@ -3,128 +3,654 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"archive/tar"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/hooks"
+	"github.com/shah1011/obscure/internal/ignore"
+	"github.com/shah1011/obscure/internal/index"
+	"github.com/shah1011/obscure/internal/keyring"
+	"github.com/shah1011/obscure/internal/manifest"
+	"github.com/shah1011/obscure/internal/naming"
+	"github.com/shah1011/obscure/internal/repository"
 	strg "github.com/shah1011/obscure/internal/storage"
 	"github.com/shah1011/obscure/utils"
 	"github.com/spf13/cobra"
 )
 
-// CreateBackupFile creates a backup file from the given path
-func CreateBackupFile(path string) (*os.File, error) {
-	// Create a temporary file
-	tmpFile, err := os.CreateTemp("", "obscure-backup-*")
+// WriteBackupArchive writes the backup payload for path to w: a tar stream
+// of the directory's files that rules admit, or the raw contents for a
+// single file
+func WriteBackupArchive(path string, w io.Writer, rules ignore.Rules) error {
+	// Check if path is a directory
+	fileInfo, err := os.Stat(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
+		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	// Check if path is a directory
-	fileInfo, err := os.Stat(path)
+	if !fileInfo.IsDir() {
+		// For regular files, just copy the contents
+		srcFile, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open source file: %w", err)
+		}
+		defer srcFile.Close()
+
+		if _, err := io.Copy(w, srcFile); err != nil {
+			return fmt.Errorf("failed to copy file contents: %w", err)
+		}
+		return nil
+	}
+
+	// Create a tar archive for directories
+	aw := utils.NewArchiveWriter(w)
+
+	// Walk through the directory, leaving out what the rules exclude
+	m, err := ignore.New(path, rules)
 	if err != nil {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-		return nil, fmt.Errorf("failed to get file info: %w", err)
+		return err
+	}
+	err = m.Walk(func(file, rel string, fi os.FileInfo) error {
+		return aw.Add(file, rel)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tar archive: %w", err)
 	}
 
-	if fileInfo.IsDir() {
-		// Create a tar archive for directories
-		tw := tar.NewWriter(tmpFile)
-		defer tw.Close()
+	// Close the tar writer
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	return nil
+}
 
-		// Walk through the directory
-		err = filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+// writeMultiArchive writes a tar stream holding each source under its own
+// top-level directory. The rules apply to every source, relative to it.
+func writeMultiArchive(sources []manifest.Source, w io.Writer, rules ignore.Rules) error {
+	aw := utils.NewArchiveWriter(w)
+	for _, source := range sources {
+		if err := aw.Add(source.Path, source.Root); err != nil {
+			return fmt.Errorf("failed to add %s: %w", source.Path, err)
+		}
+		fi, err := os.Stat(source.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get file info: %w", err)
+		}
+		if !fi.IsDir() {
+			continue
+		}
+		m, err := ignore.New(source.Path, rules)
+		if err != nil {
+			return err
+		}
+		err = m.Walk(func(file, rel string, fi os.FileInfo) error {
+			return aw.Add(file, source.Root+"/"+rel)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create tar archive: %w", err)
+		}
+	}
 
-			// Skip the root directory itself
-			if file == path {
-				return nil
-			}
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	return nil
+}
 
-			// Create header
-			header, err := tar.FileInfoHeader(fi, file)
-			if err != nil {
-				return err
-			}
+// sourceRoots resolves the paths of a multi-source backup and names the
+// top-level directory of each after its base name, numbering repeats
+func sourceRoots(paths []string) ([]manifest.Source, error) {
+	var sources []manifest.Source
+	seen := map[string]bool{}
+	taken := map[string]bool{}
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return nil, err
+		}
+		if seen[absPath] {
+			return nil, fmt.Errorf("%s is listed more than once", path)
+		}
+		seen[absPath] = true
 
-			// Get relative path
-			relPath, err := filepath.Rel(path, file)
-			if err != nil {
-				return err
-			}
-			header.Name = relPath
+		name := filepath.Base(absPath)
+		if name == string(filepath.Separator) {
+			name = "root"
+		}
+		root := name
+		for i := 2; taken[root]; i++ {
+			root = fmt.Sprintf("%s-%d", name, i)
+		}
+		taken[root] = true
+		sources = append(sources, manifest.Source{Root: root, Path: absPath})
+	}
+	return sources, nil
+}
 
-			// Write header
-			if err := tw.WriteHeader(header); err != nil {
-				return err
-			}
+// writeIncrementalArchive writes a tar stream holding the increment manifest
+// followed by the paths plan marks as changed
+func writeIncrementalArchive(root string, plan *index.Plan, increment index.Increment, w io.Writer) error {
+	aw := utils.NewArchiveWriter(w)
+	tw := aw.Writer()
 
-			// If it's a regular file, write its contents
-			if !fi.IsDir() {
-				data, err := os.Open(file)
-				if err != nil {
-					return err
-				}
-				defer data.Close()
+	manifest, err := json.Marshal(increment)
+	if err != nil {
+		return err
+	}
+	// A fixed mtime keeps the archive identical when a resumed upload
+	// writes it again
+	err = tw.WriteHeader(&tar.Header{
+		Name:    index.ManifestName,
+		Mode:    0600,
+		Size:    int64(len(manifest)),
+		ModTime: time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
 
-				if _, err := io.Copy(tw, data); err != nil {
-					return err
-				}
-			}
+	for _, rel := range plan.Changed {
+		if err := aw.Add(filepath.Join(root, filepath.FromSlash(rel)), rel); err != nil {
+			return fmt.Errorf("failed to add %s: %w", rel, err)
+		}
+	}
 
-			return nil
-		})
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	return nil
+}
+
+// archiveFunc writes the uncompressed, unencrypted backup payload to w
+type archiveFunc func(w io.Writer) error
+
+// pathArchive archives path as a whole
+func pathArchive(path string, rules ignore.Rules) archiveFunc {
+	return func(w io.Writer) error {
+		return WriteBackupArchive(path, w, rules)
+	}
+}
+
+// multiArchive archives several sources into one backup
+func multiArchive(sources []manifest.Source, rules ignore.Rules) archiveFunc {
+	return func(w io.Writer) error {
+		return writeMultiArchive(sources, w, rules)
+	}
+}
+
+// stdinArchive passes stdin through as a single-file payload. It can only
+// be read once.
+func stdinArchive(w io.Writer) error {
+	if _, err := io.Copy(w, os.Stdin); err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return nil
+}
+
+// recordedArchive tees the payload written by archive into rec, which
+// builds the backup's manifest as a side effect
+func recordedArchive(archive archiveFunc, rec *manifest.Recorder) archiveFunc {
+	return func(w io.Writer) error {
+		if err := archive(io.MultiWriter(w, rec)); err != nil {
+			rec.Abort(err)
+			return err
+		}
+		if err := rec.Close(); err != nil {
+			return fmt.Errorf("failed to build manifest: %w", err)
+		}
+		return nil
+	}
+}
 
+// uploadManifest stores the manifest next to the backup. It is encrypted
+// like the backup, to its password or the public keys of key; direct
+// backups get a plain manifest, unless names are private: their manifest is
+// sealed to the keyring that ring unlocks, so it does not give away the tag,
+// version and source the names hide.
+func uploadManifest(ctx context.Context, backend strg.Backend, names *naming.Namer, ring func() (*keyring.Keyring, error), tag, version, source string, m *manifest.Manifest, password string, key *utils.WrappedKey) error {
+	describeManifest(m, tag, version, source)
+	if password == "" && key == nil && names.IsPrivate() {
+		k, err := ring()
 		if err != nil {
-			tmpFile.Close()
-			os.Remove(tmpFile.Name())
-			return nil, fmt.Errorf("failed to create tar archive: %w", err)
+			return fmt.Errorf("failed to unlock keyring: %v", err)
+		}
+		if key, err = k.WrapKey(); err != nil {
+			return err
 		}
+	}
+	data, err := manifest.Encode(m, password, key)
+	if err != nil {
+		return err
+	}
+	metadata, err := names.SealMetadata(map[string]string{"tag": tag, "version": version})
+	if err != nil {
+		return err
+	}
+	return backend.Put(ctx, names.Key(tag, version, manifest.Extension), bytes.NewReader(data), int64(len(data)), metadata)
+}
+
+// describeManifest fills in where and when a recorded manifest was made.
+// Multi-source backups pass no source; their manifest lists m.Sources.
+func describeManifest(m *manifest.Manifest, tag, version, source string) {
+	m.Tag = tag
+	m.Version = version
+	m.CreatedAt = time.Now().UTC()
+	if source != "" {
+		m.SourcePath, _ = filepath.Abs(source)
+	}
+	m.Host, _ = os.Hostname()
+	m.ObscureVersion = Version
 
-		// Close the tar writer
-		if err := tw.Close(); err != nil {
-			tmpFile.Close()
-			os.Remove(tmpFile.Name())
-			return nil, fmt.Errorf("failed to close tar writer: %w", err)
+	// A single-file payload carries no metadata of its own
+	if m.Kind == manifest.KindFile && len(m.Files) == 1 {
+		if fi, err := os.Stat(source); err == nil {
+			m.Files[0].Mode = fi.Mode()
+			m.Files[0].ModTime = fi.ModTime().UTC()
 		}
+	}
+}
+
+// writeBackupStream runs tar -> zstd -> encrypt into w. Direct backups skip
+// compression and encryption.
+func writeBackupStream(w io.Writer, archive archiveFunc, password string, isDirect bool, encOpts utils.EncryptOptions) error {
+	if isDirect {
+		return archive(w)
+	}
+
+	encWriter, err := utils.EncryptStreamWithOptions(w, password, encOpts)
+	if err != nil {
+		return fmt.Errorf("failed to initialize encryption: %w", err)
+	}
+	compWriter := utils.NewCompressWriter(encWriter)
+
+	if err := archive(compWriter); err != nil {
+		compWriter.Close()
+		return err
+	}
+
+	// Close writers in correct order
+	if err := compWriter.Close(); err != nil {
+		return fmt.Errorf("failed to close compression: %w", err)
+	}
+	if err := encWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finalize encryption: %w", err)
+	}
+	return nil
+}
+
+// newBackupStream starts the backup pipeline in a goroutine and returns the
+// read end of a pipe. Nothing is buffered beyond the stages' own chunks: the
+// archiver blocks until the uploader reads. A failure in the pipeline
+// surfaces as the reader's error, and closing the reader early stops the
+// pipeline.
+func newBackupStream(archive archiveFunc, password string, isDirect bool, encOpts utils.EncryptOptions) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBackupStream(pw, archive, password, isDirect, encOpts))
+	}()
+	return pr
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingReader) Count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// newUploadJournal prepares the journal for a fresh multipart upload. The
+// salt and nonce prefix, and the key slots of a backup sealed to a keyring,
+// are fixed up front so that a resumed run produces exactly the same
+// ciphertext.
+func newUploadJournal(providerKey, key, tag, version string, backupPaths []string, isDirect bool, partSize int64, wrapped *utils.WrappedKey) (*strg.UploadJournal, error) {
+	var absPaths []string
+	for _, path := range backupPaths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		absPaths = append(absPaths, absPath)
+	}
+
+	journal := &strg.UploadJournal{
+		Provider:  providerKey,
+		Key:       key,
+		Tag:       tag,
+		Version:   version,
+		IsDirect:  isDirect,
+		PartSize:  partSize,
+		CreatedAt: time.Now(),
+	}
+	if len(absPaths) == 1 {
+		journal.SourcePath = absPaths[0]
 	} else {
-		// For regular files, just copy the contents
-		srcFile, err := os.Open(path)
+		journal.Sources = absPaths
+	}
+	if !isDirect {
+		salt, err := utils.GenerateSalt()
 		if err != nil {
-			tmpFile.Close()
-			os.Remove(tmpFile.Name())
-			return nil, fmt.Errorf("failed to open source file: %w", err)
+			return nil, err
 		}
-		defer srcFile.Close()
+		noncePrefix, err := utils.GenerateNoncePrefix()
+		if err != nil {
+			return nil, err
+		}
+		journal.Salt = hex.EncodeToString(salt)
+		journal.NoncePrefix = hex.EncodeToString(noncePrefix)
+	}
+	if wrapped != nil {
+		journal.KeySlots = hex.EncodeToString(wrapped.Slots)
+	}
+	return journal, nil
+}
+
+// journalEncryptOptions returns encryption options that reproduce the
+// journaled upload's stream, given the key opened by journalKey
+func journalEncryptOptions(journal *strg.UploadJournal, key *utils.WrappedKey) (utils.EncryptOptions, error) {
+	opts := utils.DefaultEncryptOptions()
+	if journal.IsDirect {
+		return opts, nil
+	}
+	opts.KDF = utils.LegacyKDFParams()
+	if journal.KDF != nil {
+		opts.KDF = *journal.KDF
+	}
+
+	salt, err := hex.DecodeString(journal.Salt)
+	if err != nil {
+		return opts, fmt.Errorf("corrupt upload journal: %w", err)
+	}
+	noncePrefix, err := hex.DecodeString(journal.NoncePrefix)
+	if err != nil {
+		return opts, fmt.Errorf("corrupt upload journal: %w", err)
+	}
+	opts.Salt = salt
+	opts.NoncePrefix = noncePrefix
+	opts.Key = key
+	return opts, nil
+}
+
+// journalKey opens the key of a resumed backup sealed to a keyring; the
+// journal only has its key slots
+func journalKey(journal *strg.UploadJournal, ring func() (*keyring.Keyring, error)) (*utils.WrappedKey, error) {
+	slots, err := hex.DecodeString(journal.KeySlots)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt upload journal: %w", err)
+	}
+	k, err := ring()
+	if err != nil {
+		return nil, err
+	}
+	return utils.UnwrapKey(slots, utils.Keys{MasterKey: k.Lookup})
+}
+
+// findResumableUpload picks the journaled upload matching tag and version;
+// empty values match anything
+func findResumableUpload(providerKey, tag, version string) (*strg.UploadJournal, error) {
+	journals, err := strg.ListJournals(providerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload journals: %v", err)
+	}
+
+	var matches []*strg.UploadJournal
+	for _, journal := range journals {
+		if (tag == "" || journal.Tag == tag) && (version == "" || journal.Version == version) {
+			matches = append(matches, journal)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no interrupted upload found in %s", strg.DisplayName(providerKey))
+	case 1:
+		return matches[0], nil
+	default:
+		msg := "several interrupted uploads match, pick one with --tag and --version:"
+		for _, journal := range matches {
+			msg += fmt.Sprintf("\n   - --tag %s --version %s (%s)", journal.Tag, journal.Version, strings.Join(journal.Paths(), ", "))
+		}
+		return nil, fmt.Errorf("%s", msg)
+	}
+}
+
+// runRepositoryBackup stores path in the user's chunk repository, uploading
+// only chunks that no earlier snapshot contains
+func runRepositoryBackup(providerKey, username, path, tag, version, password string, rules ignore.Rules) error {
+	ctx := context.Background()
+	backend, err := strg.OpenBackend(ctx, providerKey)
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s client: %v", strg.DisplayName(providerKey), err)
+	}
+	// Snapshots and chunks are stored under readable names
+	private, err := keyring.PrivateNames(ctx, backend, username)
+	if err != nil {
+		return err
+	}
+	if private {
+		return fmt.Errorf("private names are on in %s, and repository backups do not support them yet", strg.DisplayName(providerKey))
+	}
+	exists, err := strg.Exists(ctx, backend, repository.SnapshotKey(username, tag, version))
+	if err != nil {
+		return fmt.Errorf("failed to check if backup exists: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("a backup with this name already exists")
+	}
+
+	repo, err := repository.Open(ctx, backend, username, password, true)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	m, err := ignore.New(path, rules)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("📦 Chunking %s into the %s repository...\n", path, strg.DisplayName(providerKey))
+	start := time.Now()
+	stats, err := repo.Backup(ctx, path, username, tag, version, m)
+	if err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+
+	fmt.Printf("✅ Backup completed in %s\n", time.Since(start).Round(time.Millisecond))
+	fmt.Printf("📊 %d files, %s; %d of %d chunks were new (%s uploaded)\n",
+		stats.Files, FormatBytes(stats.Bytes), stats.NewChunks, stats.Chunks, FormatBytes(stats.UploadedBytes))
+	fmt.Printf("🔗 Snapshot: %s\n", repository.SnapshotKey(username, tag, version))
+	return nil
+}
+
+// addHookFlags registers the hook flags shared by backup and scheduler
+func addHookFlags(cmd *cobra.Command) {
+	cmd.Flags().String("pre-hook", "", "Shell command run before the backup reads its source; the backup is aborted if it fails")
+	cmd.Flags().String("post-hook", "", "Shell command run after the backup, whether it succeeded or not")
+	cmd.Flags().String("on-failure", "", "Shell command run when the backup fails")
+	cmd.Flags().Duration("hook-timeout", hooks.DefaultTimeout, "Kill a hook that runs longer than this (0 = no limit)")
+}
+
+// backupHooks reads the flags registered by addHookFlags
+func backupHooks(cmd *cobra.Command) hooks.Config {
+	var c hooks.Config
+	c.Pre, _ = cmd.Flags().GetString("pre-hook")
+	c.Post, _ = cmd.Flags().GetString("post-hook")
+	c.Failure, _ = cmd.Flags().GetString("on-failure")
+	c.Timeout, _ = cmd.Flags().GetDuration("hook-timeout")
+	return c
+}
+
+// planIncrement compares path with the tag's index. It falls back to a full
+// backup when there is no index, the source moved, or the parent backup is
+// gone from the provider. password is only called if the parent has a
+// private name.
+func planIncrement(providerKey, username, tag, path string, forceFull bool, rules ignore.Rules, password func() (string, error)) (*index.Plan, index.Increment, error) {
+	var increment index.Increment
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, increment, err
+	}
+	prev, err := index.Load(providerKey, username, tag)
+	if err != nil {
+		return nil, increment, fmt.Errorf("failed to read the file index: %v", err)
+	}
+	if prev != nil && prev.SourcePath != absPath {
+		fmt.Printf("ℹ️  Tag '%s' was last backed up from %s; starting a new full backup.\n", tag, prev.SourcePath)
+		prev = nil
+	}
+	if prev != nil && !forceFull {
+		ctx := context.Background()
+		backend, err := strg.OpenBackend(ctx, providerKey)
+		if err != nil {
+			return nil, increment, fmt.Errorf("failed to initialize %s client: %v", strg.DisplayName(providerKey), err)
+		}
+		names, err := backupNamer(ctx, backend, username, lazyKeyring(ctx, backend, username, password))
+		if err != nil {
+			return nil, increment, err
+		}
+		exists, err := strg.Exists(ctx, backend, names.Key(tag, prev.Version, "obscure"))
+		if err != nil {
+			return nil, increment, fmt.Errorf("failed to check the parent backup: %v", err)
+		}
+		if !exists {
+			fmt.Printf("ℹ️  Parent backup %s is gone; starting a new full backup.\n", prev.Version)
+			prev = nil
+		}
+	}
+	if forceFull {
+		prev = nil
+	}
+
+	m, err := ignore.New(absPath, rules)
+	if err != nil {
+		return nil, increment, err
+	}
+	plan, err := index.Scan(absPath, prev, m)
+	if err != nil {
+		return nil, increment, fmt.Errorf("failed to scan %s: %v", path, err)
+	}
+	plan.Index.Provider = providerKey
+	plan.Index.Username = username
+	plan.Index.Tag = tag
+
+	if prev != nil {
+		increment.Parent = prev.Version
+		increment.Base = prev.Base
+		increment.Deleted = plan.Deleted
+	}
+	return plan, increment, nil
+}
 
-		if _, err := io.Copy(tmpFile, srcFile); err != nil {
-			tmpFile.Close()
-			os.Remove(tmpFile.Name())
-			return nil, fmt.Errorf("failed to copy file contents: %w", err)
+// previewBackup lists what a backup of path would archive, for --dry-run
+func previewBackup(path string, sourceInfo os.FileInfo, rules ignore.Rules) error {
+	if !sourceInfo.IsDir() {
+		fmt.Printf("   📄 %s (%s)\n", filepath.Base(path), FormatBytes(sourceInfo.Size()))
+		fmt.Printf("📊 1 file, %s\n", FormatBytes(sourceInfo.Size()))
+		return nil
+	}
+
+	m, err := ignore.New(path, rules)
+	if err != nil {
+		return err
+	}
+	var files, dirs, links int
+	var total int64
+	err = m.Walk(func(file, rel string, fi os.FileInfo) error {
+		switch {
+		case fi.IsDir():
+			dirs++
+			fmt.Printf("   📁 %s/\n", rel)
+		case fi.Mode()&os.ModeSymlink != 0:
+			links++
+			target, _ := os.Readlink(file)
+			fmt.Printf("   🔗 %s -> %s\n", rel, target)
+		default:
+			files++
+			total += fi.Size()
+			fmt.Printf("   📄 %s (%s)\n", rel, FormatBytes(fi.Size()))
 		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %v", path, err)
 	}
 
-	// Reset file pointer to beginning
-	if _, err := tmpFile.Seek(0, 0); err != nil {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-		return nil, fmt.Errorf("failed to seek to beginning: %w", err)
+	fmt.Printf("📊 Would archive %d files, %d directories and %d symlinks, %s in total\n", files, dirs, links, FormatBytes(total))
+	if m.Excluded+m.TooLarge+m.OtherFS > 0 {
+		fmt.Printf("🚫 Skipped %d excluded, %d over the size limit, %d on other filesystems\n", m.Excluded, m.TooLarge, m.OtherFS)
+	}
+	return nil
+}
+
+// sameSources reports whether two lists name the same paths in the same order
+func sameSources(paths, journaled []string) bool {
+	if len(paths) != len(journaled) {
+		return false
+	}
+	for i, path := range paths {
+		if absPath, _ := filepath.Abs(path); absPath != journaled[i] {
+			return false
+		}
 	}
+	return true
+}
 
-	return tmpFile, nil
+// sizePattern is a number and an optional unit, matched case-insensitively
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*(B|[KMGT](?:I?B)?)?$`)
+
+// ParseBytes parses a size such as 1048576, 512K, 100MB or 2GiB; units
+// are powers of 1024
+func ParseBytes(size string) (int64, error) {
+	match := sizePattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(size)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q: use a number with an optional unit, e.g. 500MB", size)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	multiplier := int64(1)
+	if unit := strings.TrimSuffix(strings.TrimSuffix(match[2], "B"), "I"); unit != "" {
+		multiplier = int64(1) << (10 * (strings.Index("KMGT", unit) + 1))
+	}
+	n := value * float64(multiplier)
+	if n >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", size)
+	}
+	return int64(n), nil
 }
 
 // FormatBytes formats a byte size into a human-readable string
@@ -191,15 +717,100 @@ func uploadWithAWSCLI(localPath, bucket, key, region, endpoint, accessKey, secre
 	return nil
 }
 
+// uploadFilebaseWithAWSCLI retries a failed Filebase+IPFS upload through the AWS CLI.
+// The CLI needs a file, so the fresh backup stream is spooled to disk first.
+func uploadFilebaseWithAWSCLI(reader io.Reader, key string) error {
+	f, ferr := os.CreateTemp("", "obscure-upload-*")
+	if ferr != nil {
+		return fmt.Errorf("failed to create temp file for AWS CLI upload: %v", ferr)
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+
+	_, ferr = io.Copy(f, reader)
+	f.Close()
+	if ferr != nil {
+		return fmt.Errorf("failed to write temp file for AWS CLI upload: %v", ferr)
+	}
+	providerConfig, _ := cfg.GetProviderConfig("filebase-ipfs")
+	err := uploadWithAWSCLI(tmpPath, providerConfig.Bucket, key, providerConfig.Region, providerConfig.FilebaseEndpoint, providerConfig.AccessKeyID, providerConfig.SecretAccessKey)
+	if err != nil {
+		return fmt.Errorf("AWS CLI upload failed: %v", err)
+	}
+	fmt.Println("✅ Backup uploaded using AWS CLI fallback.")
+	return nil
+}
+
 var backupCmd = &cobra.Command{
-	Use:   "backup <path>",
-	Short: "Back up a file or directory to your cloud storage",
-	Long: `Back up a file or directory to your cloud storage. You can specify the backup tag and version using flags:
+	Use:   "backup <path>...",
+	Short: "Back up files or directories to your cloud storage",
+	Long: `Back up files or directories to your cloud storage. You can specify the backup tag and version using flags:
   --tag: Tag for the backup (e.g., 'unit' or 'prod')
   --version: Version for the backup (e.g., '2.1' or '1.0')
   --direct: Create an unencrypted tar backup (default is encrypted .obscure format)
-  --all: Upload to all enabled cloud providers`,
-	Args: cobra.ExactArgs(1),
+  --all: Upload to all enabled cloud providers at once
+  --quorum, --retries, --retry-delay: With --all, how many providers must store the backup and how often a failed one is retried
+  --resume: Continue an interrupted upload to an S3-family provider (path is optional)
+  --part-size, --parallel: Multipart upload tuning for S3-family providers
+  --repo: Store the backup in the deduplicating chunk repository; only changed data is uploaded
+  --incremental: Upload only files changed since the tag's previous incremental backup
+  --full: With --incremental, start a new chain with a full backup
+  --exclude, --include: Glob patterns selecting what is backed up ("**" matches any number of directories)
+  --max-file-size: Skip files larger than this size (e.g. 500MB)
+  --one-file-system: Do not cross into other mounted filesystems
+  --dry-run: List what would be backed up, with totals, without uploading
+  --pre-hook, --post-hook, --on-failure: Shell commands run around the backup
+  --password-file, --password-command, --keyfile: Read the password instead of prompting
+    (OBSCURE_PASSWORD is used when none of them is given)
+  --recipient, --recipients-file: Encrypt to age public keys instead of a password
+
+A backup encrypted to public keys (see 'obscure key generate') needs no
+password, and the machine that makes it cannot decrypt it afterwards; only
+the holders of the matching private keys can restore it. For the same
+reason such an upload keeps no journal and cannot be resumed:
+  obscure backup /srv/data --tag data --recipient age1...
+
+Once the bucket has a keyring (see 'obscure key add-password'), the password
+only unlocks it, and each backup gets its own key sealed to the keyring's
+master key, so passwords can change later without re-encrypting anything.
+With private names on ('obscure key private-names'), the backup's tag,
+version and metadata are encrypted too, and the keyring password is needed
+even for --direct and --recipient backups.
+
+With --all, the backup is archived and encrypted once into a temporary
+file, which needs as much free space as the encrypted backup, and every
+provider uploads from it at the same time, so a slow provider holds up no
+other. A provider that fails is retried on its own from the start of the
+file, waiting --retry-delay and then twice as long after each further
+failure. Buckets with different keyrings each get the backup key sealed to
+theirs. The backup succeeds, and the command exits with 0, when at least
+--quorum providers stored it (all of them by default):
+  obscure backup /srv/data --tag data --all --quorum 2
+
+A path of - backs up stdin as a single file named by --name, for piping
+database dumps without writing them to disk first:
+  pg_dump mydb | obscure backup - --tag db --name mydb.sql
+
+Several paths can go into one backup; each is stored under a directory named
+after it (numbered if two share a name), and the manifest records where each
+came from so that restore --in-place can put them back:
+  obscure backup /etc /var/lib/app ~/projects --tag host
+
+An .obscureignore file in any directory excludes paths below it, using the
+same rules as .gitignore.
+
+The pre-backup hook runs before the source is read, for example to flush or
+pause a database, and a failing pre-hook aborts the backup. The post-backup
+hook runs after every backup that got past it, so it can resume the service;
+the failure hook runs only when the backup fails. Hooks get OBSCURE_TAG,
+OBSCURE_VERSION, OBSCURE_KEY, OBSCURE_PROVIDER, OBSCURE_SOURCE,
+OBSCURE_HOOK, OBSCURE_STATUS (running, success or failure) and, after a
+failure, OBSCURE_ERROR in their environment, and are killed after
+--hook-timeout. OBSCURE_KEY is the object the backup is stored under, set
+once the provider has named it, so the pre-backup hook sees it empty. With
+private names it reveals neither tag nor version; those are only in
+OBSCURE_TAG and OBSCURE_VERSION.`,
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get session info
 		if _, err := cfg.GetSessionEmail(); err != nil {
@@ -210,6 +821,68 @@ var backupCmd = &cobra.Command{
 		// Check if direct backup is requested
 		isDirect, _ := cmd.Flags().GetBool("direct")
 		isAll, _ := cmd.Flags().GetBool("all")
+		isResume, _ := cmd.Flags().GetBool("resume")
+		partSizeMiB, _ := cmd.Flags().GetInt64("part-size")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		isRepo, _ := cmd.Flags().GetBool("repo")
+		isIncremental, _ := cmd.Flags().GetBool("incremental")
+		isFull, _ := cmd.Flags().GetBool("full")
+		isDryRun, _ := cmd.Flags().GetBool("dry-run")
+		quorum, _ := cmd.Flags().GetInt("quorum")
+		retries, _ := cmd.Flags().GetInt("retries")
+		retryDelay, _ := cmd.Flags().GetDuration("retry-delay")
+
+		var rules ignore.Rules
+		rules.Include, _ = cmd.Flags().GetStringArray("include")
+		rules.Exclude, _ = cmd.Flags().GetStringArray("exclude")
+		rules.OneFilesystem, _ = cmd.Flags().GetBool("one-file-system")
+		if maxFileSize, _ := cmd.Flags().GetString("max-file-size"); maxFileSize != "" {
+			size, err := ParseBytes(maxFileSize)
+			if err != nil {
+				fmt.Println("❌ Invalid --max-file-size:", err)
+				return
+			}
+			rules.MaxFileSize = size
+		}
+
+		if len(args) == 0 && !isResume {
+			fmt.Println("❌ Please specify the file or directory to back up.")
+			return
+		}
+		isStdin := len(args) == 1 && args[0] == "-"
+		if len(args) > 1 && slices.Contains(args, "-") {
+			fmt.Println("❌ Stdin (-) has to be the only source of a backup.")
+			return
+		}
+		if isStdin && (isResume || isIncremental || isRepo || isDryRun) {
+			fmt.Println("❌ Stdin can only be read once; drop --resume, --incremental, --repo and --dry-run.")
+			return
+		}
+		if len(args) > 1 && (isIncremental || isRepo) {
+			fmt.Println("❌ --incremental and --repo back up a single directory; give one path or drop them.")
+			return
+		}
+		if isResume && isAll {
+			fmt.Println("❌ --resume continues a single provider's upload and cannot be combined with --all.")
+			return
+		}
+		if isIncremental && (isDirect || isAll || isRepo) {
+			fmt.Println("❌ --incremental keeps one encrypted chain per provider and cannot be combined with --direct, --all or --repo.")
+			return
+		}
+		if isRepo && (isDirect || isAll || isResume) {
+			fmt.Println("❌ --repo backups are always encrypted, go to the active provider and cannot be resumed; drop --direct, --all and --resume.")
+			return
+		}
+		recipients, err := backupRecipients(cmd)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		if len(recipients) > 0 && (isDirect || isRepo) {
+			fmt.Println("❌ --recipient encrypts the backup to public keys; it cannot be combined with --direct or --repo.")
+			return
+		}
 
 		username, err := cfg.GetSessionUsername()
 		if err != nil {
@@ -233,459 +906,513 @@ var backupCmd = &cobra.Command{
 			fmt.Printf("❌ Failed to load provider configuration: %v\n", err)
 			return
 		}
+		var allProviders []string
+		if isAll {
+			allProviders = enabledProviders(providers)
+			if len(allProviders) == 0 {
+				fmt.Println("❌ No enabled and fully configured providers found.")
+				return
+			}
+			if quorum == 0 {
+				quorum = len(allProviders)
+			}
+			if quorum < 0 || quorum > len(allProviders) {
+				fmt.Printf("❌ --quorum has to be between 1 and the %d enabled providers.\n", len(allProviders))
+				return
+			}
+			if retries < 0 || retryDelay < 0 {
+				fmt.Println("❌ --retries and --retry-delay cannot be negative.")
+				return
+			}
+		}
 
-		// Get backup path and tag
-		backupPath := args[0]
+		// Get backup paths and tag
+		backupPaths := args
+
+		tag, _ := cmd.Flags().GetString("tag")
+		version, _ := cmd.Flags().GetString("version")
+		stdinName, _ := cmd.Flags().GetString("name")
+
+		var journal *strg.UploadJournal
+		if isResume {
+			// The journal knows the source, version and format of the upload
+			journal, err = findResumableUpload(providerKey, tag, version)
+			if err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+			if journal.HoldsKey() {
+				// Earlier versions journaled the backup key in plaintext; it
+				// must not stay on disk
+				journal.Remove()
+				fmt.Println("❌ This upload was journaled with its encryption key and cannot be resumed. The journal was removed; run `obscure uploads clean --older-than 0` and start a new backup.")
+				return
+			}
+			if len(backupPaths) > 0 && !sameSources(backupPaths, journal.Paths()) {
+				fmt.Printf("❌ The interrupted upload was of %s, not %s.\n", strings.Join(journal.Paths(), ", "), strings.Join(backupPaths, ", "))
+				return
+			}
+			backupPaths = journal.Paths()
+			tag = journal.Tag
+			version = journal.Version
+			isDirect = journal.IsDirect
+			isIncremental = journal.Incremental
+			isFull = journal.Parent == ""
+			if journal.Filter != nil {
+				// The same files have to be archived again
+				rules = *journal.Filter
+			}
+			fmt.Printf("🔁 Resuming upload of %s (%d parts, %s already uploaded)\n", strings.Join(backupPaths, ", "), len(journal.Parts), FormatBytes(journal.UploadedBytes()))
+		}
 
 		// Get tag from flag or prompt
-		tag, err := cmd.Flags().GetString("tag")
-		if err != nil || tag == "" {
+		if tag == "" && isStdin {
+			fmt.Println("❌ Backups read from stdin need --tag.")
+			return
+		}
+		if tag == "" {
 			tag, err = utils.PromptLine("🏷️  Enter a tag for this backup (e.g., 'unit' or 'prod'): ")
 			if err != nil || strings.TrimSpace(tag) == "" {
 				fmt.Println("❌ Invalid tag.")
 				return
 			}
 		}
+		if err := checkTag(tag); err != nil {
+			fmt.Println("❌", err)
+			return
+		}
 
 		// Get version from flag or generate
-		version, err := cmd.Flags().GetString("version")
-		if err != nil || version == "" {
+		if version == "" {
 			version = time.Now().Format("2006.01.02-15.04.05")
 		}
 
-		// Create backup
-		fmt.Printf("📦 Creating backup of %s...\n", backupPath)
-		start := time.Now()
-
-		// Create backup file
-		backupFile, err := CreateBackupFile(backupPath)
-		if err != nil {
-			fmt.Printf("❌ Failed to create backup file: %v\n", err)
-			return
+		extension := "tar"
+		if !isDirect {
+			extension = "obscure"
 		}
-		defer os.Remove(backupFile.Name())
-		defer backupFile.Close()
-
-		// Get file size
-		fileInfo, err := backupFile.Stat()
-		if err != nil {
-			fmt.Printf("❌ Failed to get file info: %v\n", err)
-			return
+		key := fmt.Sprintf("backups/%s/%s/%s_%s.%s", username, tag, version, tag, extension)
+		if isRepo {
+			key = repository.SnapshotKey(username, tag, version)
 		}
-		fileSize := fileInfo.Size()
-
-		// Prepare the backup data
-		var uploadReader io.Reader
-		var uploadSize int64
-		var extension string
 
-		if isDirect {
-			// For direct backups, use the tar file as is
-			uploadReader = backupFile
-			uploadSize = fileSize
-			extension = "tar"
-		} else {
-			// For encrypted backups, prompt for password and encrypt
-			fmt.Println("⚠️  WARNING: Keep your encryption password safe. If you lose it, you won't be able to recover your backup!")
-
-			password, err := utils.PromptPassword("🔐 Enter encryption password: ")
-			if err != nil || strings.TrimSpace(password) == "" {
-				fmt.Println("❌ Invalid or empty password.")
+		// Hooks run around everything that reads the source, so that the
+		// pre-backup hook can quiesce it first. Every return from here on
+		// is a failed backup unless backupErr is cleared.
+		hookCfg := backupHooks(cmd)
+		event := hooks.Event{Tag: tag, Version: version, Provider: providerKey, Source: strings.Join(backupPaths, ", ")}
+		if isAll {
+			event.Provider = "all"
+		}
+		if isRepo {
+			event.Key = key
+		}
+		backupErr := errors.New("backup aborted")
+		// Deferred before the post-backup hook, so that the hook still runs
+		exitCode := 0
+		defer func() {
+			if exitCode != 0 {
+				os.Exit(exitCode)
+			}
+		}()
+		if !isDryRun {
+			if err := hookCfg.Before(event); err != nil {
+				fmt.Println("❌ Backup aborted:", err)
+				if err := hookCfg.Failed(event, err); err != nil {
+					fmt.Println("⚠️ ", err)
+				}
 				return
 			}
+			defer func() {
+				if err := hookCfg.After(event, backupErr); err != nil {
+					fmt.Println("⚠️ ", err)
+				}
+			}()
+		}
 
-			// Ask for password confirmation
-			confirmPassword, err := utils.PromptPassword("🔐 Confirm encryption password: ")
-			if err != nil || strings.TrimSpace(confirmPassword) == "" {
-				fmt.Println("❌ Invalid or empty confirmation password.")
+		// Sources must exist before we prompt for a password. Several paths
+		// are archived side by side, each under its own directory.
+		var backupPath string
+		var sourceInfo os.FileInfo
+		var sources []manifest.Source
+		var archive archiveFunc
+		manifestKind := manifest.KindTar
+		description := strings.Join(backupPaths, ", ")
+		if isStdin {
+			archive = stdinArchive
+			manifestKind = manifest.KindFile
+			description = fmt.Sprintf("stdin (%s)", stdinName)
+		} else if len(backupPaths) > 1 {
+			sources, err = sourceRoots(backupPaths)
+			if err != nil {
+				fmt.Printf("❌ Failed to create backup file: %v\n", err)
 				return
 			}
-
-			if password != confirmPassword {
-				fmt.Println("❌ Passwords do not match. Please try again.")
+			archive = multiArchive(sources, rules)
+		} else {
+			backupPath = backupPaths[0]
+			sourceInfo, err = os.Stat(backupPath)
+			if err != nil {
+				fmt.Printf("❌ Failed to create backup file: %v\n", err)
 				return
 			}
+			archive = pathArchive(backupPath, rules)
+			if !sourceInfo.IsDir() {
+				manifestKind = manifest.KindFile
+			}
+		}
+		// Backups encrypted to public keys need no password, and this
+		// machine keeps nothing that could decrypt them afterwards
+		var wrappedKey *utils.WrappedKey
+		if len(recipients) > 0 && journal == nil {
+			if wrappedKey, err = utils.WrapKey(recipients); err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+		}
 
-			// Create a buffer to store encrypted data
-			var encryptedBuf bytes.Buffer
+		// The password is read once, when first needed: private names need
+		// it to find the parent of an incremental backup before the backup
+		// is planned, and to name backups that are not encrypted with it
+		askPassword := sync.OnceValues(func() (string, error) {
+			if isDirect || wrappedKey != nil {
+				return readPassword("🔐 Enter keyring password (for private names):", false)
+			}
+			// For encrypted backups, prompt for password unless it comes
+			// from a file, command, keyfile or the environment
+			if !passwordSource.Configured() {
+				fmt.Println("⚠️  WARNING: Keep your encryption password safe. If you lose it, you won't be able to recover your backup!")
+			}
+			return readPassword("🔐 Enter encryption password: ", true)
+		})
 
-			// Create encryption writer
-			encWriter, err := utils.EncryptStream(&encryptedBuf, password)
+		var plan *index.Plan
+		var increment index.Increment
+		if isIncremental {
+			if !sourceInfo.IsDir() {
+				fmt.Println("❌ Incremental backups need a directory.")
+				return
+			}
+			plan, increment, err = planIncrement(providerKey, username, tag, backupPath, isFull, rules, askPassword)
 			if err != nil {
-				fmt.Printf("❌ Failed to initialize encryption: %v\n", err)
+				fmt.Println("❌", err)
 				return
 			}
-
-			// Create compression writer
-			compWriter := utils.NewCompressWriter(encWriter)
-			defer compWriter.Close()
-
-			// Copy data through compression and encryption
-			if _, err := io.Copy(compWriter, backupFile); err != nil {
-				fmt.Printf("❌ Failed to compress and encrypt: %v\n", err)
+			if journal != nil && increment.Parent != journal.Parent {
+				fmt.Println("❌ The tag's index changed since the interrupted upload. Run `obscure uploads clean` and start a new backup.")
 				return
 			}
+			if increment.Parent == "" {
+				increment.Base = version
+				fmt.Printf("📇 Full backup: %d entries\n", len(plan.Changed))
+			} else {
+				fmt.Printf("📇 Incremental backup on top of %s: %d changed, %d deleted\n", increment.Parent, len(plan.Changed), len(plan.Deleted))
+			}
+			root := plan.Index.SourcePath
+			archive = func(w io.Writer) error {
+				return writeIncrementalArchive(root, plan, increment, w)
+			}
+		}
 
-			// Close writers in correct order
-			if err := compWriter.Close(); err != nil {
-				fmt.Printf("❌ Failed to close compression: %v\n", err)
+		if isDryRun {
+			if plan != nil {
+				for _, rel := range plan.Changed {
+					fmt.Println("   +", rel)
+				}
+				for _, rel := range plan.Deleted {
+					fmt.Println("   -", rel)
+				}
 				return
 			}
-			if err := encWriter.Close(); err != nil {
-				fmt.Printf("❌ Failed to finalize encryption: %v\n", err)
+			for _, source := range sources {
+				fmt.Printf("📂 %s -> %s/\n", source.Path, source.Root)
+				info, err := os.Stat(source.Path)
+				if err == nil {
+					err = previewBackup(source.Path, info, rules)
+				}
+				if err != nil {
+					fmt.Println("❌", err)
+					return
+				}
+			}
+			if sources != nil {
 				return
 			}
+			if err := previewBackup(backupPath, sourceInfo, rules); err != nil {
+				fmt.Println("❌", err)
+			}
+			return
+		}
 
-			uploadReader = bytes.NewReader(encryptedBuf.Bytes())
-			uploadSize = int64(encryptedBuf.Len())
-			extension = "obscure"
+		kdfParams, err := backupKDFParams()
+		if err != nil {
+			fmt.Println("❌", err)
+			return
 		}
 
-		filename := fmt.Sprintf("%s_%s.%s", version, tag, extension)
-		key := fmt.Sprintf("backups/%s/%s/%s", username, tag, filename)
+		var password string
+		if !isDirect && wrappedKey == nil {
+			password, err = askPassword()
+			if err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+		}
 
-		// Helper: upload without spinner
-		uploadFnNoSpinner := func(ctx context.Context, reader io.Reader, size int64, uploadFn func(io.Reader) error) error {
-			return uploadFn(reader)
+		if isRepo {
+			if backupErr = runRepositoryBackup(providerKey, username, backupPath, tag, version, password, rules); backupErr != nil {
+				fmt.Println("❌", backupErr)
+			}
+			return
 		}
 
-		uploadToProvider := func(providerKey string, config *cfg.CloudProviderConfig, suppressSpinner bool) error {
-			bucket := config.Bucket
-			switch providerKey {
-			case "s3":
-				ctx := context.Background()
-				awsCfg, err := strg.NewAWSClient(ctx, "s3")
-				if err != nil {
-					return fmt.Errorf("failed to initialize AWS client: %v", err)
+		// Create backup
+		fmt.Printf("📦 Creating backup of %s...\n", description)
+		start := time.Now()
+
+		metadata := map[string]string{
+			"username":  username,
+			"tag":       tag,
+			"version":   version,
+			"is_direct": fmt.Sprintf("%v", isDirect),
+		}
+		if isIncremental {
+			// Restore follows these to replay the chain
+			metadata["base"] = increment.Base
+			if increment.Parent != "" {
+				metadata["parent"] = increment.Parent
+			}
+		}
+
+		// Bytes uploaded by the last provider, and where it stored the
+		// backup, for the summary
+		var uploadSize int64
+		storedKey := key
+
+		uploadToProvider := func(providerKey string) error {
+			ctx := context.Background()
+			backend, err := strg.OpenBackend(ctx, providerKey)
+			if err != nil {
+				return fmt.Errorf("failed to initialize %s client: %v", strg.DisplayName(providerKey), err)
+			}
+			ring := lazyKeyring(ctx, backend, username, askPassword)
+			names, err := backupNamer(ctx, backend, username, ring)
+			if err != nil {
+				return err
+			}
+			key := names.Key(tag, version, extension)
+			event.Key = key
+			metadata, err := names.SealMetadata(metadata)
+			if err != nil {
+				return err
+			}
+			exists, err := strg.Exists(ctx, backend, key)
+			if err != nil {
+				return fmt.Errorf("failed to check if backup exists: %v", err)
+			}
+			if exists {
+				return fmt.Errorf("a backup with this name already exists")
+			}
+
+			// With a keyring in this bucket, the backup gets its own key
+			// sealed to the keyring instead of one derived from the password
+			backupKey := wrappedKey
+			if backupKey == nil && !isDirect && journal == nil {
+				if backupKey, err = keyringKey(ring); err != nil {
+					return fmt.Errorf("failed to unlock keyring: %v", err)
 				}
-				client := s3.NewFromConfig(*awsCfg)
-				_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
-					Bucket: aws.String(bucket),
-					Key:    aws.String(key),
-				})
-				if err == nil {
-					return fmt.Errorf("a backup with this name already exists")
+			}
+			if journal != nil && journal.KeySlots != "" {
+				if backupKey, err = journalKey(journal, ring); err != nil {
+					return fmt.Errorf("failed to unlock keyring: %v", err)
 				}
-				if suppressSpinner {
-					return uploadFnNoSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-						_, err := client.PutObject(ctx, &s3.PutObjectInput{
-							Bucket: aws.String(bucket),
-							Key:    aws.String(key),
-							Body:   reader,
-							Metadata: map[string]string{
-								"username":  username,
-								"tag":       tag,
-								"version":   version,
-								"is_direct": fmt.Sprintf("%v", isDirect),
-							},
-						})
-						return err
-					})
+			}
+
+			// S3-family uploads are journaled so that they can be resumed,
+			// unless they come from stdin, which cannot be read again, or are
+			// encrypted to public keys, whose key must not stay on this machine
+			multipart, isMultipart := backend.(strg.MultipartBackend)
+			uploadJournal := journal
+			if isMultipart && uploadJournal == nil && !isStdin && len(recipients) == 0 {
+				uploadJournal, err = newUploadJournal(providerKey, key, tag, version, backupPaths, isDirect, partSizeMiB*1024*1024, backupKey)
+				if err != nil {
+					return fmt.Errorf("failed to prepare upload journal: %v", err)
 				}
-				return uploadWithSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-					_, err := client.PutObject(ctx, &s3.PutObjectInput{
-						Bucket: aws.String(bucket),
-						Key:    aws.String(key),
-						Body:   reader,
-						Metadata: map[string]string{
-							"username":  username,
-							"tag":       tag,
-							"version":   version,
-							"is_direct": fmt.Sprintf("%v", isDirect),
-						},
-					})
+				uploadJournal.Incremental = isIncremental
+				uploadJournal.Parent = increment.Parent
+				uploadJournal.Filter = &rules
+				uploadJournal.KDF = &kdfParams
+			}
+			encOpts := utils.DefaultEncryptOptions()
+			encOpts.KDF = kdfParams
+			encOpts.Key = backupKey
+			if uploadJournal != nil {
+				if encOpts, err = journalEncryptOptions(uploadJournal, backupKey); err != nil {
 					return err
-				})
-			case "gcs":
-				ctx := context.Background()
-				client, err := strg.NewGCSClient(ctx, "gcs")
-				if err != nil {
-					return fmt.Errorf("failed to initialize GCS client: %v", err)
 				}
-				defer client.Close()
-				_, err = client.Bucket(bucket).Object(key).Attrs(ctx)
-				if err == nil {
-					return fmt.Errorf("a backup with this name already exists")
+			}
+
+			// Each provider gets its own run of the pipeline, so nothing has
+			// to be kept around between uploads
+			rec := manifest.NewRecorder(manifestKind)
+			stream := newBackupStream(recordedArchive(archive, rec), password, isDirect, encOpts)
+			defer stream.Close()
+			counter := &countingReader{reader: stream}
+
+			uploadFn := func(reader io.Reader) error {
+				var err error
+				if uploadJournal != nil {
+					err = multipart.PutMultipart(ctx, key, reader, metadata, uploadJournal.Options(parallel))
+				} else {
+					err = backend.Put(ctx, key, reader, -1, metadata)
 				}
-				if suppressSpinner {
-					return uploadFnNoSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-						writer := client.Bucket(bucket).Object(key).NewWriter(ctx)
-						writer.Metadata = map[string]string{
-							"username":  username,
-							"tag":       tag,
-							"version":   version,
-							"is_direct": fmt.Sprintf("%v", isDirect),
-						}
-						if _, err := io.Copy(writer, reader); err != nil {
-							return err
-						}
-						return writer.Close()
-					})
+				if err != nil && providerKey == "filebase-ipfs" && !isStdin && strings.Contains(strings.ToLower(err.Error()), "access denied") {
+					fmt.Print("\r\033[K")
+					fmt.Println("⚠️  Go SDK upload failed to IPFS - access denied. Trying AWS CLI fallback...")
+					rec = manifest.NewRecorder(manifestKind)
+					retryOpts := utils.DefaultEncryptOptions()
+					retryOpts.KDF = kdfParams
+					retryOpts.Key = backupKey
+					retry := newBackupStream(recordedArchive(archive, rec), password, isDirect, retryOpts)
+					defer retry.Close()
+					counter = &countingReader{reader: retry}
+					return uploadFilebaseWithAWSCLI(counter, key)
 				}
-				return uploadWithSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-					writer := client.Bucket(bucket).Object(key).NewWriter(ctx)
-					writer.Metadata = map[string]string{
-						"username":  username,
-						"tag":       tag,
-						"version":   version,
-						"is_direct": fmt.Sprintf("%v", isDirect),
-					}
-					if _, err := io.Copy(writer, reader); err != nil {
-						return err
+				return err
+			}
+			err = uploadWithSpinner(ctx, counter, -1, uploadFn)
+
+			if uploadJournal != nil {
+				if err == nil || uploadJournal.UploadID == "" {
+					uploadJournal.Remove()
+				} else if errors.Is(err, strg.ErrResumeMismatch) {
+					// The upload cannot go on without encrypting different data
+					// under nonces the provider may have seen, so it is dropped
+					if abortErr := multipart.AbortMultipartUpload(context.Background(), key, uploadJournal.UploadID); abortErr != nil {
+						return fmt.Errorf("%v: the source files or the password changed since the interrupted run. Run `obscure uploads clean` and start a new backup", err)
 					}
-					return writer.Close()
-				})
-			case "b2":
-				ctx := context.Background()
-				b2Client, err := strg.NewB2Client(ctx, "b2")
-				if err != nil {
-					return fmt.Errorf("failed to initialize B2 client: %v", err)
-				}
-				exists, err := b2Client.FileExists(ctx, key)
-				if err != nil {
-					return fmt.Errorf("failed to check if backup exists: %v", err)
-				}
-				if exists {
-					return fmt.Errorf("a backup with this name already exists")
-				}
-				if suppressSpinner {
-					return uploadFnNoSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-						return b2Client.UploadFile(ctx, key, reader, map[string]string{
-							"username":  username,
-							"tag":       tag,
-							"version":   version,
-							"is_direct": fmt.Sprintf("%v", isDirect),
-						})
-					})
-				}
-				return uploadWithSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-					return b2Client.UploadFile(ctx, key, reader, map[string]string{
-						"username":  username,
-						"tag":       tag,
-						"version":   version,
-						"is_direct": fmt.Sprintf("%v", isDirect),
-					})
-				})
-			case "idrive":
-				ctx := context.Background()
-				idriveClient, err := strg.NewIDriveClient(ctx, "idrive")
-				if err != nil {
-					return fmt.Errorf("failed to initialize IDrive E2 client: %v", err)
-				}
-				exists, err := idriveClient.FileExists(ctx, key)
-				if err != nil {
-					return fmt.Errorf("failed to check if backup exists: %v", err)
-				}
-				if exists {
-					return fmt.Errorf("a backup with this name already exists")
-				}
-				if suppressSpinner {
-					return uploadFnNoSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-						return idriveClient.UploadFile(ctx, key, reader, map[string]string{
-							"username":  username,
-							"tag":       tag,
-							"version":   version,
-							"is_direct": fmt.Sprintf("%v", isDirect),
-						})
-					})
+					uploadJournal.Remove()
+					return fmt.Errorf("%v: the source files or the password changed since the interrupted run. The upload was abandoned; start a new backup", err)
+				} else {
+					return fmt.Errorf("%v\n   %s of the upload is saved. Continue with `obscure backup --resume --tag %s --version %s` while %s is the active provider",
+						err, FormatBytes(uploadJournal.UploadedBytes()), tag, version, strg.DisplayName(providerKey))
 				}
-				return uploadWithSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-					return idriveClient.UploadFile(ctx, key, reader, map[string]string{
-						"username":  username,
-						"tag":       tag,
-						"version":   version,
-						"is_direct": fmt.Sprintf("%v", isDirect),
-					})
-				})
-			case "s3-compatible":
-				ctx := context.Background()
-				s3CompatibleClient, err := strg.NewS3CompatibleClient(ctx, "s3-compatible")
+			}
+			if err != nil {
+				return err
+			}
+			uploadSize = counter.Count()
+			storedKey = key
+
+			m := rec.Manifest()
+			m.Sources = sources
+			if isStdin {
+				m.Name = stdinName
+			}
+			if err := uploadManifest(ctx, backend, names, ring, tag, version, backupPath, m, password, backupKey); err != nil {
+				fmt.Printf("\n⚠️  Backup stored, but its manifest could not be uploaded to %s: %v\n", strg.DisplayName(providerKey), err)
+			}
+			return nil
+		}
+
+		if isAll {
+			ctx := context.Background()
+			prepare := func(providerKey string) (*fanOutTarget, error) {
+				backend, err := strg.OpenBackend(ctx, providerKey)
 				if err != nil {
-					return fmt.Errorf("failed to initialize S3-compatible client: %v", err)
+					return nil, fmt.Errorf("failed to initialize %s client: %v", strg.DisplayName(providerKey), err)
 				}
-				exists, err := s3CompatibleClient.FileExists(ctx, key)
+				ring := lazyKeyring(ctx, backend, username, askPassword)
+				names, err := backupNamer(ctx, backend, username, ring)
 				if err != nil {
-					return fmt.Errorf("failed to check if backup exists: %v", err)
-				}
-				if exists {
-					return fmt.Errorf("a backup with this name already exists")
-				}
-				if suppressSpinner {
-					return uploadFnNoSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-						return s3CompatibleClient.UploadFile(ctx, key, reader, map[string]string{
-							"username":  username,
-							"tag":       tag,
-							"version":   version,
-							"is_direct": fmt.Sprintf("%v", isDirect),
-						})
-					})
+					return nil, err
 				}
-				return uploadWithSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-					return s3CompatibleClient.UploadFile(ctx, key, reader, map[string]string{
-						"username":  username,
-						"tag":       tag,
-						"version":   version,
-						"is_direct": fmt.Sprintf("%v", isDirect),
-					})
-				})
-			case "storj":
-				ctx := context.Background()
-				storjClient, err := strg.NewStorjClient(ctx, "storj")
-				if err != nil {
-					return fmt.Errorf("failed to initialize Storj client: %v", err)
+				t := &fanOutTarget{provider: providerKey, backend: backend, names: names, unlock: ring, key: names.Key(tag, version, extension)}
+				if t.metadata, err = names.SealMetadata(metadata); err != nil {
+					return nil, err
 				}
-				exists, err := storjClient.FileExists(ctx, key)
+				exists, err := strg.Exists(ctx, backend, t.key)
 				if err != nil {
-					return fmt.Errorf("failed to check if backup exists: %v", err)
+					return nil, fmt.Errorf("failed to check if backup exists: %v", err)
 				}
 				if exists {
-					return fmt.Errorf("a backup with this name already exists")
-				}
-				if suppressSpinner {
-					return uploadFnNoSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-						return storjClient.UploadFile(ctx, key, reader, map[string]string{
-							"username":  username,
-							"tag":       tag,
-							"version":   version,
-							"is_direct": fmt.Sprintf("%v", isDirect),
-						})
-					})
+					return nil, fmt.Errorf("a backup with this name already exists")
 				}
-				return uploadWithSpinner(ctx, uploadReader, uploadSize, func(reader io.Reader) error {
-					return storjClient.UploadFile(ctx, key, reader, map[string]string{
-						"username":  username,
-						"tag":       tag,
-						"version":   version,
-						"is_direct": fmt.Sprintf("%v", isDirect),
-					})
-				})
-			case "filebase-ipfs":
-				ctx := context.Background()
-				s3CompatibleClient, err := strg.NewS3CompatibleClient(ctx, "filebase-ipfs")
-				if err != nil {
-					return fmt.Errorf("failed to initialize Filebase+IPFS client: %v", err)
+				if wrappedKey == nil && !isDirect {
+					k, err := ring()
+					if err != nil && !errors.Is(err, keyring.ErrNoKeyring) {
+						return nil, fmt.Errorf("failed to unlock keyring: %v", err)
+					}
+					if err == nil {
+						t.ring = k
+					}
 				}
-				exists, err := s3CompatibleClient.FileExists(ctx, key)
+				return t, nil
+			}
+
+			failed := make(map[string]error)
+			var targets []*fanOutTarget
+			for _, providerKey := range allProviders {
+				t, err := prepare(providerKey)
 				if err != nil {
-					return fmt.Errorf("failed to check if backup exists: %v", err)
+					failed[providerKey] = err
+					continue
 				}
-				if exists {
-					return fmt.Errorf("a backup with this name already exists")
+				targets = append(targets, t)
+			}
+			// Hooks get the object key only if every provider stores the
+			// backup under the same one
+			for i, t := range targets {
+				if i == 0 {
+					event.Key = t.key
+				} else if t.key != event.Key {
+					event.Key = ""
+					break
 				}
-				uploadFn := func(reader io.Reader) error {
-					err := s3CompatibleClient.UploadFile(ctx, key, reader, map[string]string{
-						"username":  username,
-						"tag":       tag,
-						"version":   version,
-						"is_direct": fmt.Sprintf("%v", isDirect),
-					})
-					if err != nil && strings.Contains(strings.ToLower(err.Error()), "access denied") {
-						fmt.Print("\r\033[K")
-						fmt.Println("⚠️  Go SDK upload failed to IPFS - access denied. Trying AWS CLI fallback...")
-						// Save the file to a temp location for CLI upload
-						tmpPath := "obscure_tmp_upload_file"
-						f, ferr := os.Create(tmpPath)
-						if ferr != nil {
-							return fmt.Errorf("failed to create temp file for AWS CLI upload: %v", ferr)
-						}
-						// Prepare reader for AWS CLI upload
-						var readerForCLI io.Reader = reader
-						if seeker, ok := reader.(io.Seeker); ok {
-							seeker.Seek(0, io.SeekStart)
-						} else if buf, ok := reader.(*bytes.Buffer); ok {
-							readerForCLI = bytes.NewReader(buf.Bytes())
-						}
-						_, ferr = io.Copy(f, readerForCLI)
-						f.Close()
-						if ferr != nil {
-							os.Remove(tmpPath)
-							return fmt.Errorf("failed to write temp file for AWS CLI upload: %v", ferr)
-						}
-						providerConfig, _ := cfg.GetProviderConfig("filebase-ipfs")
-						err = uploadWithAWSCLI(tmpPath, providerConfig.Bucket, key, providerConfig.Region, providerConfig.FilebaseEndpoint, providerConfig.AccessKeyID, providerConfig.SecretAccessKey)
-						os.Remove(tmpPath)
-						if err != nil {
-							return fmt.Errorf("AWS CLI upload failed: %v", err)
-						}
-						fmt.Println("✅ Backup uploaded using AWS CLI fallback.")
-						return nil
-					}
-					return err
+			}
+
+			if len(targets) > 0 {
+				encOpts := utils.DefaultEncryptOptions()
+				encOpts.KDF = kdfParams
+				encOpts.Key = wrappedKey
+				fan := &fanOut{
+					archive:    archive,
+					kind:       manifestKind,
+					password:   password,
+					isDirect:   isDirect,
+					encOpts:    encOpts,
+					tag:        tag,
+					version:    version,
+					source:     backupPath,
+					sources:    sources,
+					retries:    retries,
+					retryDelay: retryDelay,
 				}
-				if suppressSpinner {
-					return uploadFnNoSpinner(ctx, uploadReader, uploadSize, uploadFn)
+				if isStdin {
+					fan.name = stdinName
 				}
-				return uploadWithSpinner(ctx, uploadReader, uploadSize, uploadFn)
-			default:
-				return fmt.Errorf("unsupported provider: %s", providerKey)
+				fmt.Printf("☁️  Uploading to %d providers...\n", len(targets))
+				fan.run(ctx, targets)
 			}
-		}
 
-		supportedProviders := map[string]bool{
-			"s3":            true,
-			"gcs":           true,
-			"b2":            true,
-			"idrive":        true,
-			"s3-compatible": true,
-			"storj":         true,
-			"filebase-ipfs": true,
-		}
-
-		if isAll {
-			results := make(map[string]error)
-			var providerList []string
-			var configList []*cfg.CloudProviderConfig
-			for key, config := range providers.Providers {
-				if !supportedProviders[key] || !config.Enabled {
-					continue
-				}
-				isComplete, _ := cfg.IsProviderConfigComplete(config)
-				if !isComplete {
-					continue
+			stored := printFanOutResults(targets, failed)
+			for _, t := range targets {
+				if t.err == nil {
+					uploadSize = max(uploadSize, t.size)
 				}
-				providerList = append(providerList, key)
-				configList = append(configList, config)
 			}
-			if len(providerList) == 0 {
-				fmt.Println("❌ No enabled and fully configured providers found.")
+			if stored < quorum {
+				backupErr = fmt.Errorf("backup stored on %d of %d providers, %d required", stored, len(allProviders), quorum)
+				fmt.Println("\n❌", backupErr)
+				exitCode = 1
 				return
 			}
-			// Single spinner for all uploads
-			var wg sync.WaitGroup
-			done := make(chan struct{})
-			wg.Add(1)
-			go func() {
-				spinnerRunes := []rune{'⠋', '⠙', '⠹', '⠸', '⠼', '⠴', '⠦', '⠧', '⠇', '⠏'}
-				defer wg.Done()
-				for {
-					select {
-					case <-done:
-						return
-					default:
-						for _, r := range spinnerRunes {
-							fmt.Printf("\r☁️ Uploading to all enabled cloud providers... %s", string(r))
-							time.Sleep(100 * time.Millisecond)
-						}
-					}
-				}
-			}()
-			// Perform uploads (sequentially)
-			for i, key := range providerList {
-				results[key] = uploadToProvider(key, configList[i], true)
-			}
-			close(done)
-			wg.Wait()
-			fmt.Print("\r\033[K") // Clear spinner line
-			fmt.Println("\n📊 Upload results:")
-			for key, err := range results {
-				if err == nil {
-					fmt.Printf("✅ %s: Success\n", strings.ToUpper(key))
-				} else {
-					fmt.Printf("❌ %s: %v\n", strings.ToUpper(key), err)
-				}
-			}
+			backupErr = nil
 			elapsed := time.Since(start)
 			fmt.Printf("\n✅ Backup completed in %s\n", elapsed.Round(time.Millisecond))
+			if stored < len(allProviders) {
+				fmt.Printf("⚠️  Stored on %d of %d providers, which meets the quorum of %d.\n", stored, len(allProviders), quorum)
+			}
 			fmt.Printf("📊 File size: %s\n", FormatBytes(uploadSize))
 			return
 		}
@@ -696,14 +1423,22 @@ var backupCmd = &cobra.Command{
 			fmt.Printf("❌ Provider %s is not configured or disabled\n", strings.ToUpper(providerKey))
 			return
 		}
-		if err := uploadToProvider(providerKey, config, false); err != nil {
-			fmt.Printf("❌ Failed to upload: %v\n", err)
+		if backupErr = uploadToProvider(providerKey); backupErr != nil {
+			fmt.Printf("❌ Failed to upload: %v\n", backupErr)
 			return
 		}
+		if plan != nil {
+			// The index only moves forward once the increment is stored
+			plan.Index.Version = version
+			plan.Index.Base = increment.Base
+			if err := plan.Index.Save(); err != nil {
+				fmt.Printf("⚠️  Failed to save the file index, the next incremental backup will be full: %v\n", err)
+			}
+		}
 		elapsed := time.Since(start)
 		fmt.Printf("✅ Backup completed in %s\n", elapsed.Round(time.Millisecond))
 		fmt.Printf("📊 File size: %s\n", FormatBytes(uploadSize))
-		fmt.Printf("🔗 Backup path: %s\n", key)
+		fmt.Printf("🔗 Backup path: %s\n", storedKey)
 	},
 }
 
@@ -713,4 +1448,22 @@ func init() {
 	backupCmd.Flags().StringP("version", "v", "", "Version for the backup (e.g., '2.1' or '1.0')")
 	backupCmd.Flags().BoolP("direct", "d", false, "Create an unencrypted tar backup (default is encrypted .obscure format)")
 	backupCmd.Flags().BoolP("all", "a", false, "Upload to all enabled cloud providers")
+	backupCmd.Flags().Int("quorum", 0, "With --all, how many providers have to store the backup for it to succeed (default: all)")
+	backupCmd.Flags().Int("retries", 2, "With --all, how often an upload that failed is retried")
+	backupCmd.Flags().Duration("retry-delay", 2*time.Second, "With --all, how long to wait before the first retry; doubled for each one after")
+	backupCmd.Flags().Bool("resume", false, "Continue an interrupted upload")
+	backupCmd.Flags().Int64("part-size", strg.DefaultPartSize/(1024*1024), "Multipart upload part size in MiB (S3-family providers)")
+	backupCmd.Flags().Int("parallel", strg.DefaultUploadConcurrency, "Number of parts uploaded in parallel (S3-family providers)")
+	backupCmd.Flags().Bool("repo", false, "Store the backup in the deduplicating chunk repository")
+	backupCmd.Flags().Bool("incremental", false, "Upload only files changed since the previous incremental backup of the tag")
+	backupCmd.Flags().Bool("full", false, "With --incremental, start a new chain with a full backup")
+	backupCmd.Flags().StringArray("exclude", nil, "Skip paths matching this glob (repeatable)")
+	backupCmd.Flags().StringArray("include", nil, "Only back up paths matching this glob (repeatable)")
+	backupCmd.Flags().String("max-file-size", "", "Skip files larger than this size (e.g. 500MB)")
+	backupCmd.Flags().Bool("one-file-system", false, "Do not cross into other mounted filesystems")
+	backupCmd.Flags().Bool("dry-run", false, "List what would be backed up without uploading anything")
+	backupCmd.Flags().String("name", "stdin", "File name recorded for a backup read from stdin (-)")
+	addHookFlags(backupCmd)
+	addPasswordFlags(backupCmd)
+	addRecipientFlags(backupCmd)
 }
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shah1011/obscure/internal/index"
+	"github.com/shah1011/obscure/utils"
+)
+
+func TestIncrementalArchiveReproducible(t *testing.T) {
+	root := t.TempDir()
+	for name, data := range map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan := &index.Plan{Changed: []string{"a.txt", "sub", "sub/b.txt"}}
+	increment := index.Increment{Parent: "1", Base: "1", Deleted: []string{"gone.txt"}}
+	archive := func(w io.Writer) error { return writeIncrementalArchive(root, plan, increment, w) }
+
+	// A resumed upload rebuilds the stream from its journal
+	journal, err := newUploadJournal("aws", "key", "tag", "2", []string{root}, false, 5<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf := utils.Argon2idParams(64, 1, 1)
+	journal.KDF = &kdf
+	opts, err := journalEncryptOptions(journal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second bytes.Buffer
+	if err := writeBackupStream(&first, archive, "password", false, opts); err != nil {
+		t.Fatal(err)
+	}
+	// Past the one-second resolution of tar mtimes
+	time.Sleep(1100 * time.Millisecond)
+	if err := writeBackupStream(&second, archive, "password", false, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("the incremental backup stream differs between runs")
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{in: "0", want: 0, ok: true},
+		{in: "1048576", want: 1 << 20, ok: true},
+		{in: "100B", want: 100, ok: true},
+		{in: "512K", want: 512 << 10, ok: true},
+		{in: "512KB", want: 512 << 10, ok: true},
+		{in: "100m", want: 100 << 20, ok: true},
+		{in: "1.5 GB", want: 3 << 29, ok: true},
+		{in: "2GiB", want: 2 << 30, ok: true},
+		{in: "3T", want: 3 << 40, ok: true},
+		{in: " 7 tib ", want: 7 << 40, ok: true},
+		{in: "", ok: false},
+		{in: "MB", ok: false},
+		{in: "-5MB", ok: false},
+		{in: "5XB", ok: false},
+		{in: "5 MBs", ok: false},
+		{in: "1KIBB", ok: false},
+		{in: "8388608T", ok: false},
+		{in: "99999999999999999999", ok: false},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("ParseBytes(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ParseBytes(%q) = %d; want an error", tt.in, got)
+		}
+	}
+}
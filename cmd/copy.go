@@ -0,0 +1,417 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/keyring"
+	"github.com/shah1011/obscure/internal/repository"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// copyOptions controls how copyObjects transfers objects
+type copyOptions struct {
+	parallel int
+	// checksum compares the SHA-256 of objects present on both sides
+	// instead of only their size, which downloads both copies
+	checksum bool
+	dryRun   bool
+}
+
+// copyResult tallies what copyObjects did
+type copyResult struct {
+	copied  int
+	skipped int
+	failed  int
+	// conflicts are keyrings or repository configurations that differ
+	// between the providers and were kept
+	conflicts int
+	bytes     int64
+}
+
+// openProvider opens a provider that is configured, enabled and complete
+func openProvider(ctx context.Context, providers *cfg.UserProviders, providerKey string) (strg.Backend, error) {
+	config, ok := providers.Providers[providerKey]
+	if !ok || !strg.IsRegistered(providerKey) {
+		return nil, fmt.Errorf("provider %s is not configured", providerKey)
+	}
+	if !config.Enabled {
+		return nil, fmt.Errorf("provider %s is disabled", providerKey)
+	}
+	if complete, missing := cfg.IsProviderConfigComplete(config); !complete {
+		return nil, fmt.Errorf("provider %s is missing %s", providerKey, strings.Join(missing, ", "))
+	}
+	backend, err := strg.OpenBackend(ctx, providerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize %s client: %v", strg.DisplayName(providerKey), err)
+	}
+	return backend, nil
+}
+
+// copyEndpoints reads --from and --to and opens both providers. --from
+// defaults to the active provider.
+func copyEndpoints(ctx context.Context, cmd *cobra.Command) (from, to string, src, dst strg.Backend, ok bool) {
+	from, _ = cmd.Flags().GetString("from")
+	to, _ = cmd.Flags().GetString("to")
+	if from == "" {
+		var err error
+		from, err = cfg.GetSessionProvider()
+		if err != nil || from == "" {
+			from, err = cfg.GetUserDefaultProvider()
+			if err != nil || from == "" {
+				fmt.Println("⚠️  No cloud provider is configured.")
+				return "", "", nil, nil, false
+			}
+		}
+	}
+	if to == "" {
+		fmt.Println("❌ Name the provider to copy to with --to.")
+		return "", "", nil, nil, false
+	}
+	if from == to {
+		fmt.Println("❌ --from and --to are the same provider.")
+		return "", "", nil, nil, false
+	}
+
+	providers, err := cfg.LoadUserProviders()
+	if err != nil {
+		fmt.Printf("❌ Failed to load provider configuration: %v\n", err)
+		return "", "", nil, nil, false
+	}
+	if src, err = openProvider(ctx, providers, from); err != nil {
+		fmt.Println("❌", err)
+		return "", "", nil, nil, false
+	}
+	if dst, err = openProvider(ctx, providers, to); err != nil {
+		fmt.Println("❌", err)
+		return "", "", nil, nil, false
+	}
+	return from, to, src, dst, true
+}
+
+// copyOptionsFlags reads the flags registered by addCopyFlags
+func copyOptionsFlags(cmd *cobra.Command) copyOptions {
+	var opts copyOptions
+	opts.parallel, _ = cmd.Flags().GetInt("parallel")
+	opts.checksum, _ = cmd.Flags().GetBool("checksum")
+	opts.dryRun, _ = cmd.Flags().GetBool("dry-run")
+	if opts.parallel < 1 {
+		opts.parallel = 1
+	}
+	return opts
+}
+
+// addCopyFlags registers the flags shared by copy and migrate
+func addCopyFlags(cmd *cobra.Command) {
+	cmd.Flags().String("from", "", "Provider to copy from (default: the active provider)")
+	cmd.Flags().String("to", "", "Provider to copy to")
+	cmd.Flags().Int("parallel", 4, "Number of objects transferred at once")
+	cmd.Flags().Bool("checksum", false, "Compare the SHA-256 of objects both providers hold, not just their size (downloads both)")
+	cmd.Flags().Bool("dry-run", false, "List what would be copied without copying anything")
+}
+
+// copyObjects copies objects from src to dst, several at a time, and prints
+// each one as it completes. Objects dst already holds with the same size
+// (and, with checksum, the same content) are skipped; others are replaced.
+// The keyring and the repository's configuration are never replaced, since
+// the backups already in dst depend on them.
+func copyObjects(ctx context.Context, src, dst strg.Backend, username string, objects []strg.ObjectInfo, opts copyOptions) (copyResult, error) {
+	existing, err := dst.List(ctx, fmt.Sprintf("backups/%s/", username))
+	if err != nil {
+		return copyResult{}, fmt.Errorf("failed to list the destination: %w", err)
+	}
+	sizes := make(map[string]int64, len(existing))
+	for _, obj := range existing {
+		sizes[obj.Key] = obj.Size
+	}
+	protected := map[string]bool{
+		keyring.Key(username):                        true,
+		repository.ChunksPrefix(username) + "config": true,
+	}
+
+	var result copyResult
+	var mu sync.Mutex
+	done := 0
+	report := func(outcome func(*copyResult), format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		outcome(&result)
+		fmt.Printf("[%d/%d] "+format+"\n", append([]any{done, len(objects)}, args...)...)
+	}
+
+	jobs := make(chan strg.ObjectInfo)
+	var wg sync.WaitGroup
+	for range opts.parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range jobs {
+				size, present := sizes[obj.Key]
+				if present && (size == obj.Size || protected[obj.Key]) {
+					same := size == obj.Size
+					if same && (opts.checksum || protected[obj.Key]) {
+						var err error
+						if same, err = sameContent(ctx, src, dst, obj.Key); err != nil {
+							report(func(r *copyResult) { r.failed++ }, "❌ %s: %v", obj.Key, err)
+							continue
+						}
+					}
+					if same {
+						report(func(r *copyResult) { r.skipped++ }, "⏭️  %s (already there)", obj.Key)
+						continue
+					}
+					if protected[obj.Key] {
+						report(func(r *copyResult) { r.conflicts++ }, "⚠️  %s differs and was kept; backups copied here that need the source's keyring or repository cannot be read with it", obj.Key)
+						continue
+					}
+				}
+				if opts.dryRun {
+					report(func(r *copyResult) { r.copied++; r.bytes += obj.Size }, "📝 %s (%s) would be copied", obj.Key, FormatBytes(obj.Size))
+					continue
+				}
+				copied, err := strg.CopyObject(ctx, src, dst, obj.Key)
+				if err != nil {
+					report(func(r *copyResult) { r.failed++ }, "❌ %v", err)
+					continue
+				}
+				report(func(r *copyResult) { r.copied++; r.bytes += copied }, "✅ %s (%s)", obj.Key, FormatBytes(copied))
+			}
+		}()
+	}
+	for _, obj := range objects {
+		jobs <- obj
+	}
+	close(jobs)
+	wg.Wait()
+	return result, nil
+}
+
+// sameContent compares the SHA-256 of key in src and dst
+func sameContent(ctx context.Context, src, dst strg.Backend, key string) (bool, error) {
+	srcHash, err := strg.ObjectHash(ctx, src, key)
+	if err != nil {
+		return false, err
+	}
+	dstHash, err := strg.ObjectHash(ctx, dst, key)
+	if err != nil {
+		return false, err
+	}
+	return srcHash == dstHash, nil
+}
+
+// printCopyResult prints the summary of a copy
+func printCopyResult(result copyResult, to string, elapsed time.Duration, dryRun bool) {
+	if dryRun {
+		fmt.Printf("\n📝 Dry run: %d objects (%s) would be copied to %s, %d are already there.\n",
+			result.copied, FormatBytes(result.bytes), strg.DisplayName(to), result.skipped)
+		if result.conflicts > 0 {
+			fmt.Printf("⚠️  %d keyring or repository objects differ between the providers and would be kept.\n", result.conflicts)
+		}
+		return
+	}
+	rate := ""
+	if seconds := elapsed.Seconds(); seconds > 0 && result.bytes > 0 {
+		rate = fmt.Sprintf(", %s/s", FormatBytes(int64(float64(result.bytes)/seconds)))
+	}
+	fmt.Printf("\n📊 Copied %d objects (%s) to %s in %s%s; %d already there, %d failed.\n",
+		result.copied, FormatBytes(result.bytes), strg.DisplayName(to), elapsed.Round(time.Millisecond), rate, result.skipped, result.failed)
+	if result.conflicts > 0 {
+		fmt.Printf("⚠️  %d keyring or repository objects differ between the providers and were kept.\n", result.conflicts)
+	}
+}
+
+// selectBackups returns the objects of tag (and version, if set) in src:
+// backups, manifests and snapshots, the keyring they may be sealed to, and
+// the chunk repository when a snapshot needs it
+func selectBackups(ctx context.Context, src strg.Backend, username, tag, version string) ([]strg.ObjectInfo, error) {
+	// Private names can only be matched to tags with the keyring
+	password := sync.OnceValues(func() (string, error) {
+		return readPassword("🔐 Enter decryption password:", false)
+	})
+	names, err := backupNamer(ctx, src, username, lazyKeyring(ctx, src, username, password))
+	if err != nil {
+		return nil, err
+	}
+	backups, err := listBackups(ctx, src, names, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []strg.ObjectInfo
+	needsChunks := false
+	for _, backup := range backups {
+		if version != "" && backup.Version != version {
+			continue
+		}
+		objects = append(objects, backup.ObjectInfo)
+		needsChunks = needsChunks || backup.Extension == repository.SnapshotExtension
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	if info, err := src.Stat(ctx, keyring.Key(username)); err == nil {
+		objects = append(objects, *info)
+	}
+	if needsChunks {
+		chunks, err := src.List(ctx, repository.ChunksPrefix(username))
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, chunks...)
+	}
+	return objects, nil
+}
+
+var copyCmd = &cobra.Command{
+	Use:   "copy --to <provider>",
+	Short: "Copy backups from one provider to another",
+	Long: `Copy backups between two configured providers. Objects are streamed as they
+are, with their metadata, so nothing is decrypted on the way and no password
+is needed unless the backups have private names.
+
+Without --tag every object of the user is copied: backups, manifests, the
+keyring and the chunk repository. --tag and --version narrow that down to
+some backups; the keyring is copied along with them, and the chunk
+repository when a repository snapshot is among them.
+  obscure copy --from b2 --to s3
+  obscure copy --from b2 --to s3 --tag db --version 2024.05.01-03.00.00
+
+Objects the destination already holds with the same size are skipped, so an
+interrupted copy can simply be run again; --checksum compares their content
+too. A keyring already in the destination is never replaced.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		tag, _ := cmd.Flags().GetString("tag")
+		version, _ := cmd.Flags().GetString("version")
+		if version != "" && tag == "" {
+			fmt.Println("❌ --version needs --tag.")
+			return
+		}
+		if err := checkTag(tag); err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		username, err := cfg.GetSessionUsername()
+		if err != nil || username == "" {
+			fmt.Println("❌ Not logged in. Please run `obscure login` or `obscure signup`.")
+			return
+		}
+		ctx := context.Background()
+		from, to, src, dst, ok := copyEndpoints(ctx, cmd)
+		if !ok {
+			return
+		}
+		opts := copyOptionsFlags(cmd)
+
+		var objects []strg.ObjectInfo
+		if tag == "" {
+			objects, err = src.List(ctx, fmt.Sprintf("backups/%s/", username))
+		} else {
+			objects, err = selectBackups(ctx, src, username, tag, version)
+		}
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		if len(objects) == 0 {
+			fmt.Printf("📦 Nothing to copy from %s.\n", strg.DisplayName(from))
+			return
+		}
+
+		fmt.Printf("📦 Copying %d objects from %s to %s...\n", len(objects), strg.DisplayName(from), strg.DisplayName(to))
+		start := time.Now()
+		result, err := copyObjects(ctx, src, dst, username, objects, opts)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		printCopyResult(result, to, time.Since(start), opts.dryRun)
+		if result.failed > 0 {
+			fmt.Println("⚠️  Run the same command again to retry the failed objects.")
+		}
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate --to <provider>",
+	Short: "Move all backups to another provider and make it the default",
+	Long: `Copy every object of the user from one provider to another, as 'obscure
+copy' without --tag does, then make the destination the active and default
+provider once everything is there. It does not switch if the destination
+already holds a different keyring or chunk repository, which the copied
+backups may not open with.
+
+Nothing is deleted from the old provider; remove the backups there once you
+no longer need them. An interrupted migration can be run again and skips
+what was already copied.
+  obscure migrate --from b2 --to s3`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		username, err := cfg.GetSessionUsername()
+		if err != nil || username == "" {
+			fmt.Println("❌ Not logged in. Please run `obscure login` or `obscure signup`.")
+			return
+		}
+		ctx := context.Background()
+		from, to, src, dst, ok := copyEndpoints(ctx, cmd)
+		if !ok {
+			return
+		}
+		opts := copyOptionsFlags(cmd)
+
+		objects, err := src.List(ctx, fmt.Sprintf("backups/%s/", username))
+		if err != nil {
+			printProviderListError(from, err)
+			return
+		}
+		fmt.Printf("🚚 Migrating %d objects from %s to %s...\n", len(objects), strg.DisplayName(from), strg.DisplayName(to))
+		start := time.Now()
+		result, err := copyObjects(ctx, src, dst, username, objects, opts)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		printCopyResult(result, to, time.Since(start), opts.dryRun)
+		if opts.dryRun {
+			return
+		}
+		if result.failed > 0 {
+			fmt.Printf("⚠️  %s stays the active provider until every object is copied. Run the same command again to retry.\n", strg.DisplayName(from))
+			return
+		}
+		if result.conflicts > 0 {
+			// The copied backups may not open with the destination's keyring
+			// or repository, so switching could lock the user out of them
+			fmt.Printf("❌ %s stays the active provider: %s already has a different keyring or repository. Move or remove it there, then run the same command again.\n", strg.DisplayName(from), strg.DisplayName(to))
+			return
+		}
+
+		if err := cfg.SetSessionProvider(to); err != nil {
+			fmt.Println("❌ Failed to switch the active provider:", err)
+			return
+		}
+		if err := cfg.SetUserDefaultProvider(to); err != nil {
+			fmt.Println("❌ Failed to set the default provider:", err)
+			return
+		}
+		fmt.Printf("✅ %s is now the active and default provider.\n", strg.DisplayName(to))
+		fmt.Printf("ℹ️  The backups are still in %s; delete them there once you no longer need them.\n", strg.DisplayName(from))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(copyCmd)
+	rootCmd.AddCommand(migrateCmd)
+	addCopyFlags(copyCmd)
+	addCopyFlags(migrateCmd)
+	copyCmd.Flags().StringP("tag", "t", "", "Only copy the backups of this tag")
+	copyCmd.Flags().StringP("version", "v", "", "With --tag, only copy this version")
+	addPasswordFlags(copyCmd)
+}
@@ -0,0 +1,392 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/keyring"
+	"github.com/shah1011/obscure/internal/manifest"
+	"github.com/shah1011/obscure/internal/naming"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/shah1011/obscure/utils"
+)
+
+// enabledProviders returns the providers --all uploads to: registered,
+// enabled and fully configured, in a stable order
+func enabledProviders(providers *cfg.UserProviders) []string {
+	var keys []string
+	for key, config := range providers.Providers {
+		if !strg.IsRegistered(key) || !config.Enabled {
+			continue
+		}
+		if complete, _ := cfg.IsProviderConfigComplete(config); !complete {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fanOutTarget is one provider a backup is uploaded to with --all, and the
+// outcome of the upload
+type fanOutTarget struct {
+	provider string
+	backend  strg.Backend
+	names    *naming.Namer
+	key      string
+	metadata map[string]string
+	// ring seals the backup's key in this bucket; nil if the bucket has no
+	// keyring or the backup is not encrypted with one
+	ring *keyring.Keyring
+	// unlock opens the bucket's keyring, which seals the manifest of a
+	// direct backup with private names
+	unlock func() (*keyring.Keyring, error)
+	// awsCLI uploads through the AWS CLI, after Filebase IPFS denied the SDK
+	awsCLI bool
+
+	size     int64
+	attempts int
+	elapsed  time.Duration
+	err      error
+	warning  error
+}
+
+// upload stores the backup read from r
+func (t *fanOutTarget) upload(ctx context.Context, r io.Reader) error {
+	if t.awsCLI {
+		return uploadFilebaseWithAWSCLI(r, t.key)
+	}
+	return t.backend.Put(ctx, t.key, r, -1, t.metadata)
+}
+
+// spool keeps the backup stream in a temporary file while it is written,
+// so that every upload reads it at its own pace, and one that failed can
+// start over from the beginning, even when the backup comes from stdin
+type spool struct {
+	file *os.File
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	size      int64
+	done      bool
+	err       error
+	abandoned bool
+}
+
+var errSpoolAbandoned = errors.New("every upload is over")
+
+func newSpool() (*spool, error) {
+	file, err := os.CreateTemp("", "obscure-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{file: file}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// Write appends to the spool; it fails once the spool is abandoned
+func (s *spool) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	abandoned, offset := s.abandoned, s.size
+	s.mu.Unlock()
+	if abandoned {
+		return 0, errSpoolAbandoned
+	}
+
+	n, err := s.file.WriteAt(p, offset)
+	s.mu.Lock()
+	s.size += int64(n)
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return n, err
+}
+
+// finish marks the end of the stream, or that producing it failed
+func (s *spool) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done, s.err = true, err
+	s.cond.Broadcast()
+}
+
+// failure returns the error the stream failed with, if it did
+func (s *spool) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.abandoned {
+		return nil
+	}
+	return s.err
+}
+
+// abandon stops the writer and the readers that are still waiting
+func (s *spool) abandon() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abandoned = true
+	s.cond.Broadcast()
+}
+
+// remove deletes the spool file
+func (s *spool) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// reader returns a reader of the whole stream that waits for data that is
+// not written yet
+func (s *spool) reader() io.Reader {
+	return &spoolReader{spool: s}
+}
+
+type spoolReader struct {
+	spool  *spool
+	offset int64
+}
+
+func (r *spoolReader) Read(p []byte) (int, error) {
+	s := r.spool
+	s.mu.Lock()
+	for r.offset >= s.size && !s.done && !s.abandoned {
+		s.cond.Wait()
+	}
+	available := s.size - r.offset
+	done, err, abandoned := s.done, s.err, s.abandoned
+	s.mu.Unlock()
+
+	if available == 0 {
+		switch {
+		case err != nil:
+			return 0, err
+		case done:
+			return 0, io.EOF
+		case abandoned:
+			return 0, errSpoolAbandoned
+		}
+	}
+	n, readErr := s.file.ReadAt(p[:min(int64(len(p)), available)], r.offset)
+	r.offset += int64(n)
+	if readErr == io.EOF {
+		readErr = nil
+	}
+	return n, readErr
+}
+
+// fanOut uploads one backup to several providers at once
+type fanOut struct {
+	archive  archiveFunc
+	kind     manifest.Kind
+	password string
+	isDirect bool
+	// encOpts carries the KDF and, for --recipient, the wrapped key
+	encOpts utils.EncryptOptions
+
+	tag     string
+	version string
+	source  string
+	sources []manifest.Source
+	name    string
+
+	retries    int
+	retryDelay time.Duration
+
+	mu sync.Mutex
+}
+
+func (f *fanOut) printf(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Printf(format, args...)
+}
+
+// run archives and encrypts the backup once, into a spool file that every
+// target uploads from concurrently, so the slowest provider holds up no
+// other. A target that fails is retried on its own with exponential
+// backoff.
+func (f *fanOut) run(ctx context.Context, targets []*fanOutTarget) {
+	start := time.Now()
+	encOpts, err := f.backupKey(targets)
+	if err == nil {
+		var s *spool
+		if s, err = newSpool(); err == nil {
+			defer s.remove()
+			f.upload(ctx, s, targets, encOpts, start)
+			return
+		}
+		err = fmt.Errorf("failed to create spool file: %v", err)
+	}
+	for _, t := range targets {
+		if t.err == nil {
+			t.err = err
+		}
+		t.elapsed = time.Since(start)
+	}
+}
+
+// backupKey returns the encryption options for the backup. A backup that is
+// neither --direct nor encrypted to public keys gets a key of its own when
+// the buckets have keyrings, sealed to each distinct keyring so that it opens
+// in every bucket. A bucket without a keyring could not open it and fails.
+func (f *fanOut) backupKey(targets []*fanOutTarget) (utils.EncryptOptions, error) {
+	encOpts := f.encOpts
+	if f.isDirect || encOpts.Key != nil {
+		return encOpts, nil
+	}
+	sealed := make(map[string]bool)
+	for _, t := range targets {
+		if t.ring == nil {
+			continue
+		}
+		active := t.ring.Active()
+		id := hex.EncodeToString(active.ID)
+		if sealed[id] {
+			continue
+		}
+		if encOpts.Key == nil {
+			key, err := t.ring.WrapKey()
+			if err != nil {
+				return encOpts, fmt.Errorf("failed to unlock keyring: %v", err)
+			}
+			encOpts.Key = key
+		} else if err := encOpts.Key.AddMasterSlot(active.ID, active.Key); err != nil {
+			return encOpts, fmt.Errorf("failed to seal the backup key: %v", err)
+		}
+		sealed[id] = true
+	}
+	if encOpts.Key != nil {
+		for _, t := range targets {
+			if t.ring == nil {
+				t.err = errors.New("this bucket has no keyring, but the backup key is sealed to the keyrings of the other providers; add one with `obscure key add-password`")
+			}
+		}
+	}
+	return encOpts, nil
+}
+
+// upload runs the backup pipeline into s while every target uploads from it
+func (f *fanOut) upload(ctx context.Context, s *spool, targets []*fanOutTarget, encOpts utils.EncryptOptions, start time.Time) {
+	rec := manifest.NewRecorder(f.kind)
+	pipeline := make(chan struct{})
+	go func() {
+		defer close(pipeline)
+		s.finish(writeBackupStream(s, recordedArchive(f.archive, rec), f.password, f.isDirect, encOpts))
+	}()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		if t.err != nil {
+			t.elapsed = time.Since(start)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.attempt(ctx, s, t, encOpts, rec)
+			f.finish(t, start)
+		}()
+	}
+	wg.Wait()
+
+	// With every upload over, a pipeline still running has nobody left to
+	// write for
+	s.abandon()
+	<-pipeline
+}
+
+// attempt uploads the spooled backup to t, starting over after each
+// failure, waiting twice as long each time, until it succeeds or runs out
+// of retries. The manifest goes next to the backup once it is stored.
+func (f *fanOut) attempt(ctx context.Context, s *spool, t *fanOutTarget, encOpts utils.EncryptOptions, rec *manifest.Recorder) {
+	delay := f.retryDelay
+	for {
+		t.attempts++
+		counter := &countingReader{reader: s.reader()}
+		t.err = t.upload(ctx, counter)
+		t.size = counter.Count()
+		if t.err == nil {
+			break
+		}
+		// A backup that could not be produced fails everywhere
+		if err := s.failure(); err != nil {
+			t.err = err
+			return
+		}
+		if t.attempts > f.retries {
+			return
+		}
+		f.printf("🔁 %s: %v; retrying in %s (%d/%d)\n", strg.DisplayName(t.provider), t.err, delay, t.attempts, f.retries)
+		time.Sleep(delay)
+		delay *= 2
+		if t.provider == "filebase-ipfs" && strings.Contains(strings.ToLower(t.err.Error()), "access denied") {
+			f.printf("⚠️  Go SDK upload failed to IPFS - access denied. Trying AWS CLI fallback...\n")
+			t.awsCLI = true
+		}
+	}
+
+	// The stream was read to the end, so the pipeline and with it the
+	// manifest are complete
+	m := *rec.Manifest()
+	m.Sources = f.sources
+	if f.name != "" {
+		m.Name = f.name
+	}
+	t.warning = uploadManifest(ctx, t.backend, t.names, t.unlock, f.tag, f.version, f.source, &m, f.password, encOpts.Key)
+}
+
+// finish reports t once it is stored; failures are listed with the results
+func (f *fanOut) finish(t *fanOutTarget, start time.Time) {
+	t.elapsed = time.Since(start)
+	if t.err != nil {
+		return
+	}
+	f.printf("✅ %s: %s stored in %s\n", strg.DisplayName(t.provider), FormatBytes(t.size), t.elapsed.Round(time.Millisecond))
+	if t.warning != nil {
+		f.printf("⚠️  Backup stored, but its manifest could not be uploaded to %s: %v\n", strg.DisplayName(t.provider), t.warning)
+	}
+}
+
+// printFanOutResults prints a row per provider and returns how many stored
+// the backup
+func printFanOutResults(targets []*fanOutTarget, failed map[string]error) int {
+	fmt.Println("\n📊 Upload results:")
+	fmt.Printf("   %-22s %-8s %10s  %-8s  %s\n", "PROVIDER", "STATUS", "SIZE", "ATTEMPTS", "TIME")
+	stored := 0
+	for _, t := range targets {
+		status, size := "stored", FormatBytes(t.size)
+		if t.err != nil {
+			status, size = "failed", "-"
+		} else {
+			stored++
+		}
+		fmt.Printf("   %-22s %-8s %10s  %-8d  %s\n", strg.DisplayName(t.provider), status, size, t.attempts, t.elapsed.Round(time.Millisecond))
+	}
+	var keys []string
+	for key := range failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("   %-22s %-8s %10s  %-8d  %s\n", strg.DisplayName(key), "failed", "-", 0, "-")
+	}
+
+	for _, t := range targets {
+		if t.err != nil {
+			fmt.Printf("❌ %s: %v\n", strg.DisplayName(t.provider), t.err)
+		}
+	}
+	for _, key := range keys {
+		fmt.Printf("❌ %s: %v\n", strg.DisplayName(key), failed[key])
+	}
+	return stored
+}
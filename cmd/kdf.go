@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/utils"
+	"github.com/spf13/cobra"
+)
+
+// backupKDFParams returns the KDF new backups are encrypted with: the
+// parameters saved by `obscure kdf benchmark --save`, or the defaults
+func backupKDFParams() (utils.KDFParams, error) {
+	settings, err := cfg.GetKDFSettings()
+	if err != nil {
+		return utils.KDFParams{}, fmt.Errorf("failed to read KDF settings: %v", err)
+	}
+	if settings == nil {
+		return utils.DefaultKDFParams(), nil
+	}
+	params := utils.Argon2idParams(settings.Memory, settings.Time, settings.Threads)
+	if err := params.Validate(); err != nil {
+		return utils.KDFParams{}, fmt.Errorf("invalid KDF settings in config.yaml: %v", err)
+	}
+	return params, nil
+}
+
+// timeKDF derives one key with params and returns how long it took
+func timeKDF(params utils.KDFParams) (time.Duration, error) {
+	salt, err := utils.GenerateSalt()
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := utils.DeriveKeyWithParams("obscure kdf benchmark", salt, params); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// benchmarkArgon2id finds Argon2id parameters that take about target on
+// this machine. Memory is raised first, since it is what makes attacks
+// expensive, then the number of passes.
+func benchmarkArgon2id(target time.Duration, maxMemory uint32, threads uint8) (utils.KDFParams, time.Duration, error) {
+	params := utils.Argon2idParams(min(64*1024, maxMemory), 1, threads)
+	elapsed, err := timeKDF(params)
+	if err != nil {
+		return params, 0, err
+	}
+	fmt.Printf("   %-28s %s\n", params, elapsed.Round(time.Millisecond))
+
+	for elapsed < target && params.Memory < maxMemory {
+		next := params
+		next.Memory = min(params.Memory*2, maxMemory)
+		nextElapsed, err := timeKDF(next)
+		if err != nil {
+			return params, 0, err
+		}
+		fmt.Printf("   %-28s %s\n", next, nextElapsed.Round(time.Millisecond))
+		if nextElapsed > target*5/4 {
+			break
+		}
+		params, elapsed = next, nextElapsed
+	}
+
+	if elapsed < target {
+		// Time grows linearly with the number of passes
+		passes := uint32(float64(target) / float64(elapsed))
+		if passes > 1 {
+			params.Time = min(passes, 64)
+			if elapsed, err = timeKDF(params); err != nil {
+				return params, 0, err
+			}
+			fmt.Printf("   %-28s %s\n", params, elapsed.Round(time.Millisecond))
+		}
+	}
+	return params, elapsed, params.Validate()
+}
+
+var kdfCmd = &cobra.Command{
+	Use:   "kdf",
+	Short: "Tune the key derivation function that protects backup passwords",
+	Long: `New backups derive their key from the password with Argon2id, 64 MiB of
+memory and 3 passes unless other parameters are saved. The parameters are
+recorded in every backup, so changing them never affects older backups, and
+backups made with scrypt by earlier versions still restore.
+
+Pick parameters that take about a second on this machine, and use them for
+new backups:
+  obscure kdf benchmark --target 1s --save`,
+	Run: func(cmd *cobra.Command, args []string) {
+		params, err := backupKDFParams()
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		fmt.Println("🔑 New backups use", params)
+	},
+}
+
+var kdfBenchmarkCmd = &cobra.Command{
+	Use:   "benchmark",
+	Short: "Find Argon2id parameters that reach a target unlock time here",
+	Run: func(cmd *cobra.Command, args []string) {
+		target, _ := cmd.Flags().GetDuration("target")
+		maxMemoryMiB, _ := cmd.Flags().GetUint32("max-memory")
+		threads, _ := cmd.Flags().GetUint8("threads")
+		save, _ := cmd.Flags().GetBool("save")
+		reset, _ := cmd.Flags().GetBool("reset")
+
+		if reset {
+			if err := cfg.SetKDFSettings(nil); err != nil {
+				fmt.Println("❌ Failed to save KDF settings:", err)
+				return
+			}
+			fmt.Println("✅ New backups use the default", utils.DefaultKDFParams())
+			return
+		}
+		if target <= 0 || maxMemoryMiB < 8 || maxMemoryMiB > 4096 {
+			fmt.Println("❌ --target has to be positive and --max-memory between 8 and 4096 MiB.")
+			return
+		}
+		if threads == 0 {
+			threads = uint8(min(runtime.NumCPU(), 4))
+		}
+
+		fmt.Printf("⏱️  Benchmarking Argon2id (target %s, up to %d MiB, %d threads)...\n", target, maxMemoryMiB, threads)
+		params, elapsed, err := benchmarkArgon2id(target, maxMemoryMiB*1024, threads)
+		if err != nil {
+			fmt.Println("❌ Benchmark failed:", err)
+			return
+		}
+		fmt.Printf("✅ %s takes %s on this machine\n", params, elapsed.Round(time.Millisecond))
+		if elapsed < target/2 {
+			fmt.Println("ℹ️  The target was not reached within the limits; raise --max-memory for a stronger setting.")
+		}
+
+		if !save {
+			fmt.Println("ℹ️  Run again with --save to use these parameters for new backups.")
+			return
+		}
+		settings := &cfg.KDFSettings{Memory: params.Memory, Time: params.Time, Threads: params.Threads}
+		if err := cfg.SetKDFSettings(settings); err != nil {
+			fmt.Println("❌ Failed to save KDF settings:", err)
+			return
+		}
+		fmt.Println("💾 Saved. New backups will use these parameters; older backups keep theirs.")
+		fmt.Println("⚠️  Restoring needs the same memory, so keep --max-memory within what your restore machines have.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(kdfCmd)
+	kdfCmd.AddCommand(kdfBenchmarkCmd)
+	kdfBenchmarkCmd.Flags().Duration("target", time.Second, "Unlock time to aim for")
+	kdfBenchmarkCmd.Flags().Uint32("max-memory", 1024, "Most memory to use, in MiB")
+	kdfBenchmarkCmd.Flags().Uint8("threads", 0, "Parallel lanes (default: CPU cores, at most 4)")
+	kdfBenchmarkCmd.Flags().Bool("save", false, "Use the parameters found for new backups")
+	kdfBenchmarkCmd.Flags().Bool("reset", false, "Go back to the default parameters instead of benchmarking")
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"github.com/shah1011/obscure/internal/keyring"
+	"github.com/shah1011/obscure/internal/naming"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/shah1011/obscure/utils"
+	"github.com/spf13/cobra"
+)
+
+// identityFiles holds the --identity flags of the commands that decrypt
+// backups
+var identityFiles []string
+
+// keysDir holds the private keys made by `obscure key generate`
+func keysDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".obscure", "keys")
+}
+
+// addRecipientFlags registers the public keys a backup can be encrypted to
+func addRecipientFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("recipient", nil, "Encrypt to this age public key instead of a password (repeatable)")
+	cmd.Flags().StringArray("recipients-file", nil, "Encrypt to the age public keys listed in this file (repeatable)")
+}
+
+// backupRecipients returns the public keys given with --recipient and
+// --recipients-file
+func backupRecipients(cmd *cobra.Command) ([]age.Recipient, error) {
+	keys, _ := cmd.Flags().GetStringArray("recipient")
+	files, _ := cmd.Flags().GetStringArray("recipients-file")
+	return utils.ParseRecipients(keys, files)
+}
+
+// addIdentityFlags registers the private keys a backup can be decrypted with
+func addIdentityFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&identityFiles, "identity", nil, "Decrypt with the age private keys in this file (repeatable; ~/.obscure/keys is always searched)")
+}
+
+// openBucket returns what the backups being read from backend may be
+// encrypted with, and how they are named. Keys are the private keys from
+// --identity and ~/.obscure/keys, the password, and the user's keyring, which
+// the password unlocks and which also holds the key of private names. The
+// password is only asked for once a backup or its name turns out to need it.
+func openBucket(ctx context.Context, backend strg.Backend, username string) (utils.Keys, *naming.Namer, error) {
+	var identities []age.Identity
+	for _, path := range identityFiles {
+		ids, err := utils.ReadIdentityFile(path)
+		if err != nil {
+			return utils.Keys{}, nil, fmt.Errorf("failed to read identity: %w", err)
+		}
+		identities = append(identities, ids...)
+	}
+	for _, key := range localKeys() {
+		ids, err := utils.ReadIdentityFile(key.path)
+		if err != nil {
+			fmt.Printf("⚠️  Skipping %s: %v\n", key.path, err)
+			continue
+		}
+		identities = append(identities, ids...)
+	}
+
+	password := sync.OnceValues(func() (string, error) {
+		return readPassword("🔐 Enter decryption password:", false)
+	})
+	ring := lazyKeyring(ctx, backend, username, password)
+	masterKey := func(id []byte) ([]byte, error) {
+		k, err := ring()
+		if err != nil {
+			return nil, err
+		}
+		return k.Lookup(id)
+	}
+	names, err := backupNamer(ctx, backend, username, ring)
+	if err != nil {
+		return utils.Keys{}, nil, err
+	}
+	return utils.Keys{Password: password, Identities: identities, MasterKey: masterKey}, names, nil
+}
+
+// lazyKeyring returns a function that unlocks username's keyring in backend
+// the first time it is called, with the password from password
+func lazyKeyring(ctx context.Context, backend strg.Backend, username string, password func() (string, error)) func() (*keyring.Keyring, error) {
+	return sync.OnceValues(func() (*keyring.Keyring, error) {
+		pw, err := password()
+		if err != nil {
+			return nil, err
+		}
+		return keyring.Open(ctx, backend, username, pw)
+	})
+}
+
+// backupNamer returns how the backups of username in backend are named.
+// Private names need the keyring, which ring unlocks; buckets with readable
+// names never call it.
+func backupNamer(ctx context.Context, backend strg.Backend, username string, ring func() (*keyring.Keyring, error)) (*naming.Namer, error) {
+	private, err := keyring.PrivateNames(ctx, backend, username)
+	if err != nil {
+		return nil, err
+	}
+	if !private {
+		return naming.Plain(username), nil
+	}
+	k, err := ring()
+	if err != nil {
+		return nil, fmt.Errorf("backups in this bucket have private names, which need the keyring: %w", err)
+	}
+	return naming.Private(username, k.NamesKey())
+}
+
+// keyringKey seals the key of a new backup to the keyring that ring
+// unlocks. It returns nil if the user has no keyring in the bucket, so the
+// backup's key is derived from the password as before.
+func keyringKey(ring func() (*keyring.Keyring, error)) (*utils.WrappedKey, error) {
+	k, err := ring()
+	if errors.Is(err, keyring.ErrNoKeyring) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k.WrapKey()
+}
+
+type localKey struct {
+	name string
+	path string
+}
+
+// localKeys lists the private keys in ~/.obscure/keys by name
+func localKeys() []localKey {
+	paths, _ := filepath.Glob(filepath.Join(keysDir(), "*.key"))
+	sort.Strings(paths)
+	var keys []localKey
+	for _, path := range paths {
+		keys = append(keys, localKey{name: strings.TrimSuffix(filepath.Base(path), ".key"), path: path})
+	}
+	return keys
+}
+
+// writeIdentity saves a new private key in the format of age-keygen, so
+// that age can read it too. An existing file is never replaced.
+func writeIdentity(path string, identity *age.X25519Identity) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage key pairs and the keyring that encrypt backups",
+	Long: `Backups made with --recipient are encrypted to age public keys instead of a
+password. The machine making them needs only the public key, and cannot
+decrypt them; restoring needs the matching private key.
+
+Generate a key pair on the machine that will restore, and give its public
+key to the machines that back up:
+  obscure key generate laptop
+  obscure backup /srv/data --tag data --recipient age1...
+
+Private keys live in ~/.obscure/keys and are tried automatically by restore,
+ls and verify; --identity adds others. They use the age format, so
+'age -d -i' and 'age-keygen -y' work on them too.
+
+Password-encrypted backups can use a keyring instead of deriving their key
+from the password. The keyring is a small encrypted object in the bucket
+holding random master keys; each backup gets its own key, sealed to the
+active master key. Passwords only unlock the keyring, so they can be added,
+removed or changed without touching a single backup:
+  obscure key add-password      (the first one creates the keyring)
+  obscure key remove-password
+  obscure key rotate
+
+The keyring can also hide the tags and versions of backups from the
+provider by encrypting their names:
+  obscure key private-names`,
+}
+
+var keyGenerateCmd = &cobra.Command{
+	Use:   "generate [name]",
+	Short: "Create a key pair in ~/.obscure/keys and print its public key",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := "default"
+		if len(args) == 1 {
+			name = args[0]
+		}
+		if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			fmt.Println("❌ Invalid key name:", name)
+			return
+		}
+
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			fmt.Println("❌ Failed to generate key:", err)
+			return
+		}
+		path := filepath.Join(keysDir(), name+".key")
+		if err := writeIdentity(path, identity); err != nil {
+			if os.IsExist(err) {
+				fmt.Printf("❌ %s already exists. Replacing it would make the backups encrypted to it unrestorable.\n", path)
+				return
+			}
+			fmt.Println("❌ Failed to save key:", err)
+			return
+		}
+		fmt.Println("🔑 Private key written to", path)
+		fmt.Println("📢 Public key:", identity.Recipient())
+		fmt.Println("⚠️  Keep a copy of the private key somewhere safe. Backups encrypted to it cannot be restored without it.")
+	},
+}
+
+var keyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the key pairs in ~/.obscure/keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		keys := localKeys()
+		if len(keys) == 0 {
+			fmt.Println("📭 No keys found. Create one with 'obscure key generate'.")
+			return
+		}
+		for _, key := range keys {
+			identities, err := utils.ReadIdentityFile(key.path)
+			if err != nil {
+				fmt.Printf("❌ %s: %v\n", key.name, err)
+				continue
+			}
+			for _, identity := range identities {
+				if x, ok := identity.(*age.X25519Identity); ok {
+					fmt.Printf("🔑 %-16s %s\n", key.name, x.Recipient())
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyGenerateCmd)
+	keyCmd.AddCommand(keyListCmd)
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shah1011/obscure/utils"
+	"github.com/spf13/cobra"
+)
+
+// passwordSource holds the --password-file, --password-command and
+// --keyfile flags of the commands that encrypt or decrypt backups
+var passwordSource utils.PasswordSource
+
+// defaultKeyfilePath is where `obscure keyfile generate` writes, and what
+// --keyfile reads when given without a path
+func defaultKeyfilePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".obscure", "keyfile")
+}
+
+// addPasswordFlags registers the non-interactive password sources on cmd
+func addPasswordFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&passwordSource.File, "password-file", "", "Read the backup password from the first line of this file")
+	cmd.Flags().StringVar(&passwordSource.Command, "password-command", "", "Run this shell command and use its output as the backup password")
+	cmd.Flags().StringVar(&passwordSource.Keyfile, "keyfile", "", "Use a keyfile made by `obscure keyfile generate` as the backup password")
+	cmd.Flags().Lookup("keyfile").NoOptDefVal = defaultKeyfilePath()
+}
+
+// readPassword returns the backup password from --password-file,
+// --password-command, --keyfile or OBSCURE_PASSWORD, and otherwise prompts
+// for it, twice when confirm is set
+func readPassword(prompt string, confirm bool) (string, error) {
+	if passwordSource.Configured() {
+		return passwordSource.Read()
+	}
+	return promptPassword(prompt, confirm)
+}
+
+// promptPassword asks for a password on the terminal, twice when confirm
+// is set
+func promptPassword(prompt string, confirm bool) (string, error) {
+	password, err := utils.PromptPassword(prompt)
+	if err != nil || strings.TrimSpace(password) == "" {
+		return "", fmt.Errorf("invalid or empty password")
+	}
+	if !confirm {
+		return password, nil
+	}
+	confirmPassword, err := utils.PromptPassword("🔐 Confirm encryption password: ")
+	if err != nil || strings.TrimSpace(confirmPassword) == "" {
+		return "", fmt.Errorf("invalid or empty confirmation password")
+	}
+	if password != confirmPassword {
+		return "", fmt.Errorf("passwords do not match, please try again")
+	}
+	return password, nil
+}
+
+var keyfileCmd = &cobra.Command{
+	Use:   "keyfile",
+	Short: "Manage the local keyfile used as a backup password",
+	Long: `A keyfile is a random secret kept on this machine and used as the backup
+password with --keyfile, so scheduled and scripted backups need neither a
+prompt nor a password stored in plain sight.
+
+Anyone with the keyfile can decrypt the backups made with it, and nobody can
+without it: keep a copy somewhere safe, away from the machine it protects.`,
+}
+
+var keyfileGenerateCmd = &cobra.Command{
+	Use:   "generate [path]",
+	Short: "Create a new keyfile (default ~/.obscure/keyfile)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := defaultKeyfilePath()
+		if len(args) == 1 {
+			path = args[0]
+		}
+		if err := utils.WriteKeyfile(path); err != nil {
+			if os.IsExist(err) {
+				fmt.Printf("❌ %s already exists. Replacing it would make the backups encrypted with it unrestorable.\n", path)
+				return
+			}
+			fmt.Println("❌ Failed to create keyfile:", err)
+			return
+		}
+		fmt.Println("🔑 Keyfile written to", path)
+		fmt.Println("⚠️  Keep a copy somewhere safe. Backups made with it cannot be restored without it.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keyfileCmd)
+	keyfileCmd.AddCommand(keyfileGenerateCmd)
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/keyring"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// keyringBackend opens the active provider for the keyring commands
+func keyringBackend() (strg.Backend, string, string, bool) {
+	username, err := cfg.GetSessionUsername()
+	if err != nil || username == "" {
+		fmt.Println("❌ Not logged in. Please run `obscure login` or `obscure signup`.")
+		return nil, "", "", false
+	}
+	providerKey, err := cfg.GetSessionProvider()
+	if err != nil || providerKey == "" {
+		providerKey, err = cfg.GetUserDefaultProvider()
+		if err != nil || providerKey == "" {
+			fmt.Println("⚠️  No cloud provider is configured.")
+			return nil, "", "", false
+		}
+	}
+	backend, err := strg.OpenBackend(context.Background(), providerKey)
+	if err != nil {
+		fmt.Printf("❌ Failed to initialize %s client: %v\n", strg.DisplayName(providerKey), err)
+		return nil, "", "", false
+	}
+	return backend, username, providerKey, true
+}
+
+// unlockKeyring asks for a current password and opens the keyring with it
+func unlockKeyring(ctx context.Context, backend strg.Backend, username, providerKey string) (*keyring.Keyring, bool) {
+	password, err := readPassword("🔐 Enter a current keyring password:", false)
+	if err != nil {
+		fmt.Println("❌", err)
+		return nil, false
+	}
+	k, err := keyring.Open(ctx, backend, username, password)
+	if errors.Is(err, keyring.ErrNoKeyring) {
+		fmt.Printf("❌ There is no keyring in %s yet. Create one with 'obscure key add-password'.\n", strg.DisplayName(providerKey))
+		return nil, false
+	}
+	if err != nil {
+		fmt.Println("❌", err)
+		return nil, false
+	}
+	return k, true
+}
+
+var keyAddPasswordCmd = &cobra.Command{
+	Use:   "add-password",
+	Short: "Let another password unlock the keyring, creating it if needed",
+	Run: func(cmd *cobra.Command, args []string) {
+		backend, username, providerKey, ok := keyringBackend()
+		if !ok {
+			return
+		}
+		ctx := context.Background()
+
+		exists, err := keyring.Exists(ctx, backend, username)
+		if err != nil {
+			fmt.Println("❌ Failed to look up keyring:", err)
+			return
+		}
+		if !exists {
+			fmt.Printf("🆕 Creating a keyring in %s. New encrypted backups there will use it.\n", strg.DisplayName(providerKey))
+			fmt.Println("⚠️  WARNING: Keep your keyring password safe. If you lose every password, you won't be able to recover your backups!")
+			password, err := readPassword("🔐 Enter keyring password: ", true)
+			if err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+			k, err := keyring.Create(password)
+			if err != nil {
+				fmt.Println("❌ Failed to create keyring:", err)
+				return
+			}
+			if err := k.Save(ctx, backend, username); err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+			fmt.Println("✅ Keyring created. Backups made before it keep their own passwords.")
+			return
+		}
+
+		k, ok := unlockKeyring(ctx, backend, username, providerKey)
+		if !ok {
+			return
+		}
+		password, err := promptPassword("🔐 Enter the password to add: ", true)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		if err := k.AddPassword(password); err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		if err := k.Save(ctx, backend, username); err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		fmt.Printf("✅ Password added. %d passwords now unlock the keyring.\n", k.Passwords())
+	},
+}
+
+var keyRemovePasswordCmd = &cobra.Command{
+	Use:   "remove-password",
+	Short: "Stop a password from unlocking the keyring",
+	Run: func(cmd *cobra.Command, args []string) {
+		backend, username, providerKey, ok := keyringBackend()
+		if !ok {
+			return
+		}
+		ctx := context.Background()
+
+		password, err := readPassword("🔐 Enter the password to remove:", false)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		k, err := keyring.Open(ctx, backend, username, password)
+		if errors.Is(err, keyring.ErrNoKeyring) {
+			fmt.Printf("❌ There is no keyring in %s.\n", strg.DisplayName(providerKey))
+			return
+		}
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		if err := k.RemovePassword(password); err != nil {
+			if errors.Is(err, keyring.ErrLastPassword) {
+				fmt.Println("❌ This is the keyring's only password. Use 'obscure key rotate' to replace it.")
+				return
+			}
+			fmt.Println("❌", err)
+			return
+		}
+		if err := k.Save(ctx, backend, username); err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		fmt.Printf("✅ Password removed. %d passwords still unlock the keyring.\n", k.Passwords())
+		fmt.Println("ℹ️  Anyone who kept a copy of the old keyring can still open it; run 'obscure key rotate' so later backups are safe from it.")
+	},
+}
+
+var keyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Change the keyring password and start a new master key",
+	Long: `Re-encrypt the keyring under a new password and start a new master key for
+future backups. Only the keyring is rewritten: existing backups keep the
+master key they were sealed to, which the keyring still holds.
+
+Every other password is dropped, so neither a leaked password nor an old
+copy of the keyring unlocks backups made from now on. Add the passwords you
+still want with 'obscure key add-password'.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		backend, username, providerKey, ok := keyringBackend()
+		if !ok {
+			return
+		}
+		ctx := context.Background()
+
+		k, ok := unlockKeyring(ctx, backend, username, providerKey)
+		if !ok {
+			return
+		}
+		password, err := promptPassword("🔐 Enter the new keyring password: ", true)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		dropped := k.Passwords() - 1
+		if err := k.Rotate(password); err != nil {
+			fmt.Println("❌ Failed to rotate keyring:", err)
+			return
+		}
+		if err := k.Save(ctx, backend, username); err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		fmt.Printf("🔄 Keyring rotated. New backups use master key %d of %d; existing backups are unchanged.\n", k.Generations(), k.Generations())
+		if dropped > 0 {
+			fmt.Printf("⚠️  %d other password(s) no longer unlock the keyring. Add them back with 'obscure key add-password'.\n", dropped)
+		}
+	},
+}
+
+func init() {
+	keyCmd.AddCommand(keyAddPasswordCmd)
+	keyCmd.AddCommand(keyRemovePasswordCmd)
+	keyCmd.AddCommand(keyRotateCmd)
+	addPasswordFlags(keyAddPasswordCmd)
+	addPasswordFlags(keyRemovePasswordCmd)
+	addPasswordFlags(keyRotateCmd)
+}
@@ -1,24 +1,34 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
-	"cloud.google.com/go/storage"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/fatih/color"
 	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/manifest"
+	"github.com/shah1011/obscure/internal/naming"
+	"github.com/shah1011/obscure/internal/repository"
 	strg "github.com/shah1011/obscure/internal/storage"
 	"github.com/spf13/cobra"
-	"google.golang.org/api/iterator"
 )
 
 var lsCmd = &cobra.Command{
-	Use:   "ls",
+	Use:   "ls [tag/version]",
 	Short: "List all available backups (tags and versions)",
+	Long: `List all available backups (tags and versions).
+
+With a tag/version argument, list the files inside that backup from its
+manifest, without downloading the backup itself:
+  obscure ls testdata/2.9`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		providerKey, err := cfg.GetSessionProvider()
 		if err != nil || providerKey == "" {
@@ -37,804 +47,334 @@ var lsCmd = &cobra.Command{
 			return
 		}
 
-		// Use token in Authorization header or validation
-
-		switch providerKey {
-		case "gcs":
-			prefix := fmt.Sprintf("backups/%s/", username) // new
-			listFromGCS(prefix)
-		case "s3":
-			prefix := fmt.Sprintf("backups/%s/", username) // e.g., "backups/abul/"
-			listFromS3(prefix)
-		case "b2":
-			prefix := fmt.Sprintf("backups/%s/", username)
-			listFromB2(prefix)
-		case "idrive":
-			prefix := fmt.Sprintf("backups/%s/", username)
-			listFromIDrive(prefix)
-		case "s3-compatible":
-			prefix := fmt.Sprintf("backups/%s/", username)
-			listFromS3Compatible(prefix)
-		case "storj":
-			prefix := fmt.Sprintf("backups/%s/", username)
-			listFromStorj(prefix)
-		case "filebase-ipfs":
-			prefix := fmt.Sprintf("backups/%s/", username)
-			listFromFilebaseIPFS(prefix)
-		default:
+		if !strg.IsRegistered(providerKey) {
 			fmt.Printf("❌ Unknown provider: %s\n", providerKey)
+			return
 		}
+
+		if len(args) == 1 {
+			tag, version, err := parseBackupRef(args[0])
+			if err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+			listBackupContents(providerKey, username, tag, version)
+			return
+		}
+
+		listFromProvider(providerKey, username)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(lsCmd)
+	addPasswordFlags(lsCmd)
+	addIdentityFlags(lsCmd)
 }
 
-func listFromGCS(prefix string) {
+func listFromProvider(providerKey, username string) {
 	ctx := context.Background()
-	client, err := strg.NewGCSClient(ctx, "gcs")
+	backend, err := strg.OpenBackend(ctx, providerKey)
 	if err != nil {
-		// Check if it's a configuration error and provide helpful guidance
-		if strings.Contains(err.Error(), "configuration incomplete") {
-			fmt.Println("❌ GCS provider is not properly configured.")
-			fmt.Println("   Missing required configuration fields.")
-			fmt.Println("   Run: ./obscure provider add gcs")
-			fmt.Println("   Or check configuration with: ./obscure provider list")
-			return
-		}
-		if strings.Contains(err.Error(), "not configured") {
-			fmt.Println("❌ GCS provider is not configured.")
-			fmt.Println("   Run: ./obscure provider add gcs")
-			return
-		}
-		if strings.Contains(err.Error(), "disabled") {
-			fmt.Println("❌ GCS provider is disabled.")
-			fmt.Println("   Complete the configuration to enable it.")
-			fmt.Println("   Run: ./obscure provider add gcs")
-			return
-		}
-		fmt.Println("❌ Error initializing GCS client:", err)
+		printProviderConfigError(providerKey, err)
 		return
 	}
-	defer client.Close()
 
-	// Get bucket name from provider config
-	bucket, err := strg.GetBucketName("gcs")
+	// Private names are only readable with the keyring
+	_, names, err := openBucket(ctx, backend, username)
 	if err != nil {
-		fmt.Printf("❌ Failed to get bucket name: %v\n", err)
+		fmt.Println("❌", err)
 		return
 	}
-
-	it := client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
-	files := []string{}
-	metadata := make(map[string]bool) // Store is_direct metadata for each file
-
-	for {
-		obj, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			// Comprehensive error handling for GCS-specific issues
-			errMsg := err.Error()
-
-			if strings.Contains(errMsg, "invalid_grant") {
-				fmt.Println("❌ Invalid GCS service account credentials.")
-				fmt.Println("   Check your service account JSON file.")
-				fmt.Println("   Run: ./obscure provider add gcs to update credentials")
-				return
-			}
-
-			if strings.Contains(errMsg, "storage: bucket doesn't exist") {
-				fmt.Println("❌ GCS bucket not found.")
-				fmt.Println("   Check your bucket name in the Google Cloud console.")
-				fmt.Println("   Run: ./obscure provider add gcs to update bucket name")
-				return
-			}
-
-			if strings.Contains(errMsg, "storage: permission denied") {
-				fmt.Println("❌ Access denied to GCS bucket.")
-				fmt.Println("   Possible issues:")
-				fmt.Println("   - Incorrect service account permissions")
-				fmt.Println("   - Bucket doesn't exist")
-				fmt.Println("   - Service account doesn't have access to this bucket")
-				fmt.Println("   Check your service account permissions in the Google Cloud console.")
-				return
-			}
-
-			if strings.Contains(errMsg, "exceeded maximum number of attempts") {
-				fmt.Println("❌ GCS connection timeout.")
-				fmt.Println("   Possible issues:")
-				fmt.Println("   - Network connectivity problem")
-				fmt.Println("   - Google Cloud service is slow or down")
-				fmt.Println("   - Incorrect project configuration")
-				fmt.Println("   Run: ./obscure provider add gcs to check configuration")
-				return
-			}
-
-			if strings.Contains(errMsg, "invalid character") || strings.Contains(errMsg, "unexpected end of JSON") {
-				fmt.Println("❌ Invalid GCS service account JSON file.")
-				fmt.Println("   The JSON file appears to be corrupted or invalid.")
-				fmt.Println("   Download a fresh service account key from Google Cloud console.")
-				fmt.Println("   Run: ./obscure provider add gcs to update credentials")
-				return
-			}
-
-			// Generic error with suggestion to check configuration
-			fmt.Println("❌ Failed to list GCS backups:", err)
-			fmt.Println("   This might be due to:")
-			fmt.Println("   - Invalid service account credentials")
-			fmt.Println("   - Incorrect bucket name")
-			fmt.Println("   - Network connectivity issues")
-			fmt.Println("   Run: ./obscure provider add gcs to reconfigure")
-			return
-		}
-		files = append(files, obj.Name)
-		// Check if this is a direct backup
-		isDirect := obj.Metadata != nil && obj.Metadata["is_direct"] == "true"
-		metadata[obj.Name] = isDirect
+	backups, err := listBackups(ctx, backend, names, "")
+	if err != nil {
+		printProviderListError(providerKey, err)
+		return
 	}
 
-	printBackups(files, metadata)
+	printBackups(backups, names)
 }
 
-func listFromS3(prefix string) {
+// listBackupContents prints the manifest of one backup, or the snapshot of a
+// repository backup
+func listBackupContents(providerKey, username, tag, version string) {
 	ctx := context.Background()
-	awsCfg, err := strg.NewAWSClient(ctx, "s3")
+	backend, err := strg.OpenBackend(ctx, providerKey)
 	if err != nil {
-		// Check if it's a configuration error and provide helpful guidance
-		if strings.Contains(err.Error(), "configuration incomplete") {
-			fmt.Println("❌ S3 provider is not properly configured.")
-			fmt.Println("   Missing required configuration fields.")
-			fmt.Println("   Run: ./obscure provider add s3")
-			fmt.Println("   Or check configuration with: ./obscure provider list")
-			return
-		}
-		if strings.Contains(err.Error(), "not configured") {
-			fmt.Println("❌ S3 provider is not configured.")
-			fmt.Println("   Run: ./obscure provider add s3")
-			return
-		}
-		if strings.Contains(err.Error(), "disabled") {
-			fmt.Println("❌ S3 provider is disabled.")
-			fmt.Println("   Complete the configuration to enable it.")
-			fmt.Println("   Run: ./obscure provider add s3")
-			return
-		}
-		fmt.Println("❌ Failed to load AWS config:", err)
+		printProviderConfigError(providerKey, err)
 		return
 	}
 
-	client := s3sdk.NewFromConfig(*awsCfg)
-
-	// Get bucket name from provider config
-	bucket, err := strg.GetBucketName("s3")
+	// The password is only asked for if the manifest is encrypted with one,
+	// or the backup has a private name
+	keys, names, err := openBucket(ctx, backend, username)
 	if err != nil {
-		fmt.Printf("❌ Failed to get bucket name: %v\n", err)
+		fmt.Println("❌", err)
 		return
 	}
 
-	input := &s3sdk.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	}
-
-	paginator := s3sdk.NewListObjectsV2Paginator(client, input)
-	files := []string{}
-	metadata := make(map[string]bool) // Store is_direct metadata for each file
-
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			// Comprehensive error handling for S3-specific issues
-			errMsg := err.Error()
-
-			if strings.Contains(errMsg, "InvalidAccessKeyId") {
-				fmt.Println("❌ Invalid AWS Access Key ID.")
-				fmt.Println("   Check your AWS Access Key ID in the AWS console.")
-				fmt.Println("   Run: ./obscure provider add s3 to update credentials")
-				return
-			}
-
-			if strings.Contains(errMsg, "SignatureDoesNotMatch") {
-				fmt.Println("❌ Invalid AWS Secret Access Key.")
-				fmt.Println("   Check your AWS Secret Access Key in the AWS console.")
-				fmt.Println("   Run: ./obscure provider add s3 to update credentials")
-				return
-			}
-
-			if strings.Contains(errMsg, "NoSuchBucket") {
-				fmt.Println("❌ S3 bucket not found.")
-				fmt.Println("   Check your bucket name in the AWS console.")
-				fmt.Println("   Run: ./obscure provider add s3 to update bucket name")
-				return
-			}
-
-			if strings.Contains(errMsg, "AccessDenied") {
-				fmt.Println("❌ Access denied to S3 bucket.")
-				fmt.Println("   Possible issues:")
-				fmt.Println("   - Incorrect IAM permissions")
-				fmt.Println("   - Bucket doesn't exist")
-				fmt.Println("   - IAM user doesn't have access to this bucket")
-				fmt.Println("   Check your IAM user permissions in the AWS console.")
-				return
-			}
-
-			if strings.Contains(errMsg, "exceeded maximum number of attempts") {
-				fmt.Println("❌ S3 connection timeout.")
-				fmt.Println("   Possible issues:")
-				fmt.Println("   - Incorrect region")
-				fmt.Println("   - Network connectivity problem")
-				fmt.Println("   - AWS service is slow or down")
-				fmt.Println("   Run: ./obscure provider add s3 to check/update region")
-				return
-			}
-
-			// Generic error with suggestion to check configuration
-			fmt.Println("❌ Failed to list S3 backups:", err)
-			fmt.Println("   This might be due to:")
-			fmt.Println("   - Incorrect region")
-			fmt.Println("   - Invalid credentials")
-			fmt.Println("   - Network connectivity issues")
-			fmt.Println("   Run: ./obscure provider add s3 to reconfigure")
+	reader, _, err := backend.Get(ctx, names.Key(tag, version, manifest.Extension))
+	if errors.Is(err, strg.ErrNotFound) {
+		exists, _ := strg.Exists(ctx, backend, repository.SnapshotKey(username, tag, version))
+		if exists {
+			listSnapshotContents(ctx, backend, username, tag, version)
 			return
 		}
-		for _, obj := range page.Contents {
-			// Get object metadata to check if it's a direct backup
-			headInput := &s3sdk.HeadObjectInput{
-				Bucket: aws.String(bucket),
-				Key:    obj.Key,
-			}
-			headOutput, err := client.HeadObject(ctx, headInput)
-			if err != nil {
-				continue
-			}
-			files = append(files, *obj.Key)
-			if headOutput.Metadata != nil {
-				isDirect := headOutput.Metadata["is_direct"] == "true"
-				metadata[*obj.Key] = isDirect
-			}
-		}
+		fmt.Printf("❌ No manifest found for %s/%s. Backups made before manifests were introduced can only be listed by restoring them.\n", tag, version)
+		return
 	}
-
-	printBackups(files, metadata)
-}
-
-func listFromB2(prefix string) {
-	ctx := context.Background()
-	b2Client, err := strg.NewB2Client(ctx, "b2")
 	if err != nil {
-		// Check if it's a configuration error and provide helpful guidance
-		if strings.Contains(err.Error(), "configuration incomplete") {
-			fmt.Println("❌ B2 provider is not properly configured.")
-			fmt.Println("   Missing required configuration fields.")
-			fmt.Println("   Run: ./obscure provider add b2")
-			fmt.Println("   Or check configuration with: ./obscure provider list")
-			return
-		}
-		if strings.Contains(err.Error(), "not configured") {
-			fmt.Println("❌ B2 provider is not configured.")
-			fmt.Println("   Run: ./obscure provider add b2")
-			return
-		}
-		if strings.Contains(err.Error(), "disabled") {
-			fmt.Println("❌ B2 provider is disabled.")
-			fmt.Println("   Complete the configuration to enable it.")
-			fmt.Println("   Run: ./obscure provider add b2")
-			return
-		}
-		fmt.Println("❌ Failed to load B2 config:", err)
+		fmt.Println("❌ Failed to download manifest:", err)
 		return
 	}
-
-	// List files using official B2 SDK
-	files, err := b2Client.ListFiles(ctx, prefix)
+	data, err := io.ReadAll(reader)
+	reader.Close()
 	if err != nil {
-		// Comprehensive error handling for B2-specific issues
-		errMsg := err.Error()
-
-		// Check for endpoint/URL issues
-		if strings.Contains(errMsg, "tls: failed to verify certificate") {
-			fmt.Println("❌ B2 endpoint certificate verification failed.")
-			fmt.Println("   This usually means the endpoint URL is incorrect.")
-			fmt.Println("   Check your B2 bucket's endpoint in the Backblaze console.")
-			fmt.Println("   Run: ./obscure provider add b2 to update the endpoint")
-			return
-		}
-
-		if strings.Contains(errMsg, "no such host") || strings.Contains(errMsg, "dial tcp") {
-			fmt.Println("❌ Cannot connect to B2 endpoint.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect endpoint URL")
-			fmt.Println("   - Network connectivity problem")
-			fmt.Println("   - B2 service is down")
-			fmt.Println("   Run: ./obscure provider add b2 to check/update endpoint")
-			return
-		}
-
-		if strings.Contains(errMsg, "exceeded maximum number of attempts") {
-			fmt.Println("❌ B2 connection timeout.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect endpoint URL")
-			fmt.Println("   - Network connectivity problem")
-			fmt.Println("   - B2 service is slow or down")
-			fmt.Println("   Run: ./obscure provider add b2 to check/update endpoint")
-			return
-		}
-
-		if strings.Contains(errMsg, "InvalidAccessKeyId") {
-			fmt.Println("❌ Invalid B2 Application Key ID.")
-			fmt.Println("   Check your B2 Application Key ID in the Backblaze console.")
-			fmt.Println("   Run: ./obscure provider add b2 to update credentials")
-			return
-		}
-
-		if strings.Contains(errMsg, "SignatureDoesNotMatch") {
-			fmt.Println("❌ Invalid B2 Application Key.")
-			fmt.Println("   Check your B2 Application Key in the Backblaze console.")
-			fmt.Println("   Run: ./obscure provider add b2 to update credentials")
-			return
-		}
-
-		if strings.Contains(errMsg, "NoSuchBucket") {
-			fmt.Println("❌ B2 bucket not found.")
-			fmt.Println("   Check your bucket name in the Backblaze console.")
-			fmt.Println("   Run: ./obscure provider add b2 to update bucket name")
-			return
-		}
-
-		if strings.Contains(errMsg, "AccessDenied") {
-			fmt.Println("❌ Access denied to B2 bucket.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect Application Key permissions")
-			fmt.Println("   - Bucket doesn't exist")
-			fmt.Println("   - Application Key doesn't have access to this bucket")
-			fmt.Println("   Check your B2 Application Key permissions in the Backblaze console.")
-			return
-		}
-
-		// Generic error with suggestion to check configuration
-		fmt.Println("❌ Failed to list B2 backups:", err)
-		fmt.Println("   This might be due to:")
-		fmt.Println("   - Incorrect endpoint URL")
-		fmt.Println("   - Invalid credentials")
-		fmt.Println("   - Network connectivity issues")
-		fmt.Println("   Run: ./obscure provider add b2 to reconfigure")
+		fmt.Println("❌ Failed to download manifest:", err)
 		return
 	}
 
-	// Get metadata for each file
-	metadata := make(map[string]bool)
-	for _, file := range files {
-		fileMetadata, err := b2Client.GetFileMetadata(ctx, file)
-		if err != nil {
-			continue
-		}
-		isDirect := fileMetadata["is_direct"] == "true"
-		metadata[file] = isDirect
+	m, err := manifest.Decode(bytes.NewReader(data), keys)
+	if err != nil {
+		fmt.Println("❌", err)
+		return
 	}
 
-	printBackups(files, metadata)
+	printManifest(m, tag, version)
 }
 
-func listFromIDrive(prefix string) {
-	ctx := context.Background()
-	idriveClient, err := strg.NewIDriveClient(ctx, "idrive")
+// listSnapshotContents prints a repository snapshot in manifest form
+func listSnapshotContents(ctx context.Context, backend strg.Backend, username, tag, version string) {
+	password, err := readPassword("🔐 Enter decryption password:", false)
 	if err != nil {
-		// Check if it's a configuration error and provide helpful guidance
-		if strings.Contains(err.Error(), "configuration incomplete") {
-			fmt.Println("❌ IDrive E2 provider is not properly configured.")
-			fmt.Println("   Missing required configuration fields.")
-			fmt.Println("   Run: ./obscure provider add idrive")
-			fmt.Println("   Or check configuration with: ./obscure provider list")
-			return
-		}
-		if strings.Contains(err.Error(), "not configured") {
-			fmt.Println("❌ IDrive E2 provider is not configured.")
-			fmt.Println("   Run: ./obscure provider add idrive")
-			return
-		}
-		if strings.Contains(err.Error(), "disabled") {
-			fmt.Println("❌ IDrive E2 provider is disabled.")
-			fmt.Println("   Complete the configuration to enable it.")
-			fmt.Println("   Run: ./obscure provider add idrive")
-			return
-		}
-		fmt.Println("❌ Failed to load IDrive E2 config:", err)
+		fmt.Println("❌", err)
 		return
 	}
-
-	// List files using IDrive E2 client
-	files, err := idriveClient.ListFiles(ctx, prefix)
+	repo, err := repository.Open(ctx, backend, username, password, false)
 	if err != nil {
-		// Comprehensive error handling for IDrive E2-specific issues
-		errMsg := err.Error()
-
-		// Check for endpoint/URL issues
-		if strings.Contains(errMsg, "tls: failed to verify certificate") {
-			fmt.Println("❌ IDrive E2 endpoint certificate verification failed.")
-			fmt.Println("   This usually means the endpoint URL is incorrect.")
-			fmt.Println("   Check your IDrive E2 bucket's endpoint.")
-			fmt.Println("   Run: ./obscure provider add idrive to update the endpoint")
-			return
-		}
-
-		if strings.Contains(errMsg, "no such host") || strings.Contains(errMsg, "dial tcp") {
-			fmt.Println("❌ Cannot connect to IDrive E2 endpoint.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect endpoint URL")
-			fmt.Println("   - Network connectivity problem")
-			fmt.Println("   - IDrive E2 service is down")
-			fmt.Println("   Run: ./obscure provider add idrive to check/update endpoint")
-			return
-		}
-
-		if strings.Contains(errMsg, "exceeded maximum number of attempts") {
-			fmt.Println("❌ IDrive E2 connection timeout.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect endpoint URL")
-			fmt.Println("   - Network connectivity problem")
-			fmt.Println("   - IDrive E2 service is slow or down")
-			fmt.Println("   Run: ./obscure provider add idrive to check/update endpoint")
-			return
-		}
-
-		if strings.Contains(errMsg, "InvalidAccessKeyId") {
-			fmt.Println("❌ Invalid IDrive E2 Access Key ID.")
-			fmt.Println("   Check your IDrive E2 Access Key ID.")
-			fmt.Println("   Run: ./obscure provider add idrive to update credentials")
-			return
-		}
-
-		if strings.Contains(errMsg, "SignatureDoesNotMatch") {
-			fmt.Println("❌ Invalid IDrive E2 Secret Access Key.")
-			fmt.Println("   Check your IDrive E2 Secret Access Key.")
-			fmt.Println("   Run: ./obscure provider add idrive to update credentials")
-			return
-		}
-
-		if strings.Contains(errMsg, "NoSuchBucket") {
-			fmt.Println("❌ IDrive E2 bucket not found.")
-			fmt.Println("   Check your bucket name.")
-			fmt.Println("   Run: ./obscure provider add idrive to update bucket name")
-			return
-		}
-
-		if strings.Contains(errMsg, "AccessDenied") {
-			fmt.Println("❌ Access denied to IDrive E2 bucket.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect credentials")
-			fmt.Println("   - Bucket doesn't exist")
-			fmt.Println("   - Credentials don't have access to this bucket")
-			fmt.Println("   Check your IDrive E2 credentials.")
-			return
-		}
-
-		// Generic error with suggestion to check configuration
-		fmt.Println("❌ Failed to list IDrive E2 backups:", err)
-		fmt.Println("   This might be due to:")
-		fmt.Println("   - Incorrect endpoint URL")
-		fmt.Println("   - Invalid credentials")
-		fmt.Println("   - Network connectivity issues")
-		fmt.Println("   Run: ./obscure provider add idrive to reconfigure")
+		fmt.Println("❌", err)
 		return
 	}
-
-	// Get metadata for each file
-	metadata := make(map[string]bool)
-	for _, file := range files {
-		// For IDrive E2, we'll need to get metadata differently since it's S3-compatible
-		// For now, we'll assume all files are not direct backups
-		// TODO: Implement proper metadata retrieval for IDrive E2
-		metadata[file] = false
+	defer repo.Close()
+	snapshot, err := repo.LoadSnapshot(ctx, username, tag, version)
+	if err != nil {
+		fmt.Println("❌ Failed to load snapshot:", err)
+		return
 	}
 
-	printBackups(files, metadata)
+	m := &manifest.Manifest{Tag: snapshot.Tag, Version: snapshot.Version, CreatedAt: snapshot.CreatedAt, SourcePath: snapshot.Source}
+	for _, file := range snapshot.Files {
+		entry := manifest.Entry{Path: file.Path, Mode: file.Mode, ModTime: file.ModTime, Size: file.Size, Link: file.Link}
+		switch {
+		case file.Mode.IsDir():
+			entry.Type = manifest.TypeDir
+		case file.Mode&os.ModeSymlink != 0:
+			entry.Type = manifest.TypeSymlink
+		default:
+			entry.Type = manifest.TypeFile
+		}
+		m.Files = append(m.Files, entry)
+	}
+	printManifest(m, tag, version)
 }
 
-func listFromS3Compatible(prefix string) {
-	ctx := context.Background()
-	s3CompatibleClient, err := strg.NewS3CompatibleClient(ctx, "s3-compatible")
-	if err != nil {
-		// Check if it's a configuration error and provide helpful guidance
-		if strings.Contains(err.Error(), "configuration incomplete") {
-			fmt.Println("❌ S3-compatible provider is not properly configured.")
-			fmt.Println("   Missing required configuration fields.")
-			fmt.Println("   Run: ./obscure provider add s3-compatible")
-			fmt.Println("   Or check configuration with: ./obscure provider list")
-			return
-		}
-		if strings.Contains(err.Error(), "not configured") {
-			fmt.Println("❌ S3-compatible provider is not configured.")
-			fmt.Println("   Run: ./obscure provider add s3-compatible")
-			return
-		}
-		if strings.Contains(err.Error(), "disabled") {
-			fmt.Println("❌ S3-compatible provider is disabled.")
-			fmt.Println("   Complete the configuration to enable it.")
-			fmt.Println("   Run: ./obscure provider add s3-compatible")
-			return
-		}
-		fmt.Println("❌ Failed to load S3-compatible config:", err)
-		return
+func printManifest(m *manifest.Manifest, tag, version string) {
+	yellow := color.New(color.FgYellow, color.Bold).SprintFunc()
+
+	fmt.Printf("📦 %s\n", yellow(tag+"/"+version))
+	if !m.CreatedAt.IsZero() {
+		fmt.Printf("   Created:  %s\n", m.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	}
+	if m.SourcePath != "" || (m.Host != "" && len(m.Sources) == 0 && m.Name == "") {
+		fmt.Printf("   Source:   %s:%s\n", m.Host, m.SourcePath)
+	}
+	if m.Name != "" {
+		fmt.Printf("   Source:   %s:stdin (%s)\n", m.Host, m.Name)
+	}
+	for _, source := range m.Sources {
+		fmt.Printf("   Source:   %s:%s -> %s/\n", m.Host, source.Path, source.Root)
 	}
+	if m.ObscureVersion != "" {
+		fmt.Printf("   Obscure:  %s\n", m.ObscureVersion)
+	}
+	fmt.Println()
 
-	// List files using S3-compatible client
-	files, err := s3CompatibleClient.ListFiles(ctx, prefix)
-	if err != nil {
-		// Comprehensive error handling for S3-compatible-specific issues
-		errMsg := err.Error()
-
-		// Check for endpoint/URL issues
-		if strings.Contains(errMsg, "tls: failed to verify certificate") {
-			fmt.Println("❌ S3-compatible endpoint certificate verification failed.")
-			fmt.Println("   This usually means the endpoint URL is incorrect.")
-			fmt.Println("   Check your S3-compatible bucket's endpoint.")
-			fmt.Println("   Run: ./obscure provider add s3-compatible to update the endpoint")
-			return
+	// Totals are recounted so that format 1 manifests and snapshots show them too
+	var files, dirs int
+	var total int64
+	for _, entry := range m.Files {
+		switch {
+		case entry.IsFile():
+			files++
+			total += entry.Size
+		case entry.Type == manifest.TypeDir:
+			dirs++
 		}
-
-		if strings.Contains(errMsg, "no such host") || strings.Contains(errMsg, "dial tcp") {
-			fmt.Println("❌ Cannot connect to S3-compatible endpoint.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect endpoint URL")
-			fmt.Println("   - Network connectivity problem")
-			fmt.Println("   - S3-compatible service is down")
-			fmt.Println("   Run: ./obscure provider add s3-compatible to check/update endpoint")
-			return
+		path := entry.Path
+		if path == "." && m.SourcePath != "" {
+			path = filepath.Base(m.SourcePath)
 		}
-
-		if strings.Contains(errMsg, "exceeded maximum number of attempts") {
-			fmt.Println("❌ S3-compatible connection timeout.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect endpoint URL")
-			fmt.Println("   - Network connectivity problem")
-			fmt.Println("   - S3-compatible service is slow or down")
-			fmt.Println("   Run: ./obscure provider add s3-compatible to check/update endpoint")
-			return
+		if path == "." && m.Name != "" {
+			path = m.Name
 		}
-
-		if strings.Contains(errMsg, "InvalidAccessKeyId") {
-			fmt.Println("❌ Invalid S3-compatible Access Key ID.")
-			fmt.Println("   Check your S3-compatible Access Key ID.")
-			fmt.Println("   Run: ./obscure provider add s3-compatible to update credentials")
-			return
+		if entry.Link != "" {
+			path += " -> " + entry.Link
 		}
-
-		if strings.Contains(errMsg, "SignatureDoesNotMatch") {
-			fmt.Println("❌ Invalid S3-compatible Secret Access Key.")
-			fmt.Println("   Check your S3-compatible Secret Access Key.")
-			fmt.Println("   Run: ./obscure provider add s3-compatible to update credentials")
-			return
+		modTime := ""
+		if !entry.ModTime.IsZero() {
+			modTime = entry.ModTime.Local().Format("2006-01-02 15:04")
 		}
-
-		if strings.Contains(errMsg, "NoSuchBucket") {
-			fmt.Println("❌ S3-compatible bucket not found.")
-			fmt.Println("   Check your bucket name.")
-			fmt.Println("   Run: ./obscure provider add s3-compatible to update bucket name")
-			return
+		size := ""
+		if entry.IsFile() {
+			size = FormatBytes(entry.Size)
 		}
+		fmt.Printf("%-11s %10s  %-16s  %s\n", entry.Mode, size, modTime, path)
+	}
 
-		if strings.Contains(errMsg, "AccessDenied") {
-			fmt.Println("❌ Access denied to S3-compatible bucket.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect credentials")
-			fmt.Println("   - Bucket doesn't exist")
-			fmt.Println("   - Credentials don't have access to this bucket")
-			fmt.Println("   Check your S3-compatible credentials.")
-			return
-		}
+	fmt.Printf("\n📊 %d files, %d directories, %s\n", files, dirs, FormatBytes(total))
+}
 
-		// Generic error with suggestion to check configuration
-		fmt.Println("❌ Failed to list S3-compatible backups:", err)
-		fmt.Println("   This might be due to:")
-		fmt.Println("   - Incorrect endpoint URL")
-		fmt.Println("   - Invalid credentials")
-		fmt.Println("   - Network connectivity issues")
-		fmt.Println("   Run: ./obscure provider add s3-compatible to reconfigure")
+// printProviderConfigError explains why a provider client could not be created
+func printProviderConfigError(providerKey string, err error) {
+	name := strg.DisplayName(providerKey)
+
+	// Check if it's a configuration error and provide helpful guidance
+	if strings.Contains(err.Error(), "configuration incomplete") {
+		fmt.Printf("❌ %s provider is not properly configured.\n", name)
+		fmt.Println("   Missing required configuration fields.")
+		fmt.Printf("   Run: ./obscure provider add %s\n", providerKey)
+		fmt.Println("   Or check configuration with: ./obscure provider list")
 		return
 	}
-
-	// Get metadata for each file
-	metadata := make(map[string]bool)
-	for _, file := range files {
-		// For S3-compatible, we'll need to get metadata differently since it's S3-compatible
-		// For now, we'll assume all files are not direct backups
-		// TODO: Implement proper metadata retrieval for S3-compatible
-		metadata[file] = false
+	if strings.Contains(err.Error(), "not configured") {
+		fmt.Printf("❌ %s provider is not configured.\n", name)
+		fmt.Printf("   Run: ./obscure provider add %s\n", providerKey)
+		return
 	}
-
-	printBackups(files, metadata)
-}
-
-func listFromStorj(prefix string) {
-	ctx := context.Background()
-	storjClient, err := strg.NewStorjClient(ctx, "storj")
-	if err != nil {
-		// Check if it's a configuration error and provide helpful guidance
-		if strings.Contains(err.Error(), "configuration incomplete") {
-			fmt.Println("❌ Storj provider is not properly configured.")
-			fmt.Println("   Missing required configuration fields.")
-			fmt.Println("   Run: ./obscure provider add storj")
-			fmt.Println("   Or check configuration with: ./obscure provider list")
-			return
-		}
-		if strings.Contains(err.Error(), "not configured") {
-			fmt.Println("❌ Storj provider is not configured.")
-			fmt.Println("   Run: ./obscure provider add storj")
-			return
-		}
-		if strings.Contains(err.Error(), "disabled") {
-			fmt.Println("❌ Storj provider is disabled.")
-			fmt.Println("   Complete the configuration to enable it.")
-			fmt.Println("   Run: ./obscure provider add storj")
-			return
-		}
-		fmt.Println("❌ Failed to load Storj config:", err)
+	if strings.Contains(err.Error(), "disabled") {
+		fmt.Printf("❌ %s provider is disabled.\n", name)
+		fmt.Println("   Complete the configuration to enable it.")
+		fmt.Printf("   Run: ./obscure provider add %s\n", providerKey)
 		return
 	}
+	fmt.Printf("❌ Failed to load %s config: %v\n", name, err)
+}
 
-	// List files using Storj client
-	files, err := storjClient.ListFiles(ctx, prefix)
-	if err != nil {
-		// Comprehensive error handling for Storj-specific issues
-		errMsg := err.Error()
+// printProviderListError gives guidance for common listing failures
+func printProviderListError(providerKey string, err error) {
+	name := strg.DisplayName(providerKey)
+	errMsg := err.Error()
 
-		if strings.Contains(errMsg, "NoSuchBucket") {
-			fmt.Println("❌ Storj bucket not found.")
-			fmt.Println("   Check your bucket name.")
-			fmt.Println("   Run: ./obscure provider add storj to update bucket name")
-			return
-		}
-
-		if strings.Contains(errMsg, "AccessDenied") {
-			fmt.Println("❌ Access denied to Storj bucket.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect credentials")
-			fmt.Println("   - Bucket doesn't exist")
-			fmt.Println("   - Credentials don't have access to this bucket")
-			fmt.Println("   Check your Storj credentials.")
-			return
-		}
+	// Check for endpoint/URL issues
+	if strings.Contains(errMsg, "tls: failed to verify certificate") {
+		fmt.Printf("❌ %s endpoint certificate verification failed.\n", name)
+		fmt.Println("   This usually means the endpoint URL is incorrect.")
+		fmt.Printf("   Run: ./obscure provider add %s to update the endpoint\n", providerKey)
+		return
+	}
 
-		// Generic error with suggestion to check configuration
-		fmt.Println("❌ Failed to list Storj backups:", err)
-		fmt.Println("   This might be due to:")
+	if strings.Contains(errMsg, "no such host") || strings.Contains(errMsg, "dial tcp") {
+		fmt.Printf("❌ Cannot connect to %s endpoint.\n", name)
+		fmt.Println("   Possible issues:")
 		fmt.Println("   - Incorrect endpoint URL")
-		fmt.Println("   - Invalid credentials")
-		fmt.Println("   - Network connectivity issues")
-		fmt.Println("   Run: ./obscure provider add storj to reconfigure")
+		fmt.Println("   - Network connectivity problem")
+		fmt.Printf("   - %s service is down\n", name)
+		fmt.Printf("   Run: ./obscure provider add %s to check/update endpoint\n", providerKey)
 		return
 	}
 
-	// Get metadata for each file
-	metadata := make(map[string]bool)
-	for _, file := range files {
-		// For Storj, we'll need to get metadata differently since it's S3-compatible
-		// For now, we'll assume all files are not direct backups
-		// TODO: Implement proper metadata retrieval for Storj
-		metadata[file] = false
+	if strings.Contains(errMsg, "exceeded maximum number of attempts") {
+		fmt.Printf("❌ %s connection timeout.\n", name)
+		fmt.Println("   Possible issues:")
+		fmt.Println("   - Incorrect region or endpoint URL")
+		fmt.Println("   - Network connectivity problem")
+		fmt.Printf("   - %s service is slow or down\n", name)
+		fmt.Printf("   Run: ./obscure provider add %s to check configuration\n", providerKey)
+		return
 	}
 
-	printBackups(files, metadata)
-}
+	if strings.Contains(errMsg, "InvalidAccessKeyId") || strings.Contains(errMsg, "invalid_grant") {
+		fmt.Printf("❌ Invalid %s credentials.\n", name)
+		fmt.Println("   Check your access key ID or service account in the provider console.")
+		fmt.Printf("   Run: ./obscure provider add %s to update credentials\n", providerKey)
+		return
+	}
 
-func listFromFilebaseIPFS(prefix string) {
-	ctx := context.Background()
-	s3CompatibleClient, err := strg.NewS3CompatibleClient(ctx, "filebase-ipfs")
-	if err != nil {
-		if strings.Contains(err.Error(), "configuration incomplete") {
-			fmt.Println("❌ Filebase+IPFS provider is not properly configured.")
-			fmt.Println("   Missing required configuration fields.")
-			fmt.Println("   Run: ./obscure provider add filebase-ipfs")
-			fmt.Println("   Or check configuration with: ./obscure provider list")
-			return
-		}
-		if strings.Contains(err.Error(), "not configured") {
-			fmt.Println("❌ Filebase+IPFS provider is not configured.")
-			fmt.Println("   Run: ./obscure provider add filebase-ipfs")
-			return
-		}
-		if strings.Contains(err.Error(), "disabled") {
-			fmt.Println("❌ Filebase+IPFS provider is disabled.")
-			fmt.Println("   Complete the configuration to enable it.")
-			fmt.Println("   Run: ./obscure provider add filebase-ipfs")
-			return
-		}
-		fmt.Println("❌ Failed to load Filebase+IPFS config:", err)
+	if strings.Contains(errMsg, "SignatureDoesNotMatch") {
+		fmt.Printf("❌ Invalid %s secret key.\n", name)
+		fmt.Println("   Check your secret key in the provider console.")
+		fmt.Printf("   Run: ./obscure provider add %s to update credentials\n", providerKey)
 		return
 	}
 
-	files, err := s3CompatibleClient.ListFiles(ctx, prefix)
-	if err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "NoSuchBucket") {
-			fmt.Println("❌ Filebase+IPFS bucket not found.")
-			fmt.Println("   Check your bucket name.")
-			fmt.Println("   Run: ./obscure provider add filebase-ipfs to update bucket name")
-			return
-		}
-		if strings.Contains(errMsg, "AccessDenied") {
-			fmt.Println("❌ Access denied to Filebase+IPFS bucket.")
-			fmt.Println("   Possible issues:")
-			fmt.Println("   - Incorrect credentials")
-			fmt.Println("   - Bucket doesn't exist")
-			fmt.Println("   - Credentials don't have access to this bucket")
-			fmt.Println("   Check your Filebase+IPFS credentials.")
-			return
-		}
-		fmt.Println("❌ Failed to list Filebase+IPFS backups:", err)
-		fmt.Println("   This might be due to:")
-		fmt.Println("   - Incorrect endpoint URL")
-		fmt.Println("   - Invalid credentials")
-		fmt.Println("   - Network connectivity issues")
-		fmt.Println("   Run: ./obscure provider add filebase-ipfs to reconfigure")
+	if strings.Contains(errMsg, "invalid character") || strings.Contains(errMsg, "unexpected end of JSON") {
+		fmt.Printf("❌ Invalid %s credentials file.\n", name)
+		fmt.Println("   The JSON file appears to be corrupted or invalid.")
+		fmt.Printf("   Run: ./obscure provider add %s to update credentials\n", providerKey)
 		return
 	}
 
-	metadata := make(map[string]bool)
-	for _, file := range files {
-		metadata[file] = false
+	if strings.Contains(errMsg, "NoSuchBucket") || strings.Contains(errMsg, "bucket doesn't exist") {
+		fmt.Printf("❌ %s bucket not found.\n", name)
+		fmt.Println("   Check your bucket name in the provider console.")
+		fmt.Printf("   Run: ./obscure provider add %s to update bucket name\n", providerKey)
+		return
+	}
+
+	if strings.Contains(errMsg, "AccessDenied") || strings.Contains(errMsg, "permission denied") {
+		fmt.Printf("❌ Access denied to %s bucket.\n", name)
+		fmt.Println("   Possible issues:")
+		fmt.Println("   - Incorrect credentials or permissions")
+		fmt.Println("   - Bucket doesn't exist")
+		fmt.Println("   - Credentials don't have access to this bucket")
+		return
 	}
 
-	printBackups(files, metadata)
+	// Generic error with suggestion to check configuration
+	fmt.Printf("❌ Failed to list %s backups: %v\n", name, err)
+	fmt.Println("   This might be due to:")
+	fmt.Println("   - Incorrect region or endpoint URL")
+	fmt.Println("   - Invalid credentials")
+	fmt.Println("   - Network connectivity issues")
+	fmt.Printf("   Run: ./obscure provider add %s to reconfigure\n", providerKey)
 }
 
-func printBackups(files []string, metadata map[string]bool) {
-	if len(files) == 0 {
+func printBackups(backups []storedBackup, names *naming.Namer) {
+	var objects []storedBackup
+	for _, backup := range backups {
+		// Manifests are shown with ls <tag/version>
+		if backup.Extension != manifest.Extension {
+			objects = append(objects, backup)
+		}
+	}
+	if len(objects) == 0 {
 		fmt.Println("📦 No backups found.")
 		return
 	}
 
 	// Debug: Show actual file paths
 	fmt.Println("🔍 Debug - Actual file paths found:")
-	for _, file := range files {
-		fmt.Printf("   %s\n", file)
+	for _, obj := range objects {
+		fmt.Printf("   %s\n", obj.Key)
 	}
 	fmt.Println()
 
 	grouped := make(map[string][]string)
 
-	for _, file := range files {
-		parts := strings.Split(file, "/")
-		if len(parts) < 4 { // expect: backups/username/tag/filename
-			continue
-		}
-
-		// Get the tag and filename
-		tag := parts[len(parts)-2] // Get tag from path
-		filename := parts[len(parts)-1]
-
-		// Find the last dot to separate extension
-		lastDotIndex := strings.LastIndex(filename, ".")
-		if lastDotIndex == -1 {
-			continue
-		}
-
-		// Split into name and extension
-		name := filename[:lastDotIndex]
-		extension := filename[lastDotIndex+1:]
-
-		// Get the version number from the filename
-		// It could be in format: 2.1_26492030 or 2.6_testdata
-		nameParts := strings.Split(name, "_")
-		if len(nameParts) < 1 {
-			continue
-		}
-
-		version := nameParts[0] // Get the version number (e.g., "2.1" or "2.6")
+	for _, obj := range objects {
+		extension := obj.Extension
 
 		// Check if this is a direct backup from metadata
-		isDirect := metadata[file]
+		metadata, _ := names.OpenMetadata(obj.Metadata)
+		isDirect := metadata["is_direct"] == "true"
 		// Only change extension if metadata indicates it's a direct backup
 		if isDirect {
 			extension = "tar"
 		}
 
 		// Format the version string to show version_tag.extension
-		versionStr := fmt.Sprintf("%s_%s.%s", version, tag, extension)
-		grouped[tag] = append(grouped[tag], versionStr)
+		versionStr := fmt.Sprintf("%s_%s.%s", obj.Version, obj.Tag, extension)
+		grouped[obj.Tag] = append(grouped[obj.Tag], versionStr)
 	}
 
 	greenBold := color.New(color.FgGreen, color.Bold).SprintFunc()
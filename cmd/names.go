@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shah1011/obscure/internal/naming"
+	"github.com/shah1011/obscure/internal/repository"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// storedBackup is an object holding a backup, its manifest or its snapshot,
+// with the name it maps to
+type storedBackup struct {
+	strg.ObjectInfo
+	naming.Name
+}
+
+// checkTag refuses the tags that would reach into the keyring or the chunk
+// repository
+func checkTag(tag string) error {
+	if naming.Reserved(tag) {
+		return fmt.Errorf("'%s' holds your keyring or chunk repository and cannot be used as a backup tag", tag)
+	}
+	return nil
+}
+
+// listBackups returns the objects of tag's backups in backend, or of every
+// tag if it is empty
+func listBackups(ctx context.Context, backend strg.Backend, names *naming.Namer, tag string) ([]storedBackup, error) {
+	objects, err := backend.List(ctx, names.ListPrefix(tag))
+	if err != nil {
+		return nil, err
+	}
+	var backups []storedBackup
+	for _, obj := range objects {
+		name, ok := names.Parse(obj.Key)
+		if !ok || (tag != "" && name.Tag != tag) {
+			continue
+		}
+		backups = append(backups, storedBackup{ObjectInfo: obj, Name: name})
+	}
+	return backups, nil
+}
+
+// renameObject moves an object from one key to another, sealing its
+// metadata with names.
+// Backends have no server-side copy, so the object passes through this
+// machine.
+func renameObject(ctx context.Context, backend strg.Backend, names *naming.Namer, from, to string) error {
+	reader, info, err := backend.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	metadata, err := names.SealMetadata(info.Metadata)
+	if err != nil {
+		return err
+	}
+	if err := backend.Put(ctx, to, reader, info.Size, metadata); err != nil {
+		return err
+	}
+	return backend.Delete(ctx, from)
+}
+
+var keyPrivateNamesCmd = &cobra.Command{
+	Use:   "private-names",
+	Short: "Encrypt the names and metadata of backups in this bucket",
+	Long: `Backups are normally stored as backups/<user>/<tag>/<version>_<tag>.obscure,
+with their tag and version in the object metadata too, so the provider can
+read your project names and how often you back them up.
+
+With private names, tag, version and metadata are encrypted with a key kept
+in the keyring, and every backup becomes a single opaque object under
+backups/<user>/. ls, restore, verify, rm and rmdir keep taking the usual
+names, and ask for the keyring password to map them. Object sizes and upload
+times stay visible to the provider.
+
+Turning private names on renames the existing backups, which downloads and
+uploads each of them again. If that is interrupted, run the command again
+to finish. Private names cannot be turned off, and repository backups
+(--repo) cannot be made while they are on; existing ones keep their names.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		backend, username, providerKey, ok := keyringBackend()
+		if !ok {
+			return
+		}
+		ctx := context.Background()
+
+		k, ok := unlockKeyring(ctx, backend, username, providerKey)
+		if !ok {
+			return
+		}
+		if k.NamesKey() == nil {
+			fmt.Printf("❓ Encrypt the names of all backups in %s? Existing backups will be renamed. (Y/N): ", strg.DisplayName(providerKey))
+			var input string
+			fmt.Scanln(&input)
+			input = strings.TrimSpace(strings.ToLower(input))
+			if input != "y" && input != "yes" {
+				fmt.Println("❎ Cancelled.")
+				return
+			}
+			if err := k.EnablePrivateNames(); err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+			if err := k.Save(ctx, backend, username); err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+			fmt.Println("🔒 Private names are on. New backups get encrypted names.")
+		}
+
+		names, err := naming.Private(username, k.NamesKey())
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		// Only backups that still have readable names are left to rename
+		backups, err := listBackups(ctx, backend, naming.Plain(username), "")
+		if err != nil {
+			printProviderListError(providerKey, err)
+			return
+		}
+		renamed, skipped, failed := 0, 0, 0
+		for _, backup := range backups {
+			if backup.Extension == repository.SnapshotExtension {
+				skipped++
+				continue
+			}
+			fmt.Printf("🔒 %s/%s (%s)\n", backup.Tag, backup.Version, backup.Extension)
+			if err := renameObject(ctx, backend, names, backup.Key, names.Key(backup.Tag, backup.Version, backup.Extension)); err != nil {
+				fmt.Printf("⚠️  Failed to rename %s: %v\n", backup.Key, err)
+				failed++
+				continue
+			}
+			renamed++
+		}
+
+		fmt.Printf("✅ Renamed %d objects.\n", renamed)
+		if skipped > 0 {
+			fmt.Printf("ℹ️  %d repository snapshots keep their readable names.\n", skipped)
+		}
+		if failed > 0 {
+			fmt.Printf("⚠️  %d objects could not be renamed. Run 'obscure key private-names' again to retry.\n", failed)
+		}
+	},
+}
+
+func init() {
+	keyCmd.AddCommand(keyPrivateNamesCmd)
+	addPasswordFlags(keyPrivateNamesCmd)
+}
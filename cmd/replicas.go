@@ -0,0 +1,471 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/keyring"
+	"github.com/shah1011/obscure/internal/naming"
+	"github.com/shah1011/obscure/internal/repository"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// replica is one provider's copy of a backup object
+type replica struct {
+	object strg.ObjectInfo
+	hash   string
+	err    error
+}
+
+// replicaSet is every provider's copy of one backup object, matched by
+// name, since private names give the same backup a different key in every
+// bucket
+type replicaSet struct {
+	name     naming.Name
+	replicas map[string]*replica
+}
+
+// replicaBucket is one provider taking part in the comparison
+type replicaBucket struct {
+	provider string
+	backend  strg.Backend
+	names    *naming.Namer
+}
+
+// signature is what two replicas have to share to count as the same
+func (r *replica) signature() string {
+	if r.err != nil {
+		return "error"
+	}
+	return fmt.Sprintf("%d:%s", r.object.Size, r.hash)
+}
+
+// reference picks the replica the others should match: the one most
+// providers agree on. It returns "" when no single version has a majority.
+func (s *replicaSet) reference(buckets []replicaBucket) string {
+	counts := make(map[string]int)
+	for _, r := range s.replicas {
+		if r.err == nil {
+			counts[r.signature()]++
+		}
+	}
+	best, tied := 0, false
+	var bestSignature string
+	for signature, count := range counts {
+		switch {
+		case count > best:
+			best, bestSignature, tied = count, signature, false
+		case count == best:
+			tied = true
+		}
+	}
+	if best == 0 || tied {
+		return ""
+	}
+	for _, bucket := range buckets {
+		if r, ok := s.replicas[bucket.provider]; ok && r.err == nil && r.signature() == bestSignature {
+			return bucket.provider
+		}
+	}
+	return ""
+}
+
+// state describes how the replicas of s differ
+func (s *replicaSet) state(buckets []replicaBucket, checksum bool) string {
+	signatures := make(map[string]bool)
+	sizes := make(map[int64]bool)
+	for _, r := range s.replicas {
+		if r.err != nil {
+			return "unreadable"
+		}
+		signatures[r.signature()] = true
+		sizes[r.object.Size] = true
+	}
+	switch {
+	case len(sizes) > 1:
+		return "size differs"
+	case len(signatures) > 1 && checksum:
+		return "checksum differs"
+	case len(s.replicas) < len(buckets):
+		return "missing"
+	}
+	return "ok"
+}
+
+// openReplicaBuckets opens the providers named by --providers, or every
+// enabled one. Buckets with private names share one password prompt.
+func openReplicaBuckets(ctx context.Context, cmd *cobra.Command, username string) ([]replicaBucket, error) {
+	providers, err := cfg.LoadUserProviders()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provider configuration: %v", err)
+	}
+	keys, _ := cmd.Flags().GetStringSlice("providers")
+	if len(keys) == 0 {
+		keys = enabledProviders(providers)
+	}
+	if len(keys) < 2 {
+		return nil, errors.New("replicas need at least two enabled providers")
+	}
+
+	password := sync.OnceValues(func() (string, error) {
+		return readPassword("🔐 Enter decryption password:", false)
+	})
+	var buckets []replicaBucket
+	for _, key := range keys {
+		backend, err := openProvider(ctx, providers, key)
+		if err != nil {
+			return nil, err
+		}
+		names, err := backupNamer(ctx, backend, username, lazyKeyring(ctx, backend, username, password))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strg.DisplayName(key), err)
+		}
+		buckets = append(buckets, replicaBucket{provider: key, backend: backend, names: names})
+	}
+	return buckets, nil
+}
+
+// collectReplicas lists tag's backups, or every backup, in each bucket and
+// matches them up by name. With checksum, every replica is downloaded to
+// hash it, parallel at a time.
+func collectReplicas(ctx context.Context, buckets []replicaBucket, tag string, checksum bool, parallel int) ([]*replicaSet, error) {
+	byName := make(map[naming.Name]*replicaSet)
+	for _, bucket := range buckets {
+		backups, err := listBackups(ctx, bucket.backend, bucket.names, tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", strg.DisplayName(bucket.provider), err)
+		}
+		for _, backup := range backups {
+			set, ok := byName[backup.Name]
+			if !ok {
+				set = &replicaSet{name: backup.Name, replicas: make(map[string]*replica)}
+				byName[backup.Name] = set
+			}
+			set.replicas[bucket.provider] = &replica{object: backup.ObjectInfo}
+		}
+	}
+
+	var sets []*replicaSet
+	for _, set := range byName {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		a, b := sets[i].name, sets[j].name
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Extension < b.Extension
+	})
+	if !checksum {
+		return sets, nil
+	}
+
+	type job struct {
+		backend strg.Backend
+		replica *replica
+	}
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for range max(parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.replica.hash, j.replica.err = strg.ObjectHash(ctx, j.backend, j.replica.object.Key)
+			}
+		}()
+	}
+	fmt.Println("🔍 Hashing every replica...")
+	for _, set := range sets {
+		for _, bucket := range buckets {
+			if r, ok := set.replicas[bucket.provider]; ok {
+				jobs <- job{bucket.backend, r}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return sets, nil
+}
+
+// printReplicas prints a row per backup object and a column per provider,
+// and returns how many objects are not the same everywhere
+func printReplicas(sets []*replicaSet, buckets []replicaBucket, checksum, all bool) int {
+	nameWidth := len("BACKUP")
+	for _, set := range sets {
+		nameWidth = max(nameWidth, len(set.name.Tag)+1+len(set.name.Version))
+	}
+	header := fmt.Sprintf("%-*s %-9s", nameWidth, "BACKUP", "TYPE")
+	for _, bucket := range buckets {
+		header += fmt.Sprintf(" %12s", strings.ToUpper(bucket.provider))
+	}
+	fmt.Println(header + "  STATE")
+
+	inconsistent := 0
+	for _, set := range sets {
+		state := set.state(buckets, checksum)
+		if state != "ok" {
+			inconsistent++
+		} else if !all {
+			continue
+		}
+		row := fmt.Sprintf("%-*s %-9s", nameWidth, set.name.Tag+"/"+set.name.Version, set.name.Extension)
+		for _, bucket := range buckets {
+			cell := "missing"
+			if r, ok := set.replicas[bucket.provider]; ok {
+				cell = FormatBytes(r.object.Size)
+				if r.err != nil {
+					cell = "error"
+				}
+			}
+			row += fmt.Sprintf(" %12s", cell)
+		}
+		fmt.Println(row + "  " + state)
+	}
+	for _, set := range sets {
+		for _, bucket := range buckets {
+			if r, ok := set.replicas[bucket.provider]; ok && r.err != nil {
+				fmt.Printf("❌ %s/%s on %s: %v\n", set.name.Tag, set.name.Version, strg.DisplayName(bucket.provider), r.err)
+			}
+		}
+	}
+	return inconsistent
+}
+
+// copyReplica copies a backup object from one bucket to another, naming it
+// and sealing its metadata the way the destination bucket does
+func copyReplica(ctx context.Context, from, to replicaBucket, name naming.Name, key string) (int64, error) {
+	reader, info, err := from.backend.Get(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download from %s: %w", strg.DisplayName(from.provider), err)
+	}
+	defer reader.Close()
+	metadata, err := from.names.OpenMetadata(info.Metadata)
+	if err != nil {
+		return 0, err
+	}
+	if metadata, err = to.names.SealMetadata(metadata); err != nil {
+		return 0, err
+	}
+
+	target := to.names.Key(name.Tag, name.Version, name.Extension)
+	if err := to.backend.Put(ctx, target, reader, info.Size, metadata); err != nil {
+		return 0, fmt.Errorf("failed to upload to %s: %w", strg.DisplayName(to.provider), err)
+	}
+	copied, err := to.backend.Stat(ctx, target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check the copy in %s: %w", strg.DisplayName(to.provider), err)
+	}
+	if copied.Size != info.Size {
+		return 0, fmt.Errorf("the copy in %s is %d bytes, expected %d", strg.DisplayName(to.provider), copied.Size, info.Size)
+	}
+	return info.Size, nil
+}
+
+// ensureKeyring copies the keyring of from to to if to has none, so that
+// backups sealed to it can be restored from either
+func ensureKeyring(ctx context.Context, from, to replicaBucket, username string) error {
+	exists, err := strg.Exists(ctx, to.backend, keyring.Key(username))
+	if err != nil || exists {
+		return err
+	}
+	exists, err = strg.Exists(ctx, from.backend, keyring.Key(username))
+	if err != nil || !exists {
+		return err
+	}
+	if _, err := strg.CopyObject(ctx, from.backend, to.backend, keyring.Key(username)); err != nil {
+		return err
+	}
+	fmt.Printf("🔑 Copied the keyring from %s to %s\n", strg.DisplayName(from.provider), strg.DisplayName(to.provider))
+	return nil
+}
+
+// replicaArgs reads the flags shared by replicas status and repair, and
+// compares the replicas
+func replicaArgs(cmd *cobra.Command) (string, []replicaBucket, []*replicaSet, bool) {
+	username, err := cfg.GetSessionUsername()
+	if err != nil || username == "" {
+		fmt.Println("❌ Not logged in. Please run `obscure login` or `obscure signup`.")
+		return "", nil, nil, false
+	}
+	tag, _ := cmd.Flags().GetString("tag")
+	checksum, _ := cmd.Flags().GetBool("checksum")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	ctx := context.Background()
+	buckets, err := openReplicaBuckets(ctx, cmd, username)
+	if err != nil {
+		fmt.Println("❌", err)
+		return "", nil, nil, false
+	}
+	sets, err := collectReplicas(ctx, buckets, tag, checksum, parallel)
+	if err != nil {
+		fmt.Println("❌", err)
+		return "", nil, nil, false
+	}
+	return username, buckets, sets, true
+}
+
+var replicasCmd = &cobra.Command{
+	Use:   "replicas",
+	Short: "Check that every provider holds the same backups",
+	Long: `Backups made with 'backup --all' or copied with 'obscure copy' live in
+several providers. These commands compare what each enabled provider (or
+the ones given with --providers) holds, backup by backup, and fill in what
+is missing.
+
+Replicas are compared by size, and with --checksum by the SHA-256 of their
+content, which downloads every one of them. Every provider is sent the same
+encrypted bytes, so the checksums of healthy replicas are identical.`,
+}
+
+var replicasStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which providers hold each backup",
+	Long: `List every backup with its size in each provider, and whether the replicas
+match. Only backups that differ are listed unless --all is given.
+
+Exits with status 1 if any backup is missing or differs somewhere, so it can
+run from cron:
+  obscure replicas status --providers s3,b2 --checksum`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		checksum, _ := cmd.Flags().GetBool("checksum")
+		_, buckets, sets, ok := replicaArgs(cmd)
+		if !ok {
+			os.Exit(1)
+		}
+		if len(sets) == 0 {
+			fmt.Println("📦 No backups found.")
+			return
+		}
+		inconsistent := printReplicas(sets, buckets, checksum, all)
+		if inconsistent > 0 {
+			fmt.Printf("\n⚠️  %d of %d backup objects are not the same everywhere. Run 'obscure replicas repair' to fix them.\n", inconsistent, len(sets))
+			os.Exit(1)
+		}
+		fmt.Printf("✅ All %d backup objects are the same in %d providers.\n", len(sets), len(buckets))
+	},
+}
+
+var replicasRepairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Copy missing or differing backups from a provider that has them",
+	Long: `Copy every backup that is missing from a provider, or differs there, from
+a provider holding the version most providers agree on. When the replicas
+are split evenly there is no telling which one is right; those are listed
+and left alone. A provider without a keyring gets a copy of the source's, so
+that the backups sealed to it can be restored there.
+
+Repository snapshots depend on the chunk repository and are not repaired
+one by one; copy them with 'obscure copy --tag <tag>' instead.
+  obscure replicas repair --tag db --dry-run`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		checksum, _ := cmd.Flags().GetBool("checksum")
+		username, buckets, sets, ok := replicaArgs(cmd)
+		if !ok {
+			os.Exit(1)
+		}
+		byProvider := make(map[string]replicaBucket)
+		for _, bucket := range buckets {
+			byProvider[bucket.provider] = bucket
+		}
+
+		ctx := context.Background()
+		keyrings := make(map[string]bool)
+		repaired, failed, skipped := 0, 0, 0
+		var total int64
+		for _, set := range sets {
+			if set.state(buckets, checksum) == "ok" {
+				continue
+			}
+			label := fmt.Sprintf("%s/%s (%s)", set.name.Tag, set.name.Version, set.name.Extension)
+			if set.name.Extension == repository.SnapshotExtension {
+				fmt.Printf("⏭️  %s: repository snapshot; run 'obscure copy --tag %s' to copy it with its chunks\n", label, set.name.Tag)
+				skipped++
+				continue
+			}
+			source := set.reference(buckets)
+			if source == "" {
+				fmt.Printf("⚠️  %s: the replicas disagree and none has a majority; left alone\n", label)
+				skipped++
+				continue
+			}
+			ref := set.replicas[source]
+			for _, bucket := range buckets {
+				r, ok := set.replicas[bucket.provider]
+				if ok && r.err == nil && r.signature() == ref.signature() {
+					continue
+				}
+				if dryRun {
+					fmt.Printf("📝 %s would be copied from %s to %s\n", label, strg.DisplayName(source), strg.DisplayName(bucket.provider))
+					repaired++
+					continue
+				}
+				if !keyrings[bucket.provider] {
+					if err := ensureKeyring(ctx, byProvider[source], bucket, username); err != nil {
+						fmt.Printf("⚠️  Failed to copy the keyring to %s: %v\n", strg.DisplayName(bucket.provider), err)
+					}
+					keyrings[bucket.provider] = true
+				}
+				size, err := copyReplica(ctx, byProvider[source], bucket, set.name, ref.object.Key)
+				if err != nil {
+					fmt.Printf("❌ %s: %v\n", label, err)
+					failed++
+					continue
+				}
+				// A differing replica stored under another name, such as a
+				// readable one in a bucket that has private names since, is
+				// replaced rather than kept next to the copy
+				if ok && r.object.Key != bucket.names.Key(set.name.Tag, set.name.Version, set.name.Extension) {
+					if err := bucket.backend.Delete(ctx, r.object.Key); err != nil {
+						fmt.Printf("⚠️  Failed to delete the old replica %s: %v\n", r.object.Key, err)
+					}
+				}
+				fmt.Printf("✅ %s copied from %s to %s (%s)\n", label, strg.DisplayName(source), strg.DisplayName(bucket.provider), FormatBytes(size))
+				repaired++
+				total += size
+			}
+		}
+
+		switch {
+		case dryRun:
+			fmt.Printf("\n📝 Dry run: %d copies would be made, %d backup objects left alone.\n", repaired, skipped)
+		case repaired == 0 && failed == 0 && skipped == 0:
+			fmt.Println("✅ Every provider already holds the same backups.")
+		default:
+			fmt.Printf("\n📊 Repaired %d replicas (%s); %d failed, %d backup objects left alone.\n", repaired, FormatBytes(total), failed, skipped)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replicasCmd)
+	replicasCmd.AddCommand(replicasStatusCmd)
+	replicasCmd.AddCommand(replicasRepairCmd)
+	for _, cmd := range []*cobra.Command{replicasStatusCmd, replicasRepairCmd} {
+		cmd.Flags().StringP("tag", "t", "", "Only compare the backups of this tag")
+		cmd.Flags().StringSlice("providers", nil, "Providers to compare (default: every enabled provider)")
+		cmd.Flags().Bool("checksum", false, "Compare the SHA-256 of every replica, not just its size (downloads all of them)")
+		cmd.Flags().Int("parallel", 4, "Number of replicas hashed at once with --checksum")
+		addPasswordFlags(cmd)
+	}
+	replicasStatusCmd.Flags().Bool("all", false, "List every backup, not just the ones that differ")
+	replicasRepairCmd.Flags().Bool("dry-run", false, "List what would be copied without copying anything")
+}
@@ -1,11 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/index"
+	"github.com/shah1011/obscure/internal/manifest"
+	"github.com/shah1011/obscure/internal/naming"
+	"github.com/shah1011/obscure/internal/repository"
+	strg "github.com/shah1011/obscure/internal/storage"
 	"github.com/shah1011/obscure/utils"
 	"github.com/spf13/cobra"
 )
@@ -13,6 +24,13 @@ import (
 var restoreTag string
 var restoreVersion string
 var isDirectRestore bool
+var isSnapshotRestore bool
+var probeRestoreFormat bool
+var restorePaths []string
+var restoreTarget string
+var restoreInPlace bool
+var restoreDryRun bool
+var restoreStdout bool
 
 var restoreCmd = &cobra.Command{
 	Use:   "restore [backup_path]",
@@ -20,81 +38,135 @@ var restoreCmd = &cobra.Command{
 	Long: `Restore a backup from S3 or GCS. You can specify the backup in two ways:
 1. Using flags: --tag and --version
    Example: obscure restore --tag=testdata --version=2.9
-2. Using path format: tag/version_tag.obscure
+2. Using path format: tag/version_tag.obscure or tag/version
    Example: obscure restore testdata/2.9_testdata.obscure
 
+To restore only part of a backup, name the paths after the backup or use
+glob patterns ("**" matches any number of directories; a pattern without a
+slash matches file names at any depth):
+   obscure restore testdata/2.9 config/app.yaml
+   obscure restore testdata/2.9 --include 'src/**/*.go' --exclude '*.log'
+
+Files are restored into restored_<tag>_v<version> unless --target names
+another directory, or --in-place restores over the path the backup was made
+from. A backup of several paths restores each under its own directory, or
+with --in-place back to each original path; selective restores name paths
+including that directory (e.g. app/config.yaml). Paths that already exist are handled by --on-conflict:
+   overwrite  replace them (default)
+   skip       keep the existing file
+   rename     restore next to it as <name>.restored
+   newer      replace it only if the backed up copy is newer
+Use --dry-run to list what would be created, overwritten or skipped.
+
+Permissions, modification times, symlinks, hard links and extended
+attributes (including POSIX ACLs) are restored as they were backed up.
+File owners are restored when running as root; --ownership apply or ignore
+overrides that.
+
+Entries with absolute paths or "..", entries that would be written through
+a symlink, and symlinks pointing outside the restore directory are rejected
+and listed. --max-size and --max-entries stop a restore that grows beyond
+what you expect.
+
+--stdout writes the decrypted payload to stdout instead: the file itself for
+a backup of a single file or of stdin, or a tar stream for a directory.
+   obscure restore db/2024.05.01-03.00.00 --stdout | psql mydb
+
+Incremental backups are restored by replaying every backup from the last
+full one up to the requested version.
+
+Repository backups (made with --repo) end in .snapshot, or use --repo with
+--tag and --version.
+
 You can also combine both formats, but the flags will take precedence.`,
 	Args: func(cmd *cobra.Command, args []string) error {
-
-		// If no args provided and no flags, show error
+		// Flags alone are enough to identify the backup
 		if len(args) == 0 {
+			if restoreTag != "" && restoreVersion != "" {
+				return nil
+			}
 			return fmt.Errorf("either provide a backup path or use --tag and --version flags")
 		}
 
-		// Parse backup path if provided
-		if len(args) == 1 {
-			path := args[0]
+		// Anything after the backup path is a path to restore on its own
+		restorePaths = args[1:]
 
-			// Check if path contains a slash (tag/path format)
-			if strings.Contains(path, "/") {
-				parts := strings.Split(path, "/")
+		path := args[0]
+		var tagFromPath string
 
-				if len(parts) != 2 {
-					return fmt.Errorf("invalid path format. Expected: tag/version_tag.obscure")
-				}
+		// Check if path contains a slash (tag/path format)
+		if strings.Contains(path, "/") {
+			parts := strings.Split(path, "/")
 
-				// Only set tag if flag wasn't provided
-				if restoreTag == "" {
-					restoreTag = parts[0]
-				}
-				path = parts[1]
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid path format. Expected: tag/version_tag.obscure or tag/version")
 			}
+			tagFromPath = parts[0]
+			path = parts[1]
+		}
 
-			// Find the last dot to handle version numbers with dots
-			lastDotIndex := strings.LastIndex(path, ".")
-			if lastDotIndex == -1 {
-				return fmt.Errorf("invalid filename format. Expected: version_tag.obscure")
-			}
-
-			// Split into name and extension
-			name := path[:lastDotIndex]
-			extension := path[lastDotIndex+1:]
-
-			// Extract version and tag from filename (e.g., "2.9_testdata")
-			versionTag := strings.Split(name, "_")
-
-			if len(versionTag) != 2 {
-				return fmt.Errorf("invalid filename format. Expected: version_tag.obscure")
-			}
+		// Find the last dot to handle version numbers with dots
+		extension := ""
+		name := path
+		if lastDotIndex := strings.LastIndex(path, "."); lastDotIndex != -1 {
+			extension = path[lastDotIndex+1:]
+			name = path[:lastDotIndex]
+		}
 
-			// Only set version if flag wasn't provided
-			if restoreVersion == "" {
-				restoreVersion = versionTag[0]
+		switch extension {
+		case "tar":
+			isDirectRestore = true
+		case repository.SnapshotExtension:
+			isSnapshotRestore = true
+		case "obscure":
+		default:
+			// tag/version: the backup format is looked up in the provider
+			if tagFromPath == "" {
+				return fmt.Errorf("invalid filename format. Expected: version_tag.obscure or tag/version")
 			}
-
-			// Only set tag if flag wasn't provided and we didn't get it from the path
 			if restoreTag == "" {
-				restoreTag = versionTag[1]
+				restoreTag = tagFromPath
 			}
-
-			// Check extension
-			if extension == "tar" {
-				isDirectRestore = true
-			} else if extension != "obscure" {
-				return fmt.Errorf("unsupported backup extension: %s. Use .obscure or .tar", extension)
+			if restoreVersion == "" {
+				restoreVersion = path
 			}
+			probeRestoreFormat = true
+			return nil
+		}
 
-			// Validate that we have both tag and version
-			if restoreTag == "" || restoreVersion == "" {
-				return fmt.Errorf("could not determine tag or version from path. Use --tag and --version flags")
-			}
+		// Extract version and tag from filename (e.g., "2.9_testdata")
+		versionTag := strings.Split(name, "_")
+		if len(versionTag) != 2 {
+			return fmt.Errorf("invalid filename format. Expected: version_tag.obscure")
+		}
 
-			return nil
+		// Only set version and tag if the flags weren't provided
+		if restoreVersion == "" {
+			restoreVersion = versionTag[0]
+		}
+		if restoreTag == "" {
+			restoreTag = tagFromPath
+		}
+		if restoreTag == "" {
+			restoreTag = versionTag[1]
 		}
 
-		return fmt.Errorf("too many arguments")
+		// Validate that we have both tag and version
+		if restoreTag == "" || restoreVersion == "" {
+			return fmt.Errorf("could not determine tag or version from path. Use --tag and --version flags")
+		}
+
+		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// With --stdout the payload owns stdout, so every message goes to
+		// stderr instead
+		stdout := os.Stdout
+		if restoreStdout {
+			os.Stdout = os.Stderr
+			defer func() { os.Stdout = stdout }()
+		}
+
 		// Validate that we have both tag and version
 		if restoreTag == "" || restoreVersion == "" {
 			fmt.Println("❌ Both tag and version are required. Use --tag and --version flags or provide a backup path.")
@@ -145,188 +217,507 @@ You can also combine both formats, but the flags will take precedence.`,
 			return
 		}
 
-		bucket := config.Bucket
+		providerDisplayName := strg.DisplayName(provider)
+		fmt.Printf("☁️  Using provider: %s\n", providerDisplayName)
 
-		providerNames := map[string]string{
-			"s3":            "Amazon S3",
-			"gcs":           "Google Cloud Storage",
-			"b2":            "Backblaze B2",
-			"idrive":        "IDrive E2",
-			"s3-compatible": "S3-compatible",
-			"storj":         "Storj",
+		if restoreInPlace && restoreTarget != "" {
+			fmt.Println("❌ Use either --target or --in-place, not both.")
+			return
 		}
-		providerDisplayName := providerNames[provider]
-		if providerDisplayName == "" {
-			providerDisplayName = provider
+		if restoreStdout && (restoreInPlace || restoreTarget != "" || restoreDryRun || isSnapshotRestore || len(restorePaths) > 0 || cmd.Flags().Changed("include") || cmd.Flags().Changed("exclude")) {
+			fmt.Println("❌ --stdout writes the whole backup and cannot be combined with --target, --in-place, --dry-run, --repo or paths to restore.")
+			return
+		}
+		onConflict, _ := cmd.Flags().GetString("on-conflict")
+		policy, err := utils.ParseConflictPolicy(onConflict)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		ownership, _ := cmd.Flags().GetString("ownership")
+		var applyOwnership bool
+		switch ownership {
+		case "auto":
+			// Only root can give files away, as with tar
+			applyOwnership = os.Geteuid() == 0
+		case "apply":
+			applyOwnership = true
+		case "ignore":
+		default:
+			fmt.Printf("❌ Unknown --ownership %q (use auto, apply or ignore)\n", ownership)
+			return
+		}
+
+		maxSize, _ := cmd.Flags().GetInt64("max-size")
+		maxEntries, _ := cmd.Flags().GetInt("max-entries")
+		allowUnsafeLinks, _ := cmd.Flags().GetBool("allow-unsafe-symlinks")
+
+		includes, _ := cmd.Flags().GetStringArray("include")
+		excludes, _ := cmd.Flags().GetStringArray("exclude")
+		report := &restoreReport{actions: map[utils.ExtractAction]int{}}
+		extractOpts := &utils.ExtractOptions{
+			Include:    append(append([]string{}, restorePaths...), includes...),
+			Exclude:    excludes,
+			OnConflict: policy,
+			DryRun:     restoreDryRun,
+			OnEntry:    report.record,
+			Ownership:  applyOwnership,
+			OnWarning:  report.warn,
+
+			MaxEntries:       maxEntries,
+			MaxBytes:         maxSize << 20,
+			AllowUnsafeLinks: allowUnsafeLinks,
+			OnReject:         report.reject,
+		}
+
+		ctx := context.Background()
+		backend, err := strg.OpenBackend(ctx, provider)
+		if err != nil {
+			fmt.Printf("❌ Failed to initialize %s client: %v\n", providerDisplayName, err)
+			return
+		}
+
+		// The password is only asked for if the backup is encrypted with
+		// one or has a private name; backups encrypted to public keys need
+		// a private key instead
+		keys, names, err := openBucket(ctx, backend, userID)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+
+		if probeRestoreFormat && !isSnapshotRestore {
+			if !probeBackupFormat(ctx, backend, names, userID) {
+				fmt.Printf("❌ No backup found for tag '%s' and version '%s' in %s.\n", restoreTag, restoreVersion, providerDisplayName)
+				return
+			}
 		}
-		fmt.Printf("☁️  Using provider: %s\n", providerDisplayName)
 
 		// Construct backup key with correct extension
 		extension := "obscure"
 		if isDirectRestore {
 			extension = "tar"
 		}
-		key := fmt.Sprintf("backups/%s/%s/%s_%s.%s", userID, restoreTag, restoreVersion, restoreTag, extension)
+		if isSnapshotRestore {
+			extension = repository.SnapshotExtension
+		}
+		key := names.Key(restoreTag, restoreVersion, extension)
+		if isSnapshotRestore {
+			key = repository.SnapshotKey(userID, restoreTag, restoreVersion)
+		}
 		fmt.Println("🔍 Attempting to restore from key:", key)
 
-		outputDir := fmt.Sprintf("restored_%s_v%s", restoreTag, restoreVersion)
-
-		var rawReader io.ReadCloser
-		var size int64
+		if isSnapshotRestore {
+			restoreFromRepository(ctx, backend, provider, userID, keys.Password, extractOpts, report)
+			return
+		}
 
-		switch provider {
-		case "s3":
-			size, err = utils.GetObjectSize(bucket, key)
-			if err != nil {
-				if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "StatusCode: 404") {
-					fmt.Printf("❌ No backup found for tag '%s' and version '%s' in S3.\n", restoreTag, restoreVersion)
-				} else {
-					fmt.Println("❌ Could not get backup size:", err)
-				}
-				return
+		// Incremental backups are restored by replaying their chain, starting
+		// from the full backup
+		chain, incremental, err := resolveBackupChain(ctx, backend, names, restoreTag, restoreVersion, extension)
+		if err != nil {
+			if errors.Is(err, strg.ErrNotFound) {
+				fmt.Printf("❌ No backup found for tag '%s' and version '%s' in %s.\n", restoreTag, restoreVersion, providerDisplayName)
+			} else {
+				fmt.Println("❌ Failed to resolve backup:", err)
 			}
+			return
+		}
+		if len(chain) > 1 {
+			fmt.Printf("🔗 Replaying %d backups, starting from full backup %s\n", len(chain), chain[0])
+		}
 
-			fmt.Println("🔽 Downloading backup from S3...")
-			rawReader, err = utils.DownloadFromS3Stream(bucket, key)
-			if err != nil {
-				fmt.Println("❌ Failed to download backup:", err)
-				return
-			}
+		if restoreStdout && len(chain) > 1 {
+			fmt.Println("❌ An incremental backup is spread over several backups and has to be restored to a directory.")
+			return
+		}
 
-		case "gcs":
-			fmt.Println("🔽 Downloading backup from GCS...")
-			rawReader, size, err = utils.DownloadFromGCSStream(key)
-			if err != nil {
-				if strings.Contains(err.Error(), "storage: object doesn't exist") || strings.Contains(err.Error(), "Error 404") {
-					fmt.Printf("❌ No backup found for tag '%s' and version '%s' in GCS.\n", restoreTag, restoreVersion)
-				} else {
-					fmt.Println("❌ Failed to download backup:", err)
-				}
-				return
+		if restoreStdout {
+			if err := writePayload(ctx, backend, key, keys, stdout); err != nil {
+				fmt.Println("❌", err)
+				os.Exit(1)
 			}
+			fmt.Println("✅ Backup written to stdout")
+			return
+		}
 
-		case "b2":
-			fmt.Println("🔽 Downloading backup from B2...")
-			rawReader, size, err = utils.DownloadFromB2Stream(key)
+		// In-place restores go back to where the backup was made from, which
+		// the manifest records. Each path of a multi-source backup goes back
+		// to its own place.
+		var source string
+		var roots map[string]string
+		if restoreInPlace {
+			m, err := loadManifest(ctx, backend, names, restoreTag, restoreVersion, keys)
 			if err != nil {
-				if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "does not exist") {
-					fmt.Printf("❌ No backup found for tag '%s' and version '%s' in B2.\n", restoreTag, restoreVersion)
-				} else {
-					fmt.Println("❌ Failed to download backup:", err)
-				}
+				fmt.Println("❌", err)
 				return
 			}
-
-		case "idrive":
-			fmt.Println("🔽 Downloading backup from IDrive E2...")
-			size, err = utils.GetIDriveObjectSize(bucket, key)
-			if err != nil {
-				if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "not found") {
-					fmt.Printf("❌ No backup found for tag '%s' and version '%s' in IDrive E2.\n", restoreTag, restoreVersion)
-				} else {
-					fmt.Println("❌ Could not get backup size:", err)
-				}
+			if m == nil || (m.SourcePath == "" && len(m.Sources) == 0) {
+				fmt.Println("❌ This backup has no manifest recording its source path. Use --target instead.")
 				return
 			}
-
-			rawReader, err = utils.DownloadFromIDriveStream(bucket, key)
-			if err != nil {
-				fmt.Println("❌ Failed to download backup:", err)
+			if m.Kind == manifest.KindFile {
+				fmt.Println("❌ In-place restore only supports directory backups. Use --target instead.")
 				return
 			}
-
-		case "s3-compatible":
-			fmt.Println("🔽 Downloading backup from S3-compatible storage...")
-			size, err = utils.GetS3CompatibleObjectSize("s3-compatible", bucket, key)
-			if err != nil {
-				if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "not found") {
-					fmt.Printf("❌ No backup found for tag '%s' and version '%s' in S3-compatible storage.\n", restoreTag, restoreVersion)
-				} else {
-					fmt.Println("❌ Could not get backup size:", err)
+			source = m.SourcePath
+			if len(m.Sources) > 0 {
+				roots = map[string]string{}
+				var paths []string
+				for _, s := range m.Sources {
+					roots[s.Root] = s.Path
+					paths = append(paths, s.Path)
 				}
-				return
+				source = strings.Join(paths, ", ")
 			}
+		}
+		outputDir, ok := restoreOutputDir(source, policy)
+		if !ok {
+			return
+		}
+		location := outputDir
+		if roots != nil {
+			extractOpts.Roots = roots
+			outputDir = ""
+		}
 
-			rawReader, err = utils.DownloadFromS3CompatibleStream("s3-compatible", bucket, key)
+		restored := 0
+		for _, version := range chain {
+			key := names.Key(restoreTag, version, extension)
+			fmt.Printf("🔽 Downloading backup %s from %s...\n", version, providerDisplayName)
+			n, err := restoreArchive(ctx, backend, key, keys, outputDir, extractOpts, incremental)
 			if err != nil {
-				fmt.Println("❌ Failed to download backup:", err)
+				fmt.Println("❌", err)
 				return
 			}
+			restored += n
+		}
+		extractOpts.Finish()
 
-		case "storj":
-			fmt.Println("🔽 Downloading backup from Storj...")
-			size, err = utils.GetStorjObjectSize(bucket, key)
-			if err != nil {
-				if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "not found") {
-					fmt.Printf("❌ No backup found for tag '%s' and version '%s' in Storj.\n", restoreTag, restoreVersion)
-				} else {
-					fmt.Println("❌ Could not get backup size:", err)
-				}
-				return
-			}
+		if extractOpts.Filtered() && restored == 0 && report.actions[utils.ActionSkip] == 0 {
+			fmt.Println("\n⚠️  No files in the backup matched the given paths or patterns.")
+			return
+		}
+		report.print(location, restored)
+	},
+}
 
-			rawReader, err = utils.DownloadFromStorjStream(bucket, key)
-			if err != nil {
-				fmt.Println("❌ Failed to download backup:", err)
-				return
-			}
+// restoreOutputDir picks the directory to restore into: the backup's source
+// for --in-place, which has to be confirmed unless it is a dry run, then
+// --target, then restored_<tag>_v<version>
+func restoreOutputDir(source string, policy utils.ConflictPolicy) (string, bool) {
+	if !restoreInPlace {
+		if restoreTarget != "" {
+			return restoreTarget, true
+		}
+		return fmt.Sprintf("restored_%s_v%s", restoreTag, restoreVersion), true
+	}
+	if restoreDryRun {
+		return source, true
+	}
+
+	fmt.Printf("❓ Restore %s/%s over %s, resolving conflicts with '%s'? (Y/N): ", restoreTag, restoreVersion, source, policy)
+	var input string
+	fmt.Scanln(&input)
+	input = strings.TrimSpace(strings.ToLower(input))
+	if input != "y" && input != "yes" {
+		fmt.Println("❎ Cancelled restore.")
+		return "", false
+	}
+	return source, true
+}
 
-		case "filebase-ipfs":
-			fmt.Println("🔽 Downloading backup from Filebase+IPFS...")
-			size, err = utils.GetS3CompatibleObjectSize("filebase-ipfs", bucket, key)
-			if err != nil {
-				if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "not found") {
-					fmt.Printf("❌ No backup found for tag '%s' and version '%s' in Filebase+IPFS.\n", restoreTag, restoreVersion)
-				} else {
-					fmt.Println("❌ Could not get backup size:", err)
-				}
-				return
-			}
+// restoreReport collects what a restore did to each path
+type restoreReport struct {
+	actions map[utils.ExtractAction]int
+	// planned lists every action of a dry run
+	planned []string
+	// warnings lists metadata that could not be restored
+	warnings []string
+	// rejected lists entries refused as unsafe
+	rejected []string
+}
 
-			rawReader, err = utils.DownloadFromS3CompatibleStream("filebase-ipfs", bucket, key)
-			if err != nil {
-				fmt.Println("❌ Failed to download backup:", err)
-				return
-			}
+// maxRestoreWarnings caps how many warnings are printed individually
+const maxRestoreWarnings = 10
 
-		default:
-			fmt.Println("❌ Unknown provider.")
-			return
+func (r *restoreReport) warn(name string, err error) {
+	r.warnings = append(r.warnings, fmt.Sprintf("%s: %v", name, err))
+}
+
+func (r *restoreReport) reject(name string, err error) {
+	r.rejected = append(r.rejected, fmt.Sprintf("%s: %v", name, err))
+}
+
+func (r *restoreReport) record(name, target string, action utils.ExtractAction) {
+	r.actions[action]++
+	if !restoreDryRun {
+		return
+	}
+	switch action {
+	case utils.ActionCreate:
+		r.planned = append(r.planned, "   + "+name)
+	case utils.ActionOverwrite:
+		r.planned = append(r.planned, "   ~ "+name+" (overwrite)")
+	case utils.ActionRename:
+		r.planned = append(r.planned, "   + "+name+" -> "+target)
+	case utils.ActionDelete:
+		r.planned = append(r.planned, "   - "+name)
+	default:
+		r.planned = append(r.planned, "   = "+name+" (skip, exists)")
+	}
+}
+
+// print reports what a restore did, or would do in a dry run
+func (r *restoreReport) print(outputDir string, restored int) {
+	summary := fmt.Sprintf("%d created, %d overwritten, %d renamed, %d skipped",
+		r.actions[utils.ActionCreate], r.actions[utils.ActionOverwrite], r.actions[utils.ActionRename], r.actions[utils.ActionSkip])
+	if r.actions[utils.ActionDelete] > 0 {
+		summary += fmt.Sprintf(", %d deleted", r.actions[utils.ActionDelete])
+	}
+	if len(r.rejected) > 0 {
+		summary += fmt.Sprintf(", %d rejected", len(r.rejected))
+	}
+	// Rejected entries are always listed in full; they may be an attack
+	if len(r.rejected) > 0 {
+		fmt.Println()
+	}
+	for _, rejected := range r.rejected {
+		fmt.Println("🚫 Rejected", rejected)
+	}
+
+	if restoreDryRun {
+		fmt.Printf("\n📝 Dry run, nothing was written. Restoring into %s would change:\n", outputDir)
+		for _, line := range r.planned {
+			fmt.Println(line)
 		}
-		defer rawReader.Close()
+		fmt.Printf("📄 %d entries: %s\n", restored, summary)
+		return
+	}
+	fmt.Printf("\n📄 Restored %d entries: %s\n", restored, summary)
+	for i, warning := range r.warnings {
+		if i == maxRestoreWarnings {
+			fmt.Printf("⚠️  ... and %d more\n", len(r.warnings)-i)
+			break
+		}
+		fmt.Println("⚠️ ", warning)
+	}
+	fmt.Println("✅ Restore complete at:", outputDir)
+}
 
-		progressReader := utils.NewProgressReader(rawReader, size, "🔽 Downloading", 40)
+// resolveBackupChain follows the parent links of an incremental backup and
+// returns the versions to restore, oldest first, and whether they are
+// incremental backups, whose metadata records a base or a parent
+func resolveBackupChain(ctx context.Context, backend strg.Backend, names *naming.Namer, tag, version, extension string) ([]string, bool, error) {
+	var chain []string
+	incremental := false
+	seen := map[string]bool{}
+	for version != "" {
+		if seen[version] {
+			return nil, false, fmt.Errorf("backup chain of %s loops at %s", tag, version)
+		}
+		seen[version] = true
+		chain = append([]string{version}, chain...)
 
-		if isDirectRestore {
-			// For direct backups, just extract the tar archive
-			err = utils.ExtractTarArchive(progressReader, outputDir)
-			if err != nil {
-				fmt.Println("❌ Failed to extract tar archive:", err)
-				return
-			}
-		} else {
-			// For encrypted backups, decrypt and decompress
-			password, err := utils.PromptPassword("🔐 Enter decryption password:")
-			if err != nil || strings.TrimSpace(password) == "" {
-				fmt.Println("❌ Invalid or empty password.")
-				return
+		info, err := backend.Stat(ctx, names.Key(tag, version, extension))
+		if err != nil {
+			if errors.Is(err, strg.ErrNotFound) && len(chain) > 1 {
+				return nil, false, fmt.Errorf("backup %s needs %s, which is missing", chain[1], version)
 			}
+			return nil, false, err
+		}
+		metadata, err := names.OpenMetadata(info.Metadata)
+		if err != nil {
+			return nil, false, fmt.Errorf("backup %s: %v", version, err)
+		}
+		if metadata["base"] != "" || metadata["parent"] != "" {
+			incremental = true
+		}
+		version = metadata["parent"]
+	}
+	return chain, incremental, nil
+}
 
-			decStream, err := utils.DecryptStream(progressReader, password)
-			if err != nil {
-				fmt.Println("❌ Decryption failed:", err)
-				return
-			}
+// probeBackupFormat finds out whether tag/version is an encrypted, direct
+// or repository backup
+func probeBackupFormat(ctx context.Context, backend strg.Backend, names *naming.Namer, userID string) bool {
+	for _, extension := range []string{"obscure", "tar", repository.SnapshotExtension} {
+		key := names.Key(restoreTag, restoreVersion, extension)
+		if extension == repository.SnapshotExtension {
+			key = repository.SnapshotKey(userID, restoreTag, restoreVersion)
+		}
+		if exists, _ := strg.Exists(ctx, backend, key); exists {
+			isDirectRestore = extension == "tar"
+			isSnapshotRestore = extension == repository.SnapshotExtension
+			return true
+		}
+	}
+	return false
+}
 
-			err = utils.DecompressZstdToDirectory(decStream, outputDir)
-			if err != nil {
-				fmt.Println("❌ Failed to decompress:", err)
-				return
-			}
+// openPayload downloads one backup and returns its payload: decrypted and
+// decompressed, unless it is a direct backup. Call the returned function
+// once the payload has been read.
+func openPayload(ctx context.Context, backend strg.Backend, key string, keys utils.Keys) (io.Reader, func(), error) {
+	rawReader, info, err := backend.Get(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download backup: %v", err)
+	}
+	progressReader := utils.NewProgressReader(rawReader, info.Size, "🔽 Downloading", 40)
+	if isDirectRestore {
+		return progressReader, func() { rawReader.Close() }, nil
+	}
+
+	decStream, header, err := utils.DecryptStreamWithKeys(progressReader, keys)
+	if err != nil {
+		rawReader.Close()
+		return nil, nil, fmt.Errorf("decryption failed: %v", err)
+	}
+
+	// The header records how the archive was compressed
+	switch header.Compression {
+	case utils.CompressionZstd:
+		decoder, err := zstd.NewReader(decStream)
+		if err != nil {
+			rawReader.Close()
+			return nil, nil, fmt.Errorf("failed to create zstd decoder: %v", err)
 		}
+		return decoder, func() {
+			decoder.Close()
+			rawReader.Close()
+		}, nil
+	case utils.CompressionNone:
+		return decStream, func() { rawReader.Close() }, nil
+	default:
+		rawReader.Close()
+		return nil, nil, fmt.Errorf("unsupported compression: %s", header.Compression)
+	}
+}
 
-		fmt.Println("\n✅ Restore complete at:", outputDir)
-	},
+// writePayload copies the payload of one backup to w. The payload is read
+// to the end, so the last encrypted chunk is authenticated too.
+func writePayload(ctx context.Context, backend strg.Backend, key string, keys utils.Keys, w io.Writer) error {
+	payload, closePayload, err := openPayload(ctx, backend, key, keys)
+	if err != nil {
+		return err
+	}
+	defer closePayload()
+	if _, err := io.Copy(w, payload); err != nil {
+		return fmt.Errorf("failed to write backup: %v", err)
+	}
+	return nil
+}
+
+// restoreArchive downloads one backup and extracts the selected entries into
+// outputDir. For an increment, the selected paths it records as deleted are
+// removed afterwards.
+func restoreArchive(ctx context.Context, backend strg.Backend, key string, keys utils.Keys, outputDir string, opts *utils.ExtractOptions, incremental bool) (int, error) {
+	payload, closePayload, err := openPayload(ctx, backend, key, keys)
+	if err != nil {
+		return 0, err
+	}
+	defer closePayload()
+
+	// The increment manifest is read in memory rather than restored. Other
+	// backups may hold a file of that name, which is restored like any other.
+	var increment *index.Increment
+	opts.Handlers = nil
+	if incremental {
+		opts.Handlers = map[string]func(io.Reader) error{
+			index.ManifestName: func(r io.Reader) error {
+				increment = &index.Increment{}
+				if err := json.NewDecoder(r).Decode(increment); err != nil {
+					return fmt.Errorf("corrupt increment manifest: %v", err)
+				}
+				return nil
+			},
+		}
+	}
+
+	extracted, err := utils.ExtractTar(payload, outputDir, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to extract tar archive: %v", err)
+	}
+
+	if increment != nil {
+		if err := applyIncrement(increment, opts); err != nil {
+			return 0, err
+		}
+	}
+	return extracted, nil
+}
+
+// applyIncrement removes the selected paths an increment records as
+// deleted. Only paths written earlier in this restore are removed, so an
+// in-place restore never deletes files that were already there.
+func applyIncrement(increment *index.Increment, opts *utils.ExtractOptions) error {
+	for _, rel := range increment.Deleted {
+		if !opts.Selects(rel) {
+			continue
+		}
+		if err := opts.Remove(rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFromRepository reassembles a snapshot from the user's chunk repository
+func restoreFromRepository(ctx context.Context, backend strg.Backend, provider, userID string, password func() (string, error), opts *utils.ExtractOptions, report *restoreReport) {
+	pw, err := password()
+	if err != nil {
+		fmt.Println("❌", err)
+		return
+	}
+
+	repo, err := repository.Open(ctx, backend, userID, pw, false)
+	if err != nil {
+		fmt.Println("❌", err)
+		return
+	}
+	defer repo.Close()
+
+	snapshot, err := repo.LoadSnapshot(ctx, userID, restoreTag, restoreVersion)
+	if err != nil {
+		if errors.Is(err, strg.ErrNotFound) {
+			fmt.Printf("❌ No backup found for tag '%s' and version '%s' in %s.\n", restoreTag, restoreVersion, strg.DisplayName(provider))
+		} else {
+			fmt.Println("❌ Failed to load snapshot:", err)
+		}
+		return
+	}
+
+	if opts.Filtered() {
+		var selected []repository.FileEntry
+		for _, entry := range snapshot.Files {
+			if opts.Selects(entry.Path) {
+				selected = append(selected, entry)
+			}
+		}
+		if len(selected) == 0 {
+			fmt.Println("⚠️  No files in the backup matched the given paths or patterns.")
+			return
+		}
+		snapshot.Files = selected
+	}
+
+	source := snapshot.Source
+	if len(snapshot.Files) == 1 && !snapshot.Files[0].Mode.IsDir() && snapshot.Files[0].Path == filepath.Base(source) {
+		// A single file is restored into the directory it came from
+		source = filepath.Dir(source)
+	}
+	outputDir, ok := restoreOutputDir(source, opts.OnConflict)
+	if !ok {
+		return
+	}
+
+	fmt.Printf("🔽 Restoring %d files from %s...\n", len(snapshot.Files), strg.DisplayName(provider))
+	restored, err := repo.Restore(ctx, snapshot, outputDir, opts)
+	if err != nil {
+		fmt.Println("❌ Restore failed:", err)
+		return
+	}
+	report.print(outputDir, restored)
 }
 
 func init() {
@@ -334,5 +725,19 @@ func init() {
 
 	restoreCmd.Flags().StringVarP(&restoreTag, "tag", "t", "", "Tag of the backup to restore")
 	restoreCmd.Flags().StringVarP(&restoreVersion, "version", "v", "", "Version of the backup to restore")
+	restoreCmd.Flags().BoolVar(&isSnapshotRestore, "repo", false, "Restore a snapshot from the chunk repository")
+	restoreCmd.Flags().StringArray("include", nil, "Only restore paths matching this glob (repeatable)")
+	restoreCmd.Flags().StringArray("exclude", nil, "Skip paths matching this glob (repeatable)")
+	restoreCmd.Flags().StringVar(&restoreTarget, "target", "", "Directory to restore into (default restored_<tag>_v<version>)")
+	restoreCmd.Flags().BoolVar(&restoreInPlace, "in-place", false, "Restore over the path the backup was made from")
+	restoreCmd.Flags().String("on-conflict", string(utils.ConflictOverwrite), "What to do with existing paths: skip, overwrite, rename or newer")
+	restoreCmd.Flags().String("ownership", "auto", "Restore file owners: auto (only as root), apply or ignore")
+	restoreCmd.Flags().Int64("max-size", 0, "Abort if the restored files add up to more than this many MiB (0 = no limit)")
+	restoreCmd.Flags().Int("max-entries", 0, "Abort if the backup has more than this many entries (0 = no limit)")
+	restoreCmd.Flags().Bool("allow-unsafe-symlinks", false, "Restore symlinks that point outside the restore directory")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "List what would be restored without writing anything")
+	restoreCmd.Flags().BoolVar(&restoreStdout, "stdout", false, "Write the decrypted payload to stdout instead of extracting it")
+	addPasswordFlags(restoreCmd)
+	addIdentityFlags(restoreCmd)
 	restoreCmd.Flags().String("user", "", "Email to identify backup owner (optional if logged in)")
 }
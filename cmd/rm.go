@@ -2,13 +2,13 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
-	"cloud.google.com/go/storage"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	s3sdk "github.com/aws/aws-sdk-go-v2/service/s3"
 	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/manifest"
+	"github.com/shah1011/obscure/internal/naming"
 	strg "github.com/shah1011/obscure/internal/storage"
 	"github.com/spf13/cobra"
 )
@@ -35,6 +35,41 @@ var rmCmd = &cobra.Command{
 			return
 		}
 
+		if err := checkTag(strings.SplitN(filename, "/", 2)[0]); err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+
+		if !strg.IsRegistered(providerKey) {
+			fmt.Println("❌ Unknown provider:", providerKey)
+			return
+		}
+
+		ctx := context.Background()
+		backend, err := strg.OpenBackend(ctx, providerKey)
+		if err != nil {
+			fmt.Printf("❌ Failed to initialize %s client: %v\n", strg.DisplayName(providerKey), err)
+			return
+		}
+
+		// Backups with private names are found by their readable name
+		key := fmt.Sprintf("backups/%s/%s", username, filename)
+		manifestKey := manifestKeyFor(key)
+		_, names, err := openBucket(ctx, backend, username)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		if names.IsPrivate() {
+			name, ok := naming.Plain(username).Parse(key)
+			if !ok {
+				fmt.Println("⚠️  Please specify the full path with tag, e.g., unit/1.0_backup.obscure")
+				return
+			}
+			key = names.Key(name.Tag, name.Version, name.Extension)
+			manifestKey = names.Key(name.Tag, name.Version, manifest.Extension)
+		}
+
 		// 🛑 Ask for confirmation
 		fmt.Printf("❓ Are you sure you want to delete %s? (Y/N): ", filename)
 		var input string
@@ -45,51 +80,20 @@ var rmCmd = &cobra.Command{
 			return
 		}
 
-		key := fmt.Sprintf("backups/%s/%s", username, filename)
-		bucket, err := strg.GetBucketName(providerKey)
-		if err != nil {
-			fmt.Printf("❌ Failed to get bucket name: %v\n", err)
-			return
-		}
-
-		switch providerKey {
-		case "gcs":
-			deleteFromGCS(bucket, key)
-		case "s3":
-			deleteFromS3(bucket, key)
-		case "b2":
-			deleteFromB2(key)
-		case "idrive":
-			deleteFromIDrive(bucket, key)
-		case "s3-compatible":
-			deleteFromS3Compatible(bucket, key)
-		case "storj":
-			deleteFromStorj(bucket, key)
-		case "filebase-ipfs":
-			deleteFromFilebaseIPFS(bucket, key)
-		default:
-			fmt.Println("❌ Unknown provider:", providerKey)
-		}
+		deleteFromProvider(ctx, backend, providerKey, key, manifestKey)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(rmCmd)
+	addPasswordFlags(rmCmd)
 }
 
-func deleteFromGCS(bucket, key string) {
-	ctx := context.Background()
-	client, err := strg.NewGCSClient(ctx, "gcs")
-	if err != nil {
-		fmt.Println("❌ GCS client error:", err)
-		return
-	}
-	defer client.Close()
-
+// deleteFromProvider deletes a backup and its manifest
+func deleteFromProvider(ctx context.Context, backend strg.Backend, providerKey, key, manifestKey string) {
 	// Check if object exists first
-	_, err = client.Bucket(bucket).Object(key).Attrs(ctx)
-	if err != nil {
-		if err == storage.ErrObjectNotExist {
+	if _, err := backend.Stat(ctx, key); err != nil {
+		if errors.Is(err, strg.ErrNotFound) {
 			fmt.Printf("❌ File does not exist: %s\n", key)
 		} else {
 			fmt.Println("❌ Failed to check file existence:", err)
@@ -97,150 +101,27 @@ func deleteFromGCS(bucket, key string) {
 		return
 	}
 
-	err = client.Bucket(bucket).Object(key).Delete(ctx)
-	if err != nil {
-		fmt.Println("❌ Failed to delete:", err)
+	if err := backend.Delete(ctx, key); err != nil {
+		fmt.Printf("❌ Failed to delete from %s: %v\n", strg.DisplayName(providerKey), err)
 		return
 	}
 	fmt.Println("🗑️  Deleted:", key)
-}
 
-func deleteFromS3(bucket, key string) {
-	ctx := context.Background()
-	awsCfg, err := strg.NewAWSClient(ctx, "s3")
-	if err != nil {
-		fmt.Println("❌ AWS config error:", err)
-		return
-	}
-	client := s3sdk.NewFromConfig(*awsCfg)
-
-	// Check if object exists first
-	_, err = client.HeadObject(ctx, &s3sdk.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "StatusCode: 404") {
-			fmt.Printf("❌ File does not exist: %s\n", key)
-		} else {
-			fmt.Println("❌ Failed to check file existence:", err)
+	// Remove the backup's manifest along with it
+	if manifestKey != key {
+		if err := backend.Delete(ctx, manifestKey); err != nil && !errors.Is(err, strg.ErrNotFound) {
+			fmt.Printf("⚠️  Failed to delete manifest %s: %v\n", manifestKey, err)
 		}
-		return
-	}
-
-	_, err = client.DeleteObject(ctx, &s3sdk.DeleteObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		fmt.Println("❌ Failed to delete:", err)
-		return
 	}
-	fmt.Println("🗑️  Deleted:", key)
 }
 
-func deleteFromB2(key string) {
-	ctx := context.Background()
-	b2Client, err := strg.NewB2Client(ctx, "b2")
-	if err != nil {
-		fmt.Println("❌ B2 config error:", err)
-		return
+// manifestKeyFor returns the key of the manifest stored next to a backup
+func manifestKeyFor(key string) string {
+	dot := strings.LastIndex(key, ".")
+	if dot == -1 || strings.LastIndex(key, "/") > dot {
+		return key
 	}
-
-	// Debug: Show what key we're looking for
-	fmt.Printf("🔍 Looking for file: %s\n", key)
-
-	// Check if object exists first
-	exists, err := b2Client.FileExists(ctx, key)
-	if err != nil {
-		// Handle specific B2 errors more gracefully
-		if strings.Contains(err.Error(), "416") || strings.Contains(err.Error(), "out of content") {
-			fmt.Printf("❌ File does not exist or is corrupted: %s\n", key)
-		} else {
-			fmt.Println("❌ Failed to check file existence:", err)
-		}
-		return
-	}
-	if !exists {
-		fmt.Printf("❌ File does not exist: %s\n", key)
-		return
-	}
-
-	err = b2Client.DeleteFile(ctx, key)
-	if err != nil {
-		fmt.Println("❌ Failed to delete:", err)
-		return
-	}
-	fmt.Println("🗑️  Deleted:", key)
-}
-
-func deleteFromIDrive(bucket, key string) {
-	ctx := context.Background()
-	idriveClient, err := strg.NewIDriveClient(ctx, "idrive")
-	if err != nil {
-		fmt.Printf("❌ Failed to initialize IDrive E2 client: %v\n", err)
-		return
-	}
-
-	err = idriveClient.DeleteFile(ctx, key)
-	if err != nil {
-		fmt.Printf("❌ Failed to delete from IDrive E2: %v\n", err)
-		return
-	}
-
-	fmt.Println("🗑️  Deleted:", key)
-}
-
-func deleteFromS3Compatible(bucket, key string) {
-	ctx := context.Background()
-	s3CompatibleClient, err := strg.NewS3CompatibleClient(ctx, "s3-compatible")
-	if err != nil {
-		fmt.Printf("❌ Failed to initialize S3-compatible client: %v\n", err)
-		return
-	}
-
-	err = s3CompatibleClient.DeleteFile(ctx, key)
-	if err != nil {
-		fmt.Printf("❌ Failed to delete from S3-compatible: %v\n", err)
-		return
-	}
-
-	fmt.Println("🗑️  Deleted:", key)
-}
-
-func deleteFromStorj(bucket, key string) {
-	ctx := context.Background()
-	storjClient, err := strg.NewStorjClient(ctx, "storj")
-	if err != nil {
-		fmt.Printf("❌ Failed to initialize Storj client: %v\n", err)
-		return
-	}
-
-	// Delete the object
-	err = storjClient.DeleteFile(ctx, key)
-	if err != nil {
-		fmt.Printf("❌ Failed to delete from Storj: %v\n", err)
-		return
-	}
-
-	fmt.Println("🗑️  Deleted:", key)
-}
-
-func deleteFromFilebaseIPFS(bucket, key string) {
-	ctx := context.Background()
-	s3CompatibleClient, err := strg.NewS3CompatibleClient(ctx, "filebase-ipfs")
-	if err != nil {
-		fmt.Printf("❌ Failed to initialize Filebase+IPFS client: %v\n", err)
-		return
-	}
-
-	err = s3CompatibleClient.DeleteFile(ctx, key)
-	if err != nil {
-		fmt.Printf("❌ Failed to delete from Filebase+IPFS: %v\n", err)
-		return
-	}
-
-	fmt.Println("🗑️  Deleted:", key)
+	return key[:dot+1] + manifest.Extension
 }
 
 func containsSlash(s string) bool {
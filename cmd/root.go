@@ -11,6 +11,10 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Version is the obscure release, recorded in backup manifests. Release
+// builds set it with -ldflags "-X github.com/shah1011/obscure/cmd.Version=..."
+var Version = "1.0.8"
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "obscure",
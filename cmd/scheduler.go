@@ -1,20 +1,21 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"filippo.io/age"
 	cron "github.com/robfig/cron/v3"
 
 	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/hooks"
+	"github.com/shah1011/obscure/internal/ignore"
+	"github.com/shah1011/obscure/internal/manifest"
+	"github.com/shah1011/obscure/internal/naming"
 	strg "github.com/shah1011/obscure/internal/storage"
 	"github.com/shah1011/obscure/utils"
 	"github.com/spf13/cobra"
@@ -29,9 +30,10 @@ var (
 	schedRetain   int
 )
 
-// runScheduledBackup runs a backup non-interactively for the scheduler
-func runScheduledBackup(dir, tag, version string, retain int) error {
-	const defaultPassword = "scheduler-default-password" // TODO: Secure this!
+// runScheduledBackup runs a backup non-interactively for the scheduler,
+// between the configured hooks. With recipients, it is encrypted to them
+// instead of the password.
+func runScheduledBackup(dir, tag, version string, retain int, hookCfg hooks.Config, recipients []age.Recipient) (err error) {
 	isDirect := false
 
 	if _, err := cfg.GetSessionEmail(); err != nil {
@@ -48,126 +50,179 @@ func runScheduledBackup(dir, tag, version string, retain int) error {
 			return fmt.Errorf("no cloud provider configured")
 		}
 	}
-	providers, err := cfg.LoadUserProviders()
-	if err != nil {
-		return fmt.Errorf("failed to load provider config: %w", err)
+	if err := checkTag(tag); err != nil {
+		return err
 	}
-
 	if version == "auto" || version == "" {
 		version = time.Now().Format("2006.01.02-15.04.05")
 	}
 
-	backupFile, err := CreateBackupFile(dir)
-	if err != nil {
-		return fmt.Errorf("failed to create backup file: %w", err)
-	}
-	defer os.Remove(backupFile.Name())
-	defer backupFile.Close()
-
-	var uploadReader io.Reader
-	var extension string
-
+	extension := "obscure"
 	if isDirect {
-		uploadReader = backupFile
 		extension = "tar"
-	} else {
-		var encryptedBuf bytes.Buffer
-		encWriter, err := utils.EncryptStream(&encryptedBuf, defaultPassword)
-		if err != nil {
-			return fmt.Errorf("failed to initialize encryption: %w", err)
-		}
-		compWriter := utils.NewCompressWriter(encWriter)
-		defer compWriter.Close()
-		if _, err := io.Copy(compWriter, backupFile); err != nil {
-			return fmt.Errorf("failed to compress and encrypt: %w", err)
+	}
+
+	// The key is set once the backup is named
+	event := hooks.Event{Tag: tag, Version: version, Provider: providerKey, Source: dir}
+	if err := hookCfg.Before(event); err != nil {
+		if err := hookCfg.Failed(event, err); err != nil {
+			fmt.Printf("[Scheduler] %v\n", err)
 		}
-		if err := compWriter.Close(); err != nil {
-			return fmt.Errorf("failed to close compression: %w", err)
+		return fmt.Errorf("backup aborted: %w", err)
+	}
+	defer func() {
+		if hookErr := hookCfg.After(event, err); hookErr != nil {
+			fmt.Printf("[Scheduler] %v\n", hookErr)
 		}
-		if err := encWriter.Close(); err != nil {
-			return fmt.Errorf("failed to finalize encryption: %w", err)
+	}()
+
+	// The password is read for every run, so a rotated password file or
+	// keyfile takes effect without restarting the scheduler
+	var password string
+	var wrappedKey *utils.WrappedKey
+	if len(recipients) > 0 {
+		if wrappedKey, err = utils.WrapKey(recipients); err != nil {
+			return err
 		}
-		uploadReader = bytes.NewReader(encryptedBuf.Bytes())
-		extension = "obscure"
+	} else if password, err = passwordSource.Read(); err != nil {
+		return err
 	}
 
-	filename := fmt.Sprintf("%s_%s.%s", version, tag, extension)
-	key := fmt.Sprintf("backups/%s/%s/%s", username, tag, filename)
-
-	config, ok := providers.Providers[providerKey]
-	if !ok || !config.Enabled {
-		return fmt.Errorf("provider %s is not configured or disabled", providerKey)
+	sourceInfo, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	manifestKind := manifest.KindTar
+	if !sourceInfo.IsDir() {
+		manifestKind = manifest.KindFile
 	}
 
-	awsCfg, err := strg.NewAWSClient(context.Background(), "s3")
+	ctx := context.Background()
+	backend, err := strg.OpenBackend(ctx, providerKey)
 	if err != nil {
-		return fmt.Errorf("failed to initialize AWS client: %w", err)
-	}
-	client := s3.NewFromConfig(*awsCfg)
-	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
-		Bucket: aws.String(config.Bucket),
-		Key:    aws.String(key),
-		Body:   uploadReader,
-		Metadata: map[string]string{
-			"username":  username,
-			"tag":       tag,
-			"version":   version,
-			"is_direct": fmt.Sprintf("%v", isDirect),
-		},
+		return fmt.Errorf("failed to initialize %s client: %w", strg.DisplayName(providerKey), err)
+	}
+	// Backups encrypted to public keys can still have private names, if a
+	// password source is configured to unlock the keyring
+	ring := lazyKeyring(ctx, backend, username, passwordSource.Read)
+	names, err := backupNamer(ctx, backend, username, ring)
+	if err != nil {
+		return err
+	}
+	key := names.Key(tag, version, extension)
+	event.Key = key
+	if wrappedKey == nil {
+		if wrappedKey, err = keyringKey(ring); err != nil {
+			return fmt.Errorf("failed to unlock keyring: %w", err)
+		}
+	}
+
+	metadata, err := names.SealMetadata(map[string]string{
+		"username":  username,
+		"tag":       tag,
+		"version":   version,
+		"is_direct": fmt.Sprintf("%v", isDirect),
 	})
 	if err != nil {
+		return err
+	}
+	rec := manifest.NewRecorder(manifestKind)
+	encOpts := utils.DefaultEncryptOptions()
+	if encOpts.KDF, err = backupKDFParams(); err != nil {
+		return err
+	}
+	encOpts.Key = wrappedKey
+	stream := newBackupStream(recordedArchive(pathArchive(dir, ignore.Rules{}), rec), password, isDirect, encOpts)
+	defer stream.Close()
+	if err := backend.Put(ctx, key, stream, -1, metadata); err != nil {
 		return fmt.Errorf("failed to upload: %w", err)
 	}
+	manifestPassword := password
+	if isDirect {
+		manifestPassword = ""
+	}
+	if err := uploadManifest(ctx, backend, names, ring, tag, version, dir, rec.Manifest(), manifestPassword, wrappedKey); err != nil {
+		fmt.Printf("[Scheduler] Failed to upload manifest for %s: %v\n", key, err)
+	}
 
 	fmt.Printf("[Scheduler] Backup completed: %s\n", key)
-	enforceRetention(username, tag, retain, config)
+	if err := enforceRetention(ctx, backend, names, tag, extension, retain); err != nil {
+		fmt.Printf("[Scheduler] Old backups were kept: %v\n", err)
+	}
 	return nil
 }
 
-// enforceRetention deletes oldest backups if over the retain limit (S3 only)
-func enforceRetention(username, tag string, retain int, config *cfg.CloudProviderConfig) error {
-	ctx := context.Background()
-	awsCfg, err := strg.NewAWSClient(ctx, "s3")
+// enforceRetention deletes the oldest backups with the scheduler's
+// extension if over the retain limit. Snapshots, manifests and backups in
+// other formats are left alone, and so is any backup that a retained
+// incremental backup is built on.
+func enforceRetention(ctx context.Context, backend strg.Backend, names *naming.Namer, tag, extension string, retain int) error {
+	objects, err := listBackups(ctx, backend, names, tag)
 	if err != nil {
-		return fmt.Errorf("failed to init AWS client: %w", err)
-	}
-	client := s3.NewFromConfig(*awsCfg)
-	prefix := fmt.Sprintf("backups/%s/%s/", username, tag)
-	var backups []string
-	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
-		Bucket: aws.String(config.Bucket),
-		Prefix: aws.String(prefix),
-	})
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to list backups: %w", err)
-		}
-		for _, obj := range page.Contents {
-			backups = append(backups, *obj.Key)
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []storedBackup
+	byVersion := make(map[string]storedBackup)
+	for _, obj := range objects {
+		// Manifests are deleted together with their backup, and snapshots
+		// and direct backups were not made by the scheduler
+		if obj.Extension != extension {
+			continue
 		}
+		backups = append(backups, obj)
+		byVersion[obj.Version] = obj
 	}
 	if len(backups) <= retain {
 		return nil // nothing to delete
 	}
-	// Sort backups by key (filename has timestamp/version prefix)
-	sort.Strings(backups)
+	// Sort backups by version (it has a timestamp prefix)
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Version < backups[j].Version
+	})
 	toDelete := backups[:len(backups)-retain]
-	for _, key := range toDelete {
-		_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(config.Bucket),
-			Key:    aws.String(key),
-		})
+
+	// Follow the base and parent links of the retained backups down their
+	// chains; every backup on the way is needed to restore them
+	needed := make(map[string]bool)
+	queue := append([]storedBackup(nil), backups[len(backups)-retain:]...)
+	for len(queue) > 0 {
+		backup := queue[0]
+		queue = queue[1:]
+		info, err := backend.Stat(ctx, backup.Key)
+		if err != nil {
+			return fmt.Errorf("failed to read backup %s: %w", backup.Version, err)
+		}
+		metadata, err := names.OpenMetadata(info.Metadata)
 		if err != nil {
-			fmt.Printf("[Scheduler] Failed to delete old backup: %s (%v)\n", key, err)
+			return fmt.Errorf("backup %s: %w", backup.Version, err)
+		}
+		for _, version := range []string{metadata["base"], metadata["parent"]} {
+			if version == "" || needed[version] {
+				continue
+			}
+			needed[version] = true
+			if base, ok := byVersion[version]; ok {
+				queue = append(queue, base)
+			}
+		}
+	}
+
+	for _, backup := range toDelete {
+		if needed[backup.Version] {
+			fmt.Printf("[Scheduler] Kept old backup, a newer incremental backup needs it: %s\n", backup.Key)
+			continue
+		}
+		if err := backend.Delete(ctx, backup.Key); err != nil {
+			fmt.Printf("[Scheduler] Failed to delete old backup: %s (%v)\n", backup.Key, err)
 		} else {
-			fmt.Printf("[Scheduler] Deleted old backup: %s\n", key)
+			fmt.Printf("[Scheduler] Deleted old backup: %s\n", backup.Key)
+			backend.Delete(ctx, names.Key(backup.Tag, backup.Version, manifest.Extension))
 		}
 	}
 	return nil
 }
 
-func scheduleBackupJob() {
+func scheduleBackupJob(hookCfg hooks.Config, recipients []age.Recipient) {
 	c := cron.New()
 	var cronExpr string
 	switch schedInterval {
@@ -188,7 +243,7 @@ func scheduleBackupJob() {
 	}
 	fmt.Printf("[Scheduler] Using cron expression: %s\n", cronExpr)
 	_, err := c.AddFunc(cronExpr, func() {
-		err := runScheduledBackup(schedDir, schedTag, schedVersion, schedRetain)
+		err := runScheduledBackup(schedDir, schedTag, schedVersion, schedRetain, hookCfg, recipients)
 		if err != nil {
 			fmt.Printf("[Scheduler] Backup failed: %v\n", err)
 		}
@@ -205,12 +260,28 @@ func scheduleBackupJob() {
 var schedulerCmd = &cobra.Command{
 	Use:   "scheduler",
 	Short: "Schedule automated backups at specified intervals.",
-	Long:  `Automate backups with a scheduler.\n\n- The scheduler always uses the currently selected provider (set with 'obscure switch-provider') at the time of each backup.\n- To change the provider for future scheduled backups, run 'obscure switch-provider <provider>' before the next backup runs.\n\nExamples:\n  Daily at 17:00: obscure scheduler --time=\"17:00\" --interval=daily ...\n  Every 5 minutes: obscure scheduler --time=\"5\" --interval=minute ...\n  Custom cron: obscure scheduler --time=\"*/10 * * * *\" --interval=custom ...`,
+	Long:  `Automate backups with a scheduler.\n\n- The scheduler always uses the currently selected provider (set with 'obscure switch-provider') at the time of each backup.\n- To change the provider for future scheduled backups, run 'obscure switch-provider <provider>' before the next backup runs.\n\nExamples:\n  Daily at 17:00: obscure scheduler --time=\"17:00\" --interval=daily ...\n  Every 5 minutes: obscure scheduler --time=\"5\" --interval=minute ...\n  Custom cron: obscure scheduler --time=\"*/10 * * * *\" --interval=custom ...\n\nHooks (--pre-hook, --post-hook, --on-failure) run around every scheduled backup, as with 'obscure backup'.\n\nScheduled backups are encrypted with the password from --password-file, --password-command, --keyfile or OBSCURE_PASSWORD; the scheduler does not start without one. With --recipient or --recipients-file they are encrypted to those public keys instead, and no password is needed.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if schedTime == "" || schedInterval == "" || schedDir == "" || schedTag == "" {
 			fmt.Println("❌ --time, --interval, --dir, and --tag are required.")
 			return
 		}
+		recipients, err := backupRecipients(cmd)
+		if err != nil {
+			fmt.Println("❌", err)
+			return
+		}
+		// Nobody is around to type a password when a scheduled backup runs
+		if len(recipients) == 0 {
+			if !passwordSource.Configured() {
+				fmt.Println("❌ Scheduled backups need a password: use --password-file, --password-command, --keyfile or OBSCURE_PASSWORD, or --recipient.")
+				return
+			}
+			if _, err := passwordSource.Read(); err != nil {
+				fmt.Println("❌", err)
+				return
+			}
+		}
 		if schedVersion == "" {
 			schedVersion = "auto"
 		}
@@ -218,7 +289,7 @@ var schedulerCmd = &cobra.Command{
 			schedRetain = 5
 		}
 		fmt.Printf("[Scheduler] Scheduling backup: time=%s, interval=%s, dir=%s, tag=%s, version=%s, retain=%d\n", schedTime, schedInterval, schedDir, schedTag, schedVersion, schedRetain)
-		scheduleBackupJob()
+		scheduleBackupJob(backupHooks(cmd), recipients)
 	},
 }
 
@@ -230,4 +301,7 @@ func init() {
 	schedulerCmd.Flags().StringVar(&schedTag, "tag", "", "Tag for the backup")
 	schedulerCmd.Flags().StringVar(&schedVersion, "version", "auto", "Backup version (auto-increment if not specified)")
 	schedulerCmd.Flags().IntVar(&schedRetain, "retain", 5, "Retention policy (number of backups to keep, default 5)")
+	addHookFlags(schedulerCmd)
+	addPasswordFlags(schedulerCmd)
+	addRecipientFlags(schedulerCmd)
 }
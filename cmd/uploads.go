@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cfg "github.com/shah1011/obscure/internal/config"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var uploadsCmd = &cobra.Command{
+	Use:   "uploads",
+	Short: "Manage interrupted multipart uploads",
+}
+
+var uploadsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List interrupted uploads that can be resumed or cleaned up",
+	Run: func(cmd *cobra.Command, args []string) {
+		providerKey, backend, prefix, ok := openUploadsBackend()
+		if !ok {
+			return
+		}
+
+		journals, err := strg.ListJournals(providerKey)
+		if err != nil {
+			fmt.Println("❌ Failed to read upload journals:", err)
+			return
+		}
+		if len(journals) > 0 {
+			fmt.Println("📓 Resumable uploads:")
+			for _, journal := range journals {
+				fmt.Printf("   %s/%s  %d parts, %s uploaded, started %s\n",
+					journal.Tag, journal.Version, len(journal.Parts), FormatBytes(journal.UploadedBytes()),
+					journal.CreatedAt.Format("2006-01-02 15:04:05"))
+				fmt.Printf("      ↳ obscure backup --resume --tag %s --version %s\n", journal.Tag, journal.Version)
+			}
+		}
+
+		uploads, err := backend.ListMultipartUploads(context.Background(), prefix)
+		if err != nil {
+			fmt.Println("❌ Failed to list multipart uploads:", err)
+			return
+		}
+		var stale []strg.MultipartUpload
+		for _, upload := range uploads {
+			if !hasJournal(journals, upload) {
+				stale = append(stale, upload)
+			}
+		}
+		if len(stale) > 0 {
+			fmt.Printf("🧹 Unfinished uploads in %s without a local journal:\n", strg.DisplayName(providerKey))
+			for _, upload := range stale {
+				fmt.Printf("   %s  started %s\n", upload.Key, upload.Initiated.Format("2006-01-02 15:04:05"))
+			}
+		}
+
+		if len(journals) == 0 && len(stale) == 0 {
+			fmt.Println("✅ No interrupted uploads.")
+		}
+	},
+}
+
+var uploadsCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Abort stale multipart uploads so the provider stops storing their parts",
+	Run: func(cmd *cobra.Command, args []string) {
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+
+		providerKey, backend, prefix, ok := openUploadsBackend()
+		if !ok {
+			return
+		}
+		ctx := context.Background()
+		cutoff := time.Now().Add(-olderThan)
+
+		uploads, err := backend.ListMultipartUploads(ctx, prefix)
+		if err != nil {
+			fmt.Println("❌ Failed to list multipart uploads:", err)
+			return
+		}
+
+		journals, err := strg.ListJournals(providerKey)
+		if err != nil {
+			fmt.Println("❌ Failed to read upload journals:", err)
+			return
+		}
+
+		aborted := 0
+		abortedIDs := make(map[string]bool)
+		for _, upload := range uploads {
+			// Uploads whose journal holds a plaintext key are never
+			// resumed, whatever their age
+			if upload.Initiated.After(cutoff) && !hasKeyJournal(journals, upload) {
+				continue
+			}
+			if err := backend.AbortMultipartUpload(ctx, upload.Key, upload.UploadID); err != nil {
+				fmt.Printf("⚠️  Failed to abort %s: %v\n", upload.Key, err)
+				continue
+			}
+			fmt.Println("🗑️ Aborted:", upload.Key)
+			abortedIDs[upload.UploadID] = true
+			aborted++
+		}
+
+		// Drop journals whose upload was just aborted, and those that are
+		// old enough or whose upload no longer exists
+		removed := 0
+		for _, journal := range journals {
+			live := false
+			for _, upload := range uploads {
+				if upload.UploadID == journal.UploadID && !abortedIDs[upload.UploadID] {
+					live = upload.Initiated.After(cutoff)
+					break
+				}
+			}
+			stale := abortedIDs[journal.UploadID] || journal.HoldsKey()
+			if !stale && (live || journal.UpdatedAt.After(cutoff)) {
+				continue
+			}
+			if err := journal.Remove(); err != nil {
+				fmt.Printf("⚠️  Failed to remove journal for %s/%s: %v\n", journal.Tag, journal.Version, err)
+				continue
+			}
+			removed++
+		}
+
+		fmt.Printf("🧹 Aborted %d uploads and removed %d journals in %s\n", aborted, removed, strg.DisplayName(providerKey))
+	},
+}
+
+// openUploadsBackend opens the active provider, which must support
+// multipart uploads, and returns the current user's backup prefix
+func openUploadsBackend() (string, strg.MultipartBackend, string, bool) {
+	providerKey, err := cfg.GetSessionProvider()
+	if err != nil || providerKey == "" {
+		providerKey, err = cfg.GetUserDefaultProvider()
+		if err != nil || providerKey == "" {
+			fmt.Println("⚠️  No cloud provider is configured.")
+			return "", nil, "", false
+		}
+	}
+	if !strg.IsRegistered(providerKey) {
+		fmt.Println("❌ Unknown provider:", providerKey)
+		return "", nil, "", false
+	}
+
+	username, _ := cfg.GetSessionUsername()
+
+	backend, err := strg.OpenBackend(context.Background(), providerKey)
+	if err != nil {
+		fmt.Printf("❌ Failed to initialize %s client: %v\n", strg.DisplayName(providerKey), err)
+		return "", nil, "", false
+	}
+	multipart, ok := backend.(strg.MultipartBackend)
+	if !ok {
+		fmt.Printf("ℹ️  %s does not use multipart uploads.\n", strg.DisplayName(providerKey))
+		return "", nil, "", false
+	}
+
+	return providerKey, multipart, fmt.Sprintf("backups/%s/", username), true
+}
+
+func hasJournal(journals []*strg.UploadJournal, upload strg.MultipartUpload) bool {
+	for _, journal := range journals {
+		if journal.UploadID == upload.UploadID {
+			return true
+		}
+	}
+	return false
+}
+
+// hasKeyJournal reports whether upload's journal holds a plaintext key
+func hasKeyJournal(journals []*strg.UploadJournal, upload strg.MultipartUpload) bool {
+	for _, journal := range journals {
+		if journal.UploadID == upload.UploadID {
+			return journal.HoldsKey()
+		}
+	}
+	return false
+}
+
+func init() {
+	uploadsCleanCmd.Flags().Duration("older-than", 24*time.Hour, "Only clean uploads started longer ago than this")
+	uploadsCmd.AddCommand(uploadsListCmd)
+	uploadsCmd.AddCommand(uploadsCleanCmd)
+	rootCmd.AddCommand(uploadsCmd)
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	cfg "github.com/shah1011/obscure/internal/config"
+	"github.com/shah1011/obscure/internal/manifest"
+	"github.com/shah1011/obscure/internal/naming"
+	"github.com/shah1011/obscure/internal/repository"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/shah1011/obscure/utils"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <tag/version>",
+	Short: "Check that a backup can still be restored",
+	Long: `Download, decrypt and decompress a backup without writing any files, and
+compare the SHA-256 of every file with the manifest stored at backup time.
+
+Exits with status 1 if anything is damaged, so it can run from cron:
+  obscure verify --strict testdata/2.9
+  obscure verify testdata/2.9_testdata.obscure
+
+Backups made before manifests existed only have their archive read back;
+--strict fails them instead, since their checksums cannot be compared.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tag, version, err := parseBackupRef(args[0])
+		if err != nil {
+			fmt.Println("❌", err)
+			os.Exit(1)
+		}
+
+		username, err := cfg.GetSessionUsername()
+		if err != nil || username == "" {
+			fmt.Println("❌ Not logged in. Please run `obscure login` or `obscure signup`.")
+			os.Exit(1)
+		}
+		providerKey, err := cfg.GetSessionProvider()
+		if err != nil || providerKey == "" {
+			providerKey, err = cfg.GetUserDefaultProvider()
+			if err != nil || providerKey == "" {
+				fmt.Println("⚠️  No cloud provider is configured.")
+				os.Exit(1)
+			}
+		}
+
+		ctx := context.Background()
+		backend, err := strg.OpenBackend(ctx, providerKey)
+		if err != nil {
+			fmt.Printf("❌ Failed to initialize %s client: %v\n", strg.DisplayName(providerKey), err)
+			os.Exit(1)
+		}
+
+		strict, _ := cmd.Flags().GetBool("strict")
+		if !verifyBackup(ctx, backend, username, tag, version, strict) {
+			os.Exit(1)
+		}
+	},
+}
+
+// parseBackupRef accepts tag/version or tag/version_tag.ext
+func parseBackupRef(ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid backup reference %q. Expected: tag/version", ref)
+	}
+	tag, version := parts[0], parts[1]
+	if dot := strings.LastIndex(version, "."); dot != -1 && strings.HasSuffix(version[:dot], "_"+tag) {
+		version = strings.TrimSuffix(version[:dot], "_"+tag)
+	}
+	return tag, version, nil
+}
+
+// verifyBackup checks one backup and prints a report. It returns false if
+// the backup is missing or damaged, or if strict and it has no manifest.
+func verifyBackup(ctx context.Context, backend strg.Backend, username, tag, version string, strict bool) bool {
+	// The password is only asked for if the backup is encrypted with one,
+	// or has a private name
+	keys, names, err := openBucket(ctx, backend, username)
+	if err != nil {
+		fmt.Println("❌", err)
+		return false
+	}
+
+	var key string
+	var isDirect, isSnapshot bool
+	for _, extension := range []string{"obscure", "tar", repository.SnapshotExtension} {
+		candidate := names.Key(tag, version, extension)
+		if extension == repository.SnapshotExtension {
+			candidate = repository.SnapshotKey(username, tag, version)
+		}
+		exists, err := strg.Exists(ctx, backend, candidate)
+		if err != nil {
+			fmt.Println("❌ Failed to look up backup:", err)
+			return false
+		}
+		if exists {
+			key = candidate
+			isDirect = extension == "tar"
+			isSnapshot = extension == repository.SnapshotExtension
+			break
+		}
+	}
+	if key == "" {
+		fmt.Printf("❌ No backup found for tag '%s' and version '%s'.\n", tag, version)
+		return false
+	}
+
+	if isSnapshot {
+		password, err := keys.Password()
+		if err != nil {
+			fmt.Println("❌", err)
+			return false
+		}
+		return verifySnapshot(ctx, backend, username, tag, version, password)
+	}
+
+	// The manifest may be missing for backups made before manifests existed
+	m, err := loadManifest(ctx, backend, names, tag, version, keys)
+	if err != nil {
+		fmt.Println("❌", err)
+		return false
+	}
+
+	rawReader, info, err := backend.Get(ctx, key)
+	if err != nil {
+		fmt.Println("❌ Failed to download backup:", err)
+		return false
+	}
+	defer rawReader.Close()
+	progressReader := utils.NewProgressReader(rawReader, info.Size, "🔽 Verifying", 40)
+
+	var payload io.Reader = progressReader
+	if !isDirect {
+		decStream, header, err := utils.DecryptStreamWithKeys(progressReader, keys)
+		if err != nil {
+			fmt.Println("\n❌ Decryption failed:", err)
+			return false
+		}
+		payload = decStream
+		if header.Compression == utils.CompressionZstd {
+			decoder, err := zstd.NewReader(decStream)
+			if err != nil {
+				fmt.Println("\n❌ Failed to create zstd decoder:", err)
+				return false
+			}
+			defer decoder.Close()
+			payload = decoder
+		}
+	}
+
+	kind := manifest.KindTar
+	if m != nil {
+		kind = m.Kind
+	}
+	report, err := manifest.Verify(payload, kind, m)
+	if err != nil {
+		fmt.Println("\n❌ Backup is damaged:", err)
+		return false
+	}
+
+	fmt.Println()
+	for _, path := range report.Mismatched {
+		fmt.Println("❌ Checksum mismatch:", path)
+	}
+	for _, path := range report.Missing {
+		fmt.Println("❌ Missing from archive:", path)
+	}
+	for _, path := range report.Unexpected {
+		fmt.Println("❌ Not in manifest:", path)
+	}
+	if !report.OK() {
+		fmt.Printf("❌ %s/%s failed verification: %d ok, %d mismatched, %d missing, %d unexpected\n", tag, version,
+			report.Verified, len(report.Mismatched), len(report.Missing), len(report.Unexpected))
+		return false
+	}
+	if m == nil && strict {
+		fmt.Printf("❌ %s/%s failed verification: no manifest found, so checksums could not be compared (%d files read back cleanly)\n", tag, version, report.Verified)
+		return false
+	}
+	if m == nil {
+		fmt.Printf("⚠️  No manifest found; the archive reads back cleanly (%d files) but checksums were not compared.\n", report.Verified)
+		return true
+	}
+	fmt.Printf("✅ %s/%s verified: %d files match the manifest\n", tag, version, report.Verified)
+	return true
+}
+
+// loadManifest fetches the manifest of tag/version, or nil if it has none
+func loadManifest(ctx context.Context, backend strg.Backend, names *naming.Namer, tag, version string, keys utils.Keys) (*manifest.Manifest, error) {
+	reader, _, err := backend.Get(ctx, names.Key(tag, version, manifest.Extension))
+	if errors.Is(err, strg.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest: %v", err)
+	}
+	defer reader.Close()
+	return manifest.Decode(reader, keys)
+}
+
+// verifySnapshot checks every chunk referenced by a repository snapshot
+func verifySnapshot(ctx context.Context, backend strg.Backend, username, tag, version, password string) bool {
+	repo, err := repository.Open(ctx, backend, username, password, false)
+	if err != nil {
+		fmt.Println("❌", err)
+		return false
+	}
+	defer repo.Close()
+
+	snapshot, err := repo.LoadSnapshot(ctx, username, tag, version)
+	if err != nil {
+		fmt.Println("❌ Failed to load snapshot:", err)
+		return false
+	}
+	verified, damaged, err := repo.Verify(ctx, snapshot)
+	if err != nil {
+		fmt.Println("❌ Verification aborted:", err)
+		return false
+	}
+	for _, path := range damaged {
+		fmt.Println("❌ Damaged or missing chunks:", path)
+	}
+	if len(damaged) > 0 {
+		fmt.Printf("❌ %s/%s failed verification: %d ok, %d damaged\n", tag, version, verified, len(damaged))
+		return false
+	}
+	fmt.Printf("✅ %s/%s verified: %d entries, all chunks intact\n", tag, version, verified)
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().Bool("strict", false, "Fail backups that have no manifest to compare checksums against")
+	addPasswordFlags(verifyCmd)
+	addIdentityFlags(verifyCmd)
+}
@@ -5,6 +5,7 @@ go 1.24
 require (
 	cloud.google.com/go/firestore v1.18.0
 	cloud.google.com/go/storage v1.54.0
+	filippo.io/age v1.2.1
 	firebase.google.com/go/v4 v4.15.2
 	github.com/aws/aws-sdk-go v1.53.17
 	github.com/aws/aws-sdk-go-v2 v1.36.3
@@ -17,8 +18,10 @@ require (
 	github.com/klauspost/compress v1.18.0
 	github.com/kurin/blazer v0.5.3
 	github.com/manifoldco/promptui v0.9.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/cobra v1.9.1
 	golang.org/x/crypto v0.38.0
+	golang.org/x/sys v0.33.0
 	golang.org/x/term v0.32.0
 	google.golang.org/api v0.234.0
 	gopkg.in/yaml.v3 v3.0.1
@@ -70,7 +73,6 @@ require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
 	github.com/spiffe/go-spiffe/v2 v2.5.0 // indirect
 	github.com/zeebo/errs v1.4.0 // indirect
@@ -86,7 +88,6 @@ require (
 	golang.org/x/net v0.40.0 // indirect
 	golang.org/x/oauth2 v0.30.0 // indirect
 	golang.org/x/sync v0.14.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.25.0 // indirect
 	golang.org/x/time v0.11.0 // indirect
 	google.golang.org/appengine/v2 v2.0.6 // indirect
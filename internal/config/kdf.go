@@ -0,0 +1,38 @@
+package config
+
+import "os"
+
+// KDFSettings are the Argon2id parameters new backups are encrypted with,
+// as picked by `obscure kdf benchmark`
+type KDFSettings struct {
+	Memory  uint32 `yaml:"memory_kib"`
+	Time    uint32 `yaml:"time"`
+	Threads uint8  `yaml:"threads"`
+}
+
+// GetKDFSettings returns the configured KDF parameters, or nil if none are
+// set and the built-in defaults apply
+func GetKDFSettings() (*KDFSettings, error) {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return nil, nil
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.KDF, nil
+}
+
+// SetKDFSettings stores the KDF parameters for new backups; nil restores
+// the defaults
+func SetKDFSettings(settings *KDFSettings) error {
+	cfg := &Config{}
+	if _, err := os.Stat(configPath); err == nil {
+		existing, err := loadConfig()
+		if err == nil {
+			cfg = existing
+		}
+	}
+	cfg.KDF = settings
+	return saveConfig(cfg)
+}
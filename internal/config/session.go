@@ -24,6 +24,7 @@ type Config struct {
 	User *struct {
 		DefaultProvider string `yaml:"default_provider"`
 	} `yaml:"user"`
+	KDF *KDFSettings `yaml:"kdf,omitempty"`
 }
 
 var configPath = filepath.Join(os.Getenv("HOME"), ".obscure", "config.yaml")
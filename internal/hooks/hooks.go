@@ -0,0 +1,133 @@
+// Package hooks runs user commands around a backup, so that services can be
+// quiesced before their data is read and resumed afterwards.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// DefaultTimeout bounds each hook unless configured otherwise
+const DefaultTimeout = 10 * time.Minute
+
+// Stage tells a hook when it runs
+type Stage string
+
+const (
+	StagePre     Stage = "pre"
+	StagePost    Stage = "post"
+	StageFailure Stage = "failure"
+)
+
+// Config holds the shell commands to run; empty commands are skipped
+type Config struct {
+	// Pre runs before the source is read. If it fails, the backup is aborted.
+	Pre string
+	// Post runs after every backup that got past Pre, whether it succeeded
+	// or not, so it can undo what Pre did
+	Post string
+	// Failure runs when the backup fails, Pre included
+	Failure string
+	// Timeout kills a hook that runs longer; zero means no limit
+	Timeout time.Duration
+}
+
+// Event describes the backup a hook runs for
+type Event struct {
+	Tag     string
+	Version string
+	// Key is the object the backup is stored under, empty until it is
+	// known. With private names it does not contain the tag or version.
+	Key      string
+	Provider string
+	Source   string
+}
+
+// Before runs the pre-backup hook
+func (c Config) Before(e Event) error {
+	return c.run(StagePre, c.Pre, e, nil)
+}
+
+// After runs the post-backup hook and, if backupErr is set, the failure
+// hook. Both run even if the first fails.
+func (c Config) After(e Event, backupErr error) error {
+	err := c.run(StagePost, c.Post, e, backupErr)
+	if backupErr != nil {
+		err = errors.Join(err, c.run(StageFailure, c.Failure, e, backupErr))
+	}
+	return err
+}
+
+// Failed runs the failure hook alone, for a backup that stopped before Post
+// was due
+func (c Config) Failed(e Event, backupErr error) error {
+	return c.run(StageFailure, c.Failure, e, backupErr)
+}
+
+// run executes command through the shell with the event in its environment.
+// Its output goes to ours; stdin is left empty, since it may carry the data
+// being backed up.
+func (c Config) run(stage Stage, command string, e Event, backupErr error) error {
+	if command == "" {
+		return nil
+	}
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), Env(stage, e, backupErr)...)
+	// Don't wait forever for children that inherited the output
+	cmd.WaitDelay = 5 * time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s hook timed out after %s", stage, c.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook failed: %w", stage, err)
+	}
+	return nil
+}
+
+// Env returns the variables describing the backup to a hook:
+// OBSCURE_HOOK, OBSCURE_TAG, OBSCURE_VERSION, OBSCURE_KEY,
+// OBSCURE_PROVIDER, OBSCURE_SOURCE, OBSCURE_STATUS (running, success or
+// failure) and, after a failure, OBSCURE_ERROR
+func Env(stage Stage, e Event, backupErr error) []string {
+	status := "running"
+	if stage != StagePre {
+		status = "success"
+		if backupErr != nil {
+			status = "failure"
+		}
+	}
+	env := []string{
+		"OBSCURE_HOOK=" + string(stage),
+		"OBSCURE_TAG=" + e.Tag,
+		"OBSCURE_VERSION=" + e.Version,
+		"OBSCURE_KEY=" + e.Key,
+		"OBSCURE_PROVIDER=" + e.Provider,
+		"OBSCURE_SOURCE=" + e.Source,
+		"OBSCURE_STATUS=" + status,
+	}
+	if backupErr != nil {
+		env = append(env, "OBSCURE_ERROR="+backupErr.Error())
+	}
+	return env
+}
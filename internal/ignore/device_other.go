@@ -0,0 +1,10 @@
+//go:build !unix
+
+package ignore
+
+import "os"
+
+// device is unavailable here, so --one-file-system has no effect
+func device(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package ignore
+
+import (
+	"os"
+	"syscall"
+)
+
+func device(fi os.FileInfo) (uint64, bool) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), true
+	}
+	return 0, false
+}
@@ -0,0 +1,264 @@
+// Package ignore decides which files under a backup source are archived.
+// It combines the --include and --exclude globs, .obscureignore files
+// (which follow .gitignore rules and apply to the directory they are in),
+// a per-file size limit and staying on the source's filesystem.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/shah1011/obscure/utils"
+)
+
+// FileName is the ignore file honored in every directory of a backup
+const FileName = ".obscureignore"
+
+// Rules configure a Matcher. Include and Exclude use the same globs as
+// restore: "**" matches any number of directories, a pattern without a
+// slash matches base names at any depth, and a pattern matching a directory
+// covers everything under it.
+type Rules struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// MaxFileSize skips regular files larger than this many bytes; zero
+	// means no limit
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// OneFilesystem skips everything on a different device than the root,
+	// mount points included
+	OneFilesystem bool `json:"one_filesystem,omitempty"`
+}
+
+// rule is one line of an .obscureignore file
+type rule struct {
+	// base is the directory of the ignore file, relative to the root
+	base    string
+	pattern string
+	negate  bool
+	dirOnly bool
+	// anchored patterns match from base; others match names at any depth
+	anchored bool
+}
+
+// Matcher walks a backup source and skips what the rules exclude
+type Matcher struct {
+	root    string
+	rules   Rules
+	include utils.ExtractOptions
+	exclude utils.ExtractOptions
+	ignore  []rule
+	device  uint64
+
+	// Counts of skipped paths, by reason
+	Excluded int
+	TooLarge int
+	OtherFS  int
+}
+
+// New prepares a matcher for the tree at root
+func New(root string, rules Rules) (*Matcher, error) {
+	fi, err := os.Lstat(root)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{
+		root:    root,
+		rules:   rules,
+		include: utils.ExtractOptions{Include: rules.Include},
+		exclude: utils.ExtractOptions{Exclude: rules.Exclude},
+	}
+	m.device, _ = device(fi)
+	return m, nil
+}
+
+// Rules returns the rules the matcher was created with
+func (m *Matcher) Rules() Rules {
+	return m.rules
+}
+
+// Walk calls fn for every path under the root that is not skipped, in
+// lexical order, with its slash-separated path relative to the root. The
+// root itself is not passed to fn. With include patterns, a directory is
+// only passed to fn once something inside it is included. The skip counts
+// start from zero on every walk.
+func (m *Matcher) Walk(fn func(file, rel string, fi os.FileInfo) error) error {
+	type pendingDir struct {
+		file, rel string
+		fi        os.FileInfo
+		emitted   bool
+	}
+	var pending []*pendingDir
+	m.ignore = nil
+	m.Excluded, m.TooLarge, m.OtherFS = 0, 0, 0
+
+	return filepath.Walk(m.root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == m.root {
+			if fi.IsDir() {
+				return m.load(file, "")
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(m.root, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if m.skip(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			if err := m.load(file, rel); err != nil {
+				return err
+			}
+		}
+		if len(m.rules.Include) == 0 {
+			return fn(file, rel, fi)
+		}
+
+		// Drop the pending directories this path is not inside of
+		for len(pending) > 0 && !strings.HasPrefix(rel, pending[len(pending)-1].rel+"/") {
+			pending = pending[:len(pending)-1]
+		}
+		if m.include.Selects(rel) {
+			for _, dir := range pending {
+				if !dir.emitted {
+					if err := fn(dir.file, dir.rel, dir.fi); err != nil {
+						return err
+					}
+					dir.emitted = true
+				}
+			}
+			if err := fn(file, rel, fi); err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				pending = append(pending, &pendingDir{file: file, rel: rel, fi: fi, emitted: true})
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			pending = append(pending, &pendingDir{file: file, rel: rel, fi: fi})
+		} else {
+			m.Excluded++
+		}
+		return nil
+	})
+}
+
+// skip reports whether rel is left out of the backup, counting why
+func (m *Matcher) skip(rel string, fi os.FileInfo) bool {
+	if m.rules.OneFilesystem {
+		if dev, ok := device(fi); ok && dev != m.device {
+			m.OtherFS++
+			return true
+		}
+	}
+	if !m.exclude.Selects(rel) {
+		m.Excluded++
+		return true
+	}
+	if m.ignored(rel, fi.IsDir()) {
+		m.Excluded++
+		return true
+	}
+	if m.rules.MaxFileSize > 0 && fi.Mode().IsRegular() && fi.Size() > m.rules.MaxFileSize {
+		m.TooLarge++
+		return true
+	}
+	return false
+}
+
+// ignored applies the .obscureignore rules; as with .gitignore, the last
+// matching rule wins and a "!" rule re-includes what an earlier one ignored
+func (m *Matcher) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range m.ignore {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			sub = rel[len(r.base)+1:]
+		}
+		matched := utils.MatchPattern(r.pattern, sub)
+		if r.anchored {
+			matched = utils.MatchPath(r.pattern, sub)
+		}
+		if matched {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// load reads the .obscureignore of a directory, if it has one
+func (m *Matcher) load(dir, rel string) error {
+	f, err := os.Open(filepath.Join(dir, FileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		r, ok, err := parseRule(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", filepath.Join(dir, FileName), line, err)
+		}
+		if ok {
+			r.base = rel
+			m.ignore = append(m.ignore, r)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseRule parses one line of an ignore file. Blank lines and comments
+// return false.
+func parseRule(line string) (rule, bool, error) {
+	var r rule
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return r, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// A slash anywhere but the end anchors the pattern to the ignore file's
+	// directory; otherwise it matches names at any depth below it
+	r.anchored = strings.Contains(line, "/")
+	line = strings.TrimLeft(line, "/")
+	if line == "" {
+		return r, false, nil
+	}
+	r.pattern = path.Clean(line)
+	if _, err := path.Match(r.pattern, ""); err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
@@ -0,0 +1,176 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeTree creates files, by slash-separated path, under a new directory
+func makeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func walk(t *testing.T, root string, rules Rules) map[string]bool {
+	t.Helper()
+	m, err := New(root, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walked := map[string]bool{}
+	err = m.Walk(func(file, rel string, fi os.FileInfo) error {
+		walked[rel] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return walked
+}
+
+func TestWalk(t *testing.T) {
+	tree := map[string]string{
+		"a.log":                 "",
+		"keep.log":              "",
+		"big.bin":               "0123456789",
+		"build/out.bin":         "",
+		"src/build":             "",
+		"src/main.go":           "",
+		"src/sub/x.tmp":         "",
+		"docs/a.log":            "",
+		"docs/keep.log":         "",
+		"nested/.obscureignore": "*.txt\n",
+		"nested/n.txt":          "",
+		"nested/deep/d.txt":     "",
+		"top.txt":               "",
+	}
+
+	tests := []struct {
+		name    string
+		ignore  string
+		rules   Rules
+		kept    []string
+		skipped []string
+	}{
+		{
+			name:    "negation",
+			ignore:  "*.log\n!keep.log\n",
+			kept:    []string{"keep.log", "docs/keep.log", "src/main.go"},
+			skipped: []string{"a.log", "docs/a.log"},
+		},
+		{
+			name:    "later rules win",
+			ignore:  "!keep.log\n*.log\n",
+			skipped: []string{"a.log", "keep.log", "docs/keep.log"},
+		},
+		{
+			name:    "directory only",
+			ignore:  "build/\n",
+			kept:    []string{"src/build"},
+			skipped: []string{"build", "build/out.bin"},
+		},
+		{
+			name:    "anchored to the ignore file",
+			ignore:  "/a.log\nsrc/sub\n",
+			kept:    []string{"docs/a.log", "src/main.go"},
+			skipped: []string{"a.log", "src/sub", "src/sub/x.tmp"},
+		},
+		{
+			name:    "unanchored matches at any depth",
+			ignore:  "*.tmp\n",
+			kept:    []string{"src/sub"},
+			skipped: []string{"src/sub/x.tmp"},
+		},
+		{
+			name:   "comments and escapes",
+			ignore: "# a.log\n\\#top.txt\n",
+			kept:   []string{"a.log", "top.txt"},
+		},
+		{
+			name:    "nested ignore files apply below their directory",
+			kept:    []string{"top.txt", "nested/.obscureignore"},
+			skipped: []string{"nested/n.txt", "nested/deep/d.txt"},
+		},
+		{
+			name:    "exclude globs",
+			rules:   Rules{Exclude: []string{"docs", "**/*.go"}},
+			kept:    []string{"a.log"},
+			skipped: []string{"docs", "docs/a.log", "src/main.go"},
+		},
+		{
+			name:    "include globs keep the directories above a match",
+			rules:   Rules{Include: []string{"src/sub/*.tmp"}},
+			kept:    []string{"src", "src/sub", "src/sub/x.tmp"},
+			skipped: []string{"a.log", "src/main.go", "build"},
+		},
+		{
+			name:    "size limit",
+			rules:   Rules{MaxFileSize: 5},
+			kept:    []string{"a.log"},
+			skipped: []string{"big.bin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{}
+			for name, data := range tree {
+				files[name] = data
+			}
+			if tt.ignore != "" {
+				files[FileName] = tt.ignore
+			}
+			walked := walk(t, makeTree(t, files), tt.rules)
+			for _, rel := range tt.kept {
+				if !walked[rel] {
+					t.Errorf("%s was skipped", rel)
+				}
+			}
+			for _, rel := range tt.skipped {
+				if walked[rel] {
+					t.Errorf("%s was not skipped", rel)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want rule
+	}{
+		{line: "", ok: false},
+		{line: "# comment", ok: false},
+		{line: "*.log", ok: true, want: rule{pattern: "*.log"}},
+		{line: "!keep.log", ok: true, want: rule{pattern: "keep.log", negate: true}},
+		{line: `\!bang`, ok: true, want: rule{pattern: "!bang"}},
+		{line: "build/", ok: true, want: rule{pattern: "build", dirOnly: true}},
+		{line: "/root.txt", ok: true, want: rule{pattern: "root.txt", anchored: true}},
+		{line: "a/b/  ", ok: true, want: rule{pattern: "a/b", dirOnly: true, anchored: true}},
+		{line: "/", ok: false},
+	}
+	for _, tt := range tests {
+		r, ok, err := parseRule(tt.line)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.line, err)
+		}
+		if ok != tt.ok || (ok && r != tt.want) {
+			t.Errorf("%q: got %+v, %v; want %+v, %v", tt.line, r, ok, tt.want, tt.ok)
+		}
+	}
+	if _, _, err := parseRule("[z-a"); err == nil {
+		t.Error("a malformed pattern was accepted")
+	}
+}
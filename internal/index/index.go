@@ -0,0 +1,212 @@
+// Package index keeps the local file index used by incremental backups.
+// Each tag has an index under ~/.obscure/index/ describing the files as of
+// the latest version in its chain, so the next backup only needs to upload
+// what changed since then.
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shah1011/obscure/internal/ignore"
+)
+
+// ManifestName is the archive entry, written first in every incremental
+// archive, that records the parent version and the deleted paths
+const ManifestName = ".obscure-increment.json"
+
+// Increment is the contents of the ManifestName entry
+type Increment struct {
+	Parent  string   `json:"parent,omitempty"`
+	Base    string   `json:"base"`
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// Entry is what the index remembers about one path
+type Entry struct {
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime int64       `json:"mtime"`
+	Inode   uint64      `json:"inode,omitempty"`
+	Hash    string      `json:"sha256,omitempty"`
+}
+
+// Index describes the files of a tag's latest backup. Paths are
+// slash-separated and relative to SourcePath.
+type Index struct {
+	Provider   string           `json:"provider"`
+	Username   string           `json:"username"`
+	Tag        string           `json:"tag"`
+	SourcePath string           `json:"source_path"`
+	Base       string           `json:"base"`
+	Version    string           `json:"version"`
+	Files      map[string]Entry `json:"files"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+// Plan is the result of comparing a directory against an index
+type Plan struct {
+	// Changed lists new and modified paths, in walk order
+	Changed []string
+	// Deleted lists paths in the index that no longer exist
+	Deleted []string
+	// Index describes the directory as scanned; it becomes the tag's index
+	// once the backup is uploaded
+	Index *Index
+}
+
+func getIndexDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".obscure", "index")
+}
+
+func indexPath(provider, username, tag string) string {
+	return filepath.Join(getIndexDir(), provider, username, tag+".json")
+}
+
+// Load returns the index of tag, or nil if there is none
+func Load(provider, username, tag string) (*Index, error) {
+	data, err := os.ReadFile(indexPath(provider, username, tag))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, err
+	}
+	if idx.Files == nil {
+		idx.Files = map[string]Entry{}
+	}
+	return &idx, nil
+}
+
+// Save writes the index atomically
+func (idx *Index) Save() error {
+	idx.UpdatedAt = time.Now()
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	path := indexPath(idx.Provider, idx.Username, idx.Tag)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Scan walks root through m and compares it with prev, which may be nil for
+// a full backup. A file counts as unchanged when its mode, size, mtime and
+// inode match the index; otherwise it is hashed, so that touched but
+// identical files are not uploaded again. Files excluded by m are left out
+// of the index, and show up as deleted if they were backed up before.
+func Scan(root string, prev *Index, m *ignore.Matcher) (*Plan, error) {
+	plan := &Plan{Index: &Index{SourcePath: root, Files: map[string]Entry{}}}
+	if prev != nil {
+		plan.Index.Provider = prev.Provider
+		plan.Index.Username = prev.Username
+		plan.Index.Tag = prev.Tag
+		plan.Index.Base = prev.Base
+	}
+
+	err := m.Walk(func(file, rel string, fi os.FileInfo) error {
+		var err error
+		entry := Entry{Mode: fi.Mode(), Size: fi.Size(), ModTime: fi.ModTime().UnixNano(), Inode: inode(fi)}
+		if fi.IsDir() {
+			entry.Size = 0
+			entry.ModTime = 0
+		}
+
+		old, known := Entry{}, false
+		if prev != nil {
+			old, known = prev.Files[rel]
+		}
+
+		changed := !known || old.Mode != entry.Mode
+		if !changed && (fi.Mode().IsRegular() || fi.Mode()&fs.ModeSymlink != 0) {
+			if old.Size == entry.Size && old.ModTime == entry.ModTime && old.Inode == entry.Inode {
+				entry.Hash = old.Hash
+			}
+		}
+		if entry.Hash == "" && !fi.IsDir() {
+			if entry.Hash, err = hashEntry(file, fi); err != nil {
+				return err
+			}
+			changed = changed || entry.Hash != old.Hash
+		}
+
+		plan.Index.Files[rel] = entry
+		if changed {
+			plan.Changed = append(plan.Changed, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if prev != nil {
+		for rel := range prev.Files {
+			if _, ok := plan.Index.Files[rel]; !ok {
+				plan.Deleted = append(plan.Deleted, rel)
+			}
+		}
+		sort.Strings(plan.Deleted)
+		plan.Deleted = collapseDeleted(plan.Deleted)
+	}
+	return plan, nil
+}
+
+// collapseDeleted drops paths whose parent directory is deleted as well
+func collapseDeleted(deleted []string) []string {
+	var out []string
+	for _, rel := range deleted {
+		if len(out) > 0 && strings.HasPrefix(rel, out[len(out)-1]+"/") {
+			continue
+		}
+		out = append(out, rel)
+	}
+	return out
+}
+
+// hashEntry hashes a file's contents, or a symlink's target
+func hashEntry(file string, fi os.FileInfo) (string, error) {
+	h := sha256.New()
+	switch {
+	case fi.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(file)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(link))
+	case fi.Mode().IsRegular():
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	default:
+		// Devices, sockets and pipes are tracked by metadata only
+		return "-", nil
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
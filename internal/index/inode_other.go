@@ -0,0 +1,10 @@
+//go:build !unix
+
+package index
+
+import "os"
+
+// inode is unavailable here; size and mtime alone detect changes
+func inode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,370 @@
+// Package keyring keeps the master keys of a user's backups in one small
+// object in the bucket, backups/<user>/keys/keyring. Each backup gets its
+// own random key, sealed to the keyring's active master key, and the master
+// keys are in turn encrypted with a file key that every password wraps.
+// Adding, removing or changing a password therefore only rewrites the
+// keyring, never a backup. The keyring also holds the key of private names,
+// when they are turned on.
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/shah1011/obscure/utils"
+)
+
+const (
+	formatVersion = 1
+	keySize       = 32
+	keysInfo      = "obscure keyring v1"
+	namesInfo     = "obscure keyring names v1"
+)
+
+var (
+	// ErrNoKeyring is returned by Open when the user has no keyring yet
+	ErrNoKeyring = errors.New("no keyring found")
+	// ErrWrongPassword is returned when a password opens none of the slots
+	ErrWrongPassword = errors.New("the password does not unlock the keyring")
+	// ErrLastPassword is returned when removing the only password, which
+	// would leave every backup made with the keyring unrestorable
+	ErrLastPassword = errors.New("cannot remove the keyring's last password")
+)
+
+// file is the keyring as stored in the bucket
+type file struct {
+	Version int    `json:"version"`
+	Slots   []slot `json:"slots"`
+	// Keys is the JSON list of master keys, sealed with the file key as
+	// [12-byte nonce][AES-GCM ciphertext]
+	Keys []byte `json:"keys"`
+	// Names is the key of private names, sealed like Keys. It is left out
+	// while backups have readable names, so anyone can tell which layout a
+	// bucket uses without a password.
+	Names []byte `json:"names,omitempty"`
+}
+
+// slot is the file key encrypted with one password, in .obscure format
+type slot struct {
+	CreatedAt time.Time `json:"created_at"`
+	Sealed    []byte    `json:"sealed"`
+}
+
+// MasterKey is one generation of master key. Rotating adds a new one for
+// future backups and keeps the old ones for the backups sealed to them.
+type MasterKey struct {
+	ID        []byte    `json:"id"`
+	Key       []byte    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Keyring is an unlocked keyring
+type Keyring struct {
+	slots   []slot
+	fileKey []byte
+	keys    []MasterKey
+	names   []byte
+}
+
+// Key returns the object key of username's keyring
+func Key(username string) string {
+	return fmt.Sprintf("backups/%s/keys/keyring", username)
+}
+
+// Exists reports whether username has a keyring
+func Exists(ctx context.Context, backend strg.Backend, username string) (bool, error) {
+	return strg.Exists(ctx, backend, Key(username))
+}
+
+// Create returns a new keyring, with one master key, unlocked by password.
+// It is only stored once saved.
+func Create(password string) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.rekey(password); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// PrivateNames reports whether username's backups have private names. It
+// needs no password.
+func PrivateNames(ctx context.Context, backend strg.Backend, username string) (bool, error) {
+	f, err := load(ctx, backend, username)
+	if errors.Is(err, ErrNoKeyring) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(f.Names) > 0, nil
+}
+
+func load(ctx context.Context, backend strg.Backend, username string) (*file, error) {
+	reader, _, err := backend.Get(ctx, Key(username))
+	if errors.Is(err, strg.ErrNotFound) {
+		return nil, ErrNoKeyring
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download keyring: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download keyring: %w", err)
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("corrupt keyring: %w", err)
+	}
+	if f.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported keyring version %d", f.Version)
+	}
+	return &f, nil
+}
+
+// Open loads username's keyring and unlocks it with password
+func Open(ctx context.Context, backend strg.Backend, username, password string) (*Keyring, error) {
+	f, err := load(ctx, backend, username)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keyring{slots: f.Slots}
+	for _, s := range f.Slots {
+		fileKey, err := openSlot(s, password)
+		if errors.Is(err, utils.ErrHeaderAuth) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		k.fileKey = fileKey
+		break
+	}
+	if k.fileKey == nil {
+		return nil, ErrWrongPassword
+	}
+
+	gcm, err := newGCM(k.fileKey)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := open(gcm, f.Keys, keysInfo)
+	if err != nil {
+		return nil, errors.New("corrupt keyring: master keys do not authenticate")
+	}
+	if err := json.Unmarshal(plain, &k.keys); err != nil {
+		return nil, fmt.Errorf("corrupt keyring: %w", err)
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("corrupt keyring: no master keys")
+	}
+	if len(f.Names) > 0 {
+		if k.names, err = open(gcm, f.Names, namesInfo); err != nil || len(k.names) != keySize {
+			return nil, errors.New("corrupt keyring: private names key does not authenticate")
+		}
+	}
+	return k, nil
+}
+
+// Save stores the keyring, replacing the previous one
+func (k *Keyring) Save(ctx context.Context, backend strg.Backend, username string) error {
+	plain, err := json.Marshal(k.keys)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(k.fileKey)
+	if err != nil {
+		return err
+	}
+	f := file{Version: formatVersion, Slots: k.slots}
+	if f.Keys, err = seal(gcm, plain, keysInfo); err != nil {
+		return err
+	}
+	if k.names != nil {
+		if f.Names, err = seal(gcm, k.names, namesInfo); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := backend.Put(ctx, Key(username), bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		return fmt.Errorf("failed to store keyring: %w", err)
+	}
+	return nil
+}
+
+// Passwords returns the number of passwords that unlock the keyring
+func (k *Keyring) Passwords() int {
+	return len(k.slots)
+}
+
+// Generations returns the number of master keys, the active one included
+func (k *Keyring) Generations() int {
+	return len(k.keys)
+}
+
+// Active returns the master key new backups are sealed to
+func (k *Keyring) Active() MasterKey {
+	return k.keys[len(k.keys)-1]
+}
+
+// Lookup returns the master key with the given ID
+func (k *Keyring) Lookup(id []byte) ([]byte, error) {
+	for _, key := range k.keys {
+		if bytes.Equal(key.ID, id) {
+			return key.Key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %x; it may belong to another bucket", utils.ErrUnknownMasterKey, id)
+}
+
+// WrapKey creates the key of a new backup, sealed to the active master key
+func (k *Keyring) WrapKey() (*utils.WrappedKey, error) {
+	active := k.Active()
+	return utils.WrapKeyWithMaster(active.ID, active.Key)
+}
+
+// NamesKey returns the key of private names, or nil if backups have
+// readable names
+func (k *Keyring) NamesKey() []byte {
+	return k.names
+}
+
+// EnablePrivateNames creates the key of private names. It is kept across
+// rotations, since changing it would lose track of every backup.
+func (k *Keyring) EnablePrivateNames() error {
+	if k.names != nil {
+		return nil
+	}
+	names := make([]byte, keySize)
+	if _, err := rand.Read(names); err != nil {
+		return err
+	}
+	k.names = names
+	return nil
+}
+
+// AddPassword lets password unlock the keyring too
+func (k *Keyring) AddPassword(password string) error {
+	for _, s := range k.slots {
+		if _, err := openSlot(s, password); err == nil {
+			return errors.New("this password already unlocks the keyring")
+		}
+	}
+	s, err := sealSlot(k.fileKey, password)
+	if err != nil {
+		return err
+	}
+	k.slots = append(k.slots, s)
+	return nil
+}
+
+// RemovePassword stops password from unlocking the keyring
+func (k *Keyring) RemovePassword(password string) error {
+	for i, s := range k.slots {
+		if _, err := openSlot(s, password); err != nil {
+			continue
+		}
+		if len(k.slots) == 1 {
+			return ErrLastPassword
+		}
+		k.slots = append(k.slots[:i], k.slots[i+1:]...)
+		return nil
+	}
+	return ErrWrongPassword
+}
+
+// Rotate starts a new master key for future backups and re-encrypts the
+// keyring with a new file key under password alone. Every other password
+// is dropped, so a leaked password or keyring copy unlocks nothing new.
+// Existing backups stay sealed to the master keys they were made with.
+func (k *Keyring) Rotate(password string) error {
+	return k.rekey(password)
+}
+
+// rekey replaces the file key and slots and adds a master key
+func (k *Keyring) rekey(password string) error {
+	master := MasterKey{ID: make([]byte, utils.MasterKeyIDSize), Key: make([]byte, keySize), CreatedAt: time.Now().UTC()}
+	if _, err := rand.Read(master.ID); err != nil {
+		return err
+	}
+	if _, err := rand.Read(master.Key); err != nil {
+		return err
+	}
+	fileKey := make([]byte, keySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return err
+	}
+	s, err := sealSlot(fileKey, password)
+	if err != nil {
+		return err
+	}
+
+	k.keys = append(k.keys, master)
+	k.fileKey = fileKey
+	k.slots = []slot{s}
+	return nil
+}
+
+func sealSlot(fileKey []byte, password string) (slot, error) {
+	sealed, err := utils.EncryptBuffer(bytes.NewBuffer(fileKey), password)
+	if err != nil {
+		return slot{}, err
+	}
+	return slot{CreatedAt: time.Now().UTC(), Sealed: sealed.Bytes()}, nil
+}
+
+// openSlot decrypts the file key in s. A wrong password fails with
+// utils.ErrHeaderAuth.
+func openSlot(s slot, password string) ([]byte, error) {
+	plain, err := utils.DecryptStream(bytes.NewReader(s.Sealed), password)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := io.ReadAll(plain)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileKey) != keySize {
+		return nil, errors.New("corrupt keyring slot")
+	}
+	return fileKey, nil
+}
+
+// seal encrypts plain as [12-byte nonce][AES-GCM ciphertext]
+func seal(gcm cipher.AEAD, plain []byte, info string) ([]byte, error) {
+	sealed := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plain)+gcm.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(sealed, sealed, plain, []byte(info)), nil
+}
+
+func open(gcm cipher.AEAD, sealed []byte, info string) ([]byte, error) {
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(info))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
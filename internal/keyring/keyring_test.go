@@ -0,0 +1,259 @@
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/shah1011/obscure/utils"
+)
+
+const (
+	testUser     = "alice"
+	testPassword = "correct horse battery staple"
+	newPassword  = "tr0ub4dor&3"
+)
+
+// memBackend is a Backend kept in memory
+type memBackend struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{objects: make(map[string][]byte)}
+}
+
+func (b *memBackend) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = data
+	return nil
+}
+
+func (b *memBackend) Get(ctx context.Context, key string) (io.ReadCloser, *strg.ObjectInfo, error) {
+	info, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(b.objects[key])), info, nil
+}
+
+func (b *memBackend) Stat(ctx context.Context, key string) (*strg.ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, strg.ErrNotFound
+	}
+	return &strg.ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (b *memBackend) List(ctx context.Context, prefix string) ([]strg.ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var objects []strg.ObjectInfo
+	for key, data := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, strg.ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	return objects, nil
+}
+
+func (b *memBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, key)
+	return nil
+}
+
+// saved stores k in a new bucket
+func saved(t *testing.T, k *Keyring) *memBackend {
+	t.Helper()
+	backend := newMemBackend()
+	if err := k.Save(context.Background(), backend, testUser); err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func unwrap(t *testing.T, k *Keyring, wrapped *utils.WrappedKey) []byte {
+	t.Helper()
+	unwrapped, err := utils.UnwrapKey(wrapped.Slots, utils.Keys{MasterKey: k.Lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unwrapped.Key
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	if _, err := Open(ctx, newMemBackend(), testUser, testPassword); !errors.Is(err, ErrNoKeyring) {
+		t.Fatalf("opening a missing keyring: got %v, want ErrNoKeyring", err)
+	}
+
+	k, err := Create(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := saved(t, k)
+
+	opened, err := Open(ctx, backend, testUser, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened.Active().Key, k.Active().Key) || opened.Generations() != 1 || opened.Passwords() != 1 {
+		t.Fatal("the reopened keyring differs from the saved one")
+	}
+	if _, err := Open(ctx, backend, testUser, newPassword); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("opening with a wrong password: got %v, want ErrWrongPassword", err)
+	}
+
+	wrapped, err := k.WrapKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrap(t, opened, wrapped), wrapped.Key) {
+		t.Fatal("the reopened keyring unwraps a different key")
+	}
+}
+
+func TestKeyringPasswords(t *testing.T) {
+	ctx := context.Background()
+	k, err := Create(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.AddPassword(newPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.AddPassword(testPassword); err == nil {
+		t.Fatal("a password was added twice")
+	}
+	backend := saved(t, k)
+	for _, password := range []string{testPassword, newPassword} {
+		opened, err := Open(ctx, backend, testUser, password)
+		if err != nil {
+			t.Fatalf("password %q: %v", password, err)
+		}
+		if !bytes.Equal(opened.Active().Key, k.Active().Key) {
+			t.Fatalf("password %q opens a different keyring", password)
+		}
+	}
+
+	if err := k.RemovePassword("not a password"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("removing an unknown password: got %v, want ErrWrongPassword", err)
+	}
+	if err := k.RemovePassword(testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.RemovePassword(newPassword); !errors.Is(err, ErrLastPassword) {
+		t.Fatalf("removing the last password: got %v, want ErrLastPassword", err)
+	}
+	backend = saved(t, k)
+	if _, err := Open(ctx, backend, testUser, testPassword); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("a removed password: got %v, want ErrWrongPassword", err)
+	}
+	if _, err := Open(ctx, backend, testUser, newPassword); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	ctx := context.Background()
+	k, err := Create(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.AddPassword("another password"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := k.WrapKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := k.Active()
+
+	if err := k.Rotate(newPassword); err != nil {
+		t.Fatal(err)
+	}
+	if k.Generations() != 2 || k.Passwords() != 1 {
+		t.Fatalf("after rotating: %d generations and %d passwords, want 2 and 1", k.Generations(), k.Passwords())
+	}
+	if bytes.Equal(k.Active().ID, first.ID) {
+		t.Fatal("rotating kept the active master key")
+	}
+	after, err := k.WrapKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := saved(t, k)
+	for _, password := range []string{testPassword, "another password"} {
+		if _, err := Open(ctx, backend, testUser, password); !errors.Is(err, ErrWrongPassword) {
+			t.Fatalf("password %q still opens the rotated keyring: %v", password, err)
+		}
+	}
+	opened, err := Open(ctx, backend, testUser, newPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Backups of either generation still open
+	if !bytes.Equal(unwrap(t, opened, before), before.Key) {
+		t.Fatal("a backup sealed before rotating no longer unwraps")
+	}
+	if !bytes.Equal(unwrap(t, opened, after), after.Key) {
+		t.Fatal("a backup sealed after rotating does not unwrap")
+	}
+
+	other, err := Create(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.UnwrapKey(after.Slots, utils.Keys{MasterKey: other.Lookup}); !errors.Is(err, utils.ErrUnknownMasterKey) {
+		t.Fatalf("unwrapping with another keyring: got %v, want ErrUnknownMasterKey", err)
+	}
+}
+
+func TestKeyringPrivateNames(t *testing.T) {
+	ctx := context.Background()
+	k, err := Create(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := saved(t, k)
+	if private, err := PrivateNames(ctx, backend, testUser); err != nil || private {
+		t.Fatalf("PrivateNames = %v, %v; want false", private, err)
+	}
+
+	if err := k.EnablePrivateNames(); err != nil {
+		t.Fatal(err)
+	}
+	names := k.NamesKey()
+	if err := k.Rotate(newPassword); err != nil {
+		t.Fatal(err)
+	}
+	backend = saved(t, k)
+	if private, err := PrivateNames(ctx, backend, testUser); err != nil || !private {
+		t.Fatalf("PrivateNames = %v, %v; want true", private, err)
+	}
+	opened, err := Open(ctx, backend, testUser, newPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened.NamesKey(), names) {
+		t.Fatal("the names key changed across a rotation")
+	}
+}
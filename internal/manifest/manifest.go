@@ -0,0 +1,322 @@
+// Package manifest records what a backup contains. A manifest is built
+// while the archive is written and stored next to the backup object, so a
+// backup can later be verified without restoring it.
+package manifest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/shah1011/obscure/utils"
+)
+
+// Extension is the file extension of manifest objects, which sit next to
+// the backup as backups/<user>/<tag>/<version>_<tag>.manifest
+const Extension = "manifest"
+
+// formatVersion 2 added entry metadata, totals and provenance
+const formatVersion = 2
+
+// Kind tells how the backup payload is laid out
+type Kind string
+
+const (
+	// KindTar is a tar archive of a directory
+	KindTar Kind = "tar"
+	// KindFile is the raw contents of a single file
+	KindFile Kind = "file"
+)
+
+// EntryType is the kind of a manifest entry
+type EntryType string
+
+const (
+	TypeFile    EntryType = "file"
+	TypeDir     EntryType = "dir"
+	TypeSymlink EntryType = "symlink"
+	TypeLink    EntryType = "hardlink"
+	TypeOther   EntryType = "other"
+)
+
+// Manifest lists the contents of one backup
+type Manifest struct {
+	FormatVersion int  `json:"format_version"`
+	Kind          Kind `json:"kind"`
+
+	Tag            string    `json:"tag,omitempty"`
+	Version        string    `json:"version,omitempty"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+	SourcePath     string    `json:"source_path,omitempty"`
+	Host           string    `json:"host,omitempty"`
+	ObscureVersion string    `json:"obscure_version,omitempty"`
+	// Name is the file name given to a backup read from stdin
+	Name string `json:"name,omitempty"`
+	// Sources is set instead of SourcePath for a backup of several paths
+	Sources []Source `json:"sources,omitempty"`
+
+	TotalFiles int   `json:"total_files"`
+	TotalDirs  int   `json:"total_dirs"`
+	TotalBytes int64 `json:"total_bytes"`
+
+	Files []Entry `json:"files"`
+}
+
+// Source is one path of a multi-source backup. Its contents are stored
+// under the top-level directory Root of the archive.
+type Source struct {
+	Root string `json:"root"`
+	Path string `json:"path"`
+}
+
+// Entry is one path in the backup. Only regular files have a hash.
+type Entry struct {
+	Path    string      `json:"path"`
+	Type    EntryType   `json:"type,omitempty"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	ModTime time.Time   `json:"mtime,omitempty"`
+	Size    int64       `json:"size"`
+	Link    string      `json:"link,omitempty"`
+	SHA256  string      `json:"sha256,omitempty"`
+}
+
+// IsFile reports whether the entry is a regular file. Format 1 manifests
+// only listed regular files and have no type.
+func (e Entry) IsFile() bool {
+	return e.Type == TypeFile || e.Type == ""
+}
+
+// Key returns the object key of the manifest for tag/version
+func Key(username, tag, version string) string {
+	return fmt.Sprintf("backups/%s/%s/%s_%s.%s", username, tag, version, tag, Extension)
+}
+
+// Encode serializes m, encrypted to key when it is set and otherwise with
+// password unless it is empty (for direct backups, whose payload is not
+// encrypted either)
+func Encode(m *Manifest, password string, key *utils.WrappedKey) ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if password == "" && key == nil {
+		return data, nil
+	}
+	sealed, err := utils.EncryptBufferWithKey(bytes.NewBuffer(data), password, key)
+	if err != nil {
+		return nil, err
+	}
+	return sealed.Bytes(), nil
+}
+
+// Encrypted reports whether encoded manifest data needs a password
+func Encrypted(data []byte) bool {
+	return len(data) > 0 && data[0] != '{'
+}
+
+// Decode reads a manifest written by Encode. Plain manifests are detected
+// by their leading '{'; anything else is decrypted with keys.
+func Decode(r io.Reader, keys utils.Keys) (*Manifest, error) {
+	br := bufio.NewReader(r)
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var plain io.Reader = br
+	if first[0] != '{' {
+		if plain, _, err = utils.DecryptStreamWithKeys(br, keys); err != nil {
+			return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+		}
+	}
+
+	var m Manifest
+	if err := json.NewDecoder(plain).Decode(&m); err != nil {
+		return nil, fmt.Errorf("corrupt manifest: %w", err)
+	}
+	if m.FormatVersion > formatVersion {
+		return nil, fmt.Errorf("manifest format %d is newer than this obscure build supports", m.FormatVersion)
+	}
+	return &m, nil
+}
+
+// Recorder builds a manifest from the archive bytes written to it. It
+// parses the stream in a goroutine, so the archive is only produced once.
+type Recorder struct {
+	pw       *io.PipeWriter
+	done     chan error
+	manifest *Manifest
+}
+
+// NewRecorder starts recording an archive of the given kind
+func NewRecorder(kind Kind) *Recorder {
+	pr, pw := io.Pipe()
+	r := &Recorder{
+		pw:       pw,
+		done:     make(chan error, 1),
+		manifest: &Manifest{FormatVersion: formatVersion, Kind: kind},
+	}
+	go func() {
+		files, err := hashPayload(pr, kind)
+		r.manifest.Files = files
+		for _, entry := range files {
+			switch entry.Type {
+			case TypeFile:
+				r.manifest.TotalFiles++
+				r.manifest.TotalBytes += entry.Size
+			case TypeDir:
+				r.manifest.TotalDirs++
+			}
+		}
+		// Drain whatever follows the end of the archive so writers never block
+		io.Copy(io.Discard, pr)
+		pr.CloseWithError(err)
+		r.done <- err
+	}()
+	return r
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	return r.pw.Write(p)
+}
+
+// Close finishes recording and reports whether the archive could be parsed
+func (r *Recorder) Close() error {
+	r.pw.Close()
+	return <-r.done
+}
+
+// Abort stops recording after the archive failed to be written
+func (r *Recorder) Abort(err error) {
+	r.pw.CloseWithError(err)
+	<-r.done
+}
+
+// Manifest returns the recorded manifest; it is complete after Close
+func (r *Recorder) Manifest() *Manifest {
+	return r.manifest
+}
+
+// hashPayload lists every entry of a payload and hashes the regular files
+func hashPayload(r io.Reader, kind Kind) ([]Entry, error) {
+	if kind == KindFile {
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		if err != nil {
+			return nil, err
+		}
+		return []Entry{{Path: ".", Type: TypeFile, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}}, nil
+	}
+
+	var files []Entry
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+		entry := Entry{
+			Path:    header.Name,
+			Mode:    header.FileInfo().Mode(),
+			ModTime: header.ModTime.UTC(),
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			h := sha256.New()
+			n, err := io.Copy(h, tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+			}
+			entry.Type = TypeFile
+			entry.Size = n
+			entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+		case tar.TypeDir:
+			entry.Type = TypeDir
+		case tar.TypeSymlink:
+			entry.Type = TypeSymlink
+			entry.Link = header.Linkname
+		case tar.TypeLink:
+			entry.Type = TypeLink
+			entry.Link = header.Linkname
+		default:
+			entry.Type = TypeOther
+		}
+		files = append(files, entry)
+	}
+}
+
+// Report is the outcome of Verify
+type Report struct {
+	Verified   int
+	Mismatched []string
+	Missing    []string
+	Unexpected []string
+}
+
+// OK reports whether the payload matched the manifest exactly
+func (r *Report) OK() bool {
+	return len(r.Mismatched) == 0 && len(r.Missing) == 0 && len(r.Unexpected) == 0
+}
+
+// Verify hashes the decrypted, decompressed payload in r and compares every
+// file with the manifest. A nil manifest only checks that the payload reads
+// to the end, which still authenticates every encrypted chunk.
+func Verify(r io.Reader, kind Kind, m *Manifest) (*Report, error) {
+	files, err := hashPayload(r, kind)
+	if err != nil {
+		return nil, err
+	}
+	// Read past the end of the tar archive so the final encrypted chunk is
+	// authenticated too
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	if m == nil {
+		for _, entry := range files {
+			if entry.IsFile() {
+				report.Verified++
+			}
+		}
+		return report, nil
+	}
+
+	expected := make(map[string]Entry, len(m.Files))
+	for _, entry := range m.Files {
+		if entry.IsFile() {
+			expected[entry.Path] = entry
+		}
+	}
+	for _, entry := range files {
+		if !entry.IsFile() {
+			continue
+		}
+		want, ok := expected[entry.Path]
+		if !ok {
+			report.Unexpected = append(report.Unexpected, entry.Path)
+			continue
+		}
+		delete(expected, entry.Path)
+		if want.SHA256 != entry.SHA256 || want.Size != entry.Size {
+			report.Mismatched = append(report.Mismatched, entry.Path)
+			continue
+		}
+		report.Verified++
+	}
+	for path := range expected {
+		report.Missing = append(report.Missing, path)
+	}
+	sort.Strings(report.Missing)
+	return report, nil
+}
@@ -0,0 +1,248 @@
+// Package naming maps backup names (tag, version and extension) to object
+// keys and back.
+//
+// The readable layout is backups/<user>/<tag>/<version>_<tag>.<ext>. With
+// private names the whole name is encrypted deterministically into a single
+// opaque component, backups/<user>/<name>, so the provider sees neither the
+// tags nor which backups belong together, and object metadata is sealed.
+// Sizes and upload times stay visible.
+package naming
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of the key private names are derived from
+const KeySize = 32
+
+// sealedField is the only metadata field of an object with private names
+const sealedField = "sealed"
+
+// reserved lists the directories of a user that hold no backups: the chunk
+// repository and the keyring
+var reserved = map[string]bool{"chunks": true, "keys": true}
+
+// Reserved reports whether tag names one of the directories that hold no
+// backups, so that no backup is created or deleted under it
+func Reserved(tag string) bool {
+	return reserved[tag]
+}
+
+var nameEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Name identifies one object of a backup: the backup itself, or its
+// manifest or snapshot, told apart by the extension
+type Name struct {
+	Tag       string
+	Version   string
+	Extension string
+}
+
+// Namer maps the names of one user's backups to object keys
+type Namer struct {
+	username string
+	// Subkeys of the private names key; nil for readable names
+	macKey  []byte
+	encKey  []byte
+	metaKey []byte
+}
+
+// Plain returns the namer of the readable layout
+func Plain(username string) *Namer {
+	return &Namer{username: username}
+}
+
+// Private returns a namer that encrypts names and metadata with key
+func Private(username string, key []byte) (*Namer, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid private names key length %d", len(key))
+	}
+	return &Namer{
+		username: username,
+		macKey:   subkey(key, "obscure names mac"),
+		encKey:   subkey(key, "obscure names enc"),
+		metaKey:  subkey(key, "obscure names metadata"),
+	}, nil
+}
+
+func subkey(key []byte, info string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(info))
+	return mac.Sum(nil)
+}
+
+// IsPrivate reports whether names are encrypted
+func (n *Namer) IsPrivate() bool {
+	return n.macKey != nil
+}
+
+// Key returns the object key of tag/version with the given extension
+func (n *Namer) Key(tag, version, extension string) string {
+	if !n.IsPrivate() {
+		return fmt.Sprintf("backups/%s/%s/%s_%s.%s", n.username, tag, version, tag, extension)
+	}
+	return fmt.Sprintf("backups/%s/%s", n.username, n.seal(Name{tag, version, extension}))
+}
+
+// ListPrefix returns the prefix to list to find the objects of tag, or of
+// every tag if it is empty. Private names are not grouped by tag, so the
+// listing has to be filtered by Parse.
+func (n *Namer) ListPrefix(tag string) string {
+	if tag == "" || n.IsPrivate() {
+		return fmt.Sprintf("backups/%s/", n.username)
+	}
+	return fmt.Sprintf("backups/%s/%s/", n.username, tag)
+}
+
+// Parse returns the name of key. Readable keys are understood by every
+// namer, so backups made before private names were turned on, or while they
+// were being renamed, still show up. It returns false for keys that name no
+// backup, such as chunks and the keyring.
+func (n *Namer) Parse(key string) (Name, bool) {
+	rest, ok := strings.CutPrefix(key, fmt.Sprintf("backups/%s/", n.username))
+	if !ok {
+		return Name{}, false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) == 1 && n.IsPrivate() {
+		return n.open(parts[0])
+	}
+	if len(parts) != 2 || reserved[parts[0]] {
+		return Name{}, false
+	}
+
+	tag, file := parts[0], parts[1]
+	dot := strings.LastIndex(file, ".")
+	if dot == -1 {
+		return Name{}, false
+	}
+	// Files are named version_tag.ext; very old ones may carry something
+	// else after the underscore
+	version, ok := strings.CutSuffix(file[:dot], "_"+tag)
+	if !ok {
+		version = strings.Split(file[:dot], "_")[0]
+	}
+	return Name{Tag: tag, Version: version, Extension: file[dot+1:]}, true
+}
+
+// seal encrypts name with a synthetic IV: the IV is a MAC of the name, so
+// the same name always gives the same key and any change to the key is
+// detected. The name is padded to a whole block to blur its length.
+func (n *Namer) seal(name Name) string {
+	plain := []byte(name.Tag + "\x00" + name.Version + "\x00" + name.Extension)
+	plain = append(plain, make([]byte, aes.BlockSize-len(plain)%aes.BlockSize)...)
+
+	mac := hmac.New(sha256.New, n.macKey)
+	mac.Write(plain)
+	iv := mac.Sum(nil)[:aes.BlockSize]
+
+	out := make([]byte, aes.BlockSize+len(plain))
+	copy(out, iv)
+	n.stream(iv).XORKeyStream(out[aes.BlockSize:], plain)
+	return strings.ToLower(nameEncoding.EncodeToString(out))
+}
+
+// open reverses seal. Components that were not sealed with this key fail
+// to authenticate.
+func (n *Namer) open(component string) (Name, bool) {
+	data, err := nameEncoding.DecodeString(strings.ToUpper(component))
+	if err != nil || len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return Name{}, false
+	}
+	iv := data[:aes.BlockSize]
+	plain := make([]byte, len(data)-aes.BlockSize)
+	n.stream(iv).XORKeyStream(plain, data[aes.BlockSize:])
+
+	mac := hmac.New(sha256.New, n.macKey)
+	mac.Write(plain)
+	if !hmac.Equal(mac.Sum(nil)[:aes.BlockSize], iv) {
+		return Name{}, false
+	}
+	fields := strings.Split(string(bytes.TrimRight(plain, "\x00")), "\x00")
+	if len(fields) != 3 {
+		return Name{}, false
+	}
+	return Name{Tag: fields[0], Version: fields[1], Extension: fields[2]}, true
+}
+
+func (n *Namer) stream(iv []byte) cipher.Stream {
+	block, err := aes.NewCipher(n.encKey)
+	if err != nil {
+		// The key length is fixed, so this cannot happen
+		panic(err)
+	}
+	return cipher.NewCTR(block, iv)
+}
+
+// SealMetadata returns the metadata to store with an object. With private
+// names it is encrypted into a single field.
+func (n *Namer) SealMetadata(metadata map[string]string) (map[string]string, error) {
+	if !n.IsPrivate() {
+		return metadata, nil
+	}
+	plain, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := n.metadataCipher()
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plain)+gcm.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, err
+	}
+	sealed = gcm.Seal(sealed, sealed, plain, nil)
+	return map[string]string{sealedField: base64.RawURLEncoding.EncodeToString(sealed)}, nil
+}
+
+// OpenMetadata returns the metadata stored with an object, decrypting it if
+// it was sealed. Readable metadata is returned as is.
+func (n *Namer) OpenMetadata(metadata map[string]string) (map[string]string, error) {
+	value, ok := metadata[sealedField]
+	if !ok {
+		return metadata, nil
+	}
+	if !n.IsPrivate() {
+		return nil, errors.New("object metadata is encrypted, but private names are not enabled")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt object metadata: %w", err)
+	}
+	gcm, err := n.metadataCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("corrupt object metadata")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("object metadata does not authenticate")
+	}
+	var opened map[string]string
+	if err := json.Unmarshal(plain, &opened); err != nil {
+		return nil, fmt.Errorf("corrupt object metadata: %w", err)
+	}
+	return opened, nil
+}
+
+func (n *Namer) metadataCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(n.metaKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
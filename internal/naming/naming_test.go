@@ -0,0 +1,165 @@
+package naming
+
+import (
+	"crypto/rand"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func privateNamer(t *testing.T, key []byte) *Namer {
+	t.Helper()
+	n, err := Private("alice", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPlainNames(t *testing.T) {
+	n := Plain("alice")
+	key := n.Key("photos", "1.0", "obscure")
+	if key != "backups/alice/photos/1.0_photos.obscure" {
+		t.Fatalf("Key = %q", key)
+	}
+	if prefix := n.ListPrefix("photos"); prefix != "backups/alice/photos/" {
+		t.Fatalf("ListPrefix = %q", prefix)
+	}
+
+	tests := []struct {
+		key  string
+		want Name
+		ok   bool
+	}{
+		{key: key, want: Name{"photos", "1.0", "obscure"}, ok: true},
+		{key: "backups/alice/photos/1.0_photos.manifest", want: Name{"photos", "1.0", "manifest"}, ok: true},
+		{key: "backups/alice/photos/2_old.tar", want: Name{"photos", "2", "tar"}, ok: true},
+		{key: "backups/alice/photos/noextension", ok: false},
+		{key: "backups/bob/photos/1.0_photos.obscure", ok: false},
+		{key: "backups/alice/chunks/ab.chunk", ok: false},
+		{key: "backups/alice/keys/keyring", ok: false},
+		{key: "backups/alice/photos/deep/1.0_photos.obscure", ok: false},
+	}
+	for _, tt := range tests {
+		name, ok := n.Parse(tt.key)
+		if ok != tt.ok || (ok && name != tt.want) {
+			t.Errorf("Parse(%q) = %+v, %v; want %+v, %v", tt.key, name, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReserved(t *testing.T) {
+	for tag, want := range map[string]bool{"chunks": true, "keys": true, "photos": false, "": false} {
+		if Reserved(tag) != want {
+			t.Errorf("Reserved(%q) = %v, want %v", tag, !want, want)
+		}
+	}
+}
+
+func TestPrivateNames(t *testing.T) {
+	n := privateNamer(t, testKey(t))
+	if !n.IsPrivate() || Plain("alice").IsPrivate() {
+		t.Fatal("IsPrivate does not tell the layouts apart")
+	}
+
+	key := n.Key("photos", "1.0", "obscure")
+	if strings.Contains(key, "photos") || strings.Count(key, "/") != 2 {
+		t.Fatalf("private key %q reveals the name", key)
+	}
+	if n.Key("photos", "1.0", "obscure") != key {
+		t.Fatal("the same name gives different keys")
+	}
+	if n.Key("photos", "1.0", "manifest") == key {
+		t.Fatal("different names give the same key")
+	}
+	if prefix := n.ListPrefix("photos"); prefix != "backups/alice/" {
+		t.Fatalf("ListPrefix = %q", prefix)
+	}
+
+	name, ok := n.Parse(key)
+	if !ok || name != (Name{"photos", "1.0", "obscure"}) {
+		t.Fatalf("Parse = %+v, %v", name, ok)
+	}
+	// Readable keys are still understood, reserved directories are not
+	if name, ok := n.Parse("backups/alice/photos/0.9_photos.obscure"); !ok || name.Version != "0.9" {
+		t.Fatalf("Parse of a readable key = %+v, %v", name, ok)
+	}
+	for _, key := range []string{"backups/alice/chunks/ab.chunk", "backups/alice/keys/keyring", "backups/alice/keys"} {
+		if _, ok := n.Parse(key); ok {
+			t.Errorf("Parse(%q) succeeded", key)
+		}
+	}
+}
+
+func TestPrivateNamesWrongKey(t *testing.T) {
+	key := privateNamer(t, testKey(t)).Key("photos", "1.0", "obscure")
+	if _, ok := privateNamer(t, testKey(t)).Parse(key); ok {
+		t.Fatal("a name sealed with another key was opened")
+	}
+
+	n := privateNamer(t, testKey(t))
+	key = n.Key("photos", "1.0", "obscure")
+	data, err := nameEncoding.DecodeString(strings.ToUpper(key[strings.LastIndex(key, "/")+1:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if _, ok := n.Parse("backups/alice/" + strings.ToLower(nameEncoding.EncodeToString(data))); ok {
+		t.Fatal("a tampered name was opened")
+	}
+
+	if _, err := Private("alice", make([]byte, KeySize-1)); err == nil {
+		t.Fatal("a short key was accepted")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	metadata := map[string]string{"tag": "photos", "version": "1.0", "sha256": "abc"}
+
+	plain := Plain("alice")
+	sealed, err := plain.SealMetadata(metadata)
+	if err != nil || !maps.Equal(sealed, metadata) {
+		t.Fatalf("readable metadata was changed: %v, %v", sealed, err)
+	}
+
+	n := privateNamer(t, testKey(t))
+	sealed, err = n.SealMetadata(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sealed[sealedField]; !ok || len(sealed) != 1 {
+		t.Fatalf("sealed metadata has fields %v", sealed)
+	}
+	for _, value := range sealed {
+		if strings.Contains(value, "photos") {
+			t.Fatal("sealed metadata reveals the tag")
+		}
+	}
+	opened, err := n.OpenMetadata(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(opened, metadata) {
+		t.Fatalf("OpenMetadata = %v, want %v", opened, metadata)
+	}
+
+	if _, err := privateNamer(t, testKey(t)).OpenMetadata(sealed); err == nil {
+		t.Fatal("metadata sealed with another key was opened")
+	}
+	if _, err := plain.OpenMetadata(sealed); err == nil {
+		t.Fatal("sealed metadata was opened without private names")
+	}
+	// Readable metadata of older objects is returned as is
+	if opened, err := n.OpenMetadata(metadata); err != nil || !maps.Equal(opened, metadata) {
+		t.Fatalf("OpenMetadata of readable metadata = %v, %v", opened, err)
+	}
+}
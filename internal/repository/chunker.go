@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Chunk size bounds of the content-defined chunker. Changing any of these,
+// or the gear table, changes every chunk boundary and defeats deduplication
+// against existing snapshots.
+const (
+	MinChunkSize = 256 * 1024
+	AvgChunkSize = 1024 * 1024
+	MaxChunkSize = 4 * 1024 * 1024
+)
+
+// FastCDC normalized chunking: a stricter mask below the average size and a
+// looser one above it pulls chunk sizes towards AvgChunkSize. The masks test
+// the high bits of the gear hash, which depend on the last 64 bytes.
+const (
+	maskStrict = uint64(0xFFFFFC0000000000) // 22 bits
+	maskLoose  = uint64(0xFFFC000000000000) // 18 bits
+)
+
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{'o', 'b', 's', 'c', 'u', 'r', 'e', byte(i)})
+		table[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks, so that an insertion
+// or deletion only changes the chunks around it
+type Chunker struct {
+	reader io.Reader
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// NewChunker returns a chunker reading from r
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{reader: r, buf: make([]byte, MaxChunkSize)}
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The returned
+// slice is only valid until the following call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	data := c.buf[c.start:c.end]
+	n := cutPoint(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// fill tops the buffer up to MaxChunkSize bytes, moving unread data to the front
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= MaxChunkSize {
+		return nil
+	}
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+
+	n, err := io.ReadFull(c.reader, c.buf[c.end:])
+	c.end += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+// cutPoint returns the length of the chunk at the start of data
+func cutPoint(data []byte) int {
+	if len(data) <= MinChunkSize {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > MaxChunkSize {
+		limit = MaxChunkSize
+	}
+	normal := AvgChunkSize
+	if normal > limit {
+		normal = limit
+	}
+
+	var hash uint64
+	i := MinChunkSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskStrict == 0 {
+			return i + 1
+		}
+	}
+	for ; i < limit; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskLoose == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
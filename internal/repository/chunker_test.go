@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// pseudoRandom returns n reproducible bytes that do not compress
+func pseudoRandom(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var out [][]byte
+	c := NewChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunkerBounds(t *testing.T) {
+	data := pseudoRandom(1, 24*1024*1024)
+	got := chunks(t, data)
+	if !bytes.Equal(bytes.Join(got, nil), data) {
+		t.Fatal("the chunks do not add up to the input")
+	}
+	for i, chunk := range got {
+		if len(chunk) > MaxChunkSize {
+			t.Fatalf("chunk %d is %d bytes, above the maximum", i, len(chunk))
+		}
+		if len(chunk) < MinChunkSize && i != len(got)-1 {
+			t.Fatalf("chunk %d is %d bytes, below the minimum", i, len(chunk))
+		}
+	}
+	if avg := len(data) / len(got); avg < AvgChunkSize/2 || avg > 2*AvgChunkSize {
+		t.Fatalf("average chunk size %d is far from %d", avg, AvgChunkSize)
+	}
+}
+
+func TestChunkerSmallInputs(t *testing.T) {
+	if got := chunks(t, nil); len(got) != 0 {
+		t.Fatalf("empty input gave %d chunks", len(got))
+	}
+	data := pseudoRandom(2, MinChunkSize)
+	if got := chunks(t, data); len(got) != 1 || !bytes.Equal(got[0], data) {
+		t.Fatalf("input of the minimum size gave %d chunks, want 1", len(got))
+	}
+	// Data without any cut point is split at the maximum size
+	zeros := make([]byte, 2*MaxChunkSize+10)
+	if n := cutPoint(zeros); n != MaxChunkSize {
+		t.Fatalf("cutPoint of zeros = %d, want %d", n, MaxChunkSize)
+	}
+}
+
+func TestChunkerResynchronizes(t *testing.T) {
+	data := pseudoRandom(3, 16*1024*1024)
+	edited := append(append(append([]byte(nil), data[:100]...), []byte("inserted")...), data[100:]...)
+
+	before := map[string]bool{}
+	for _, chunk := range chunks(t, data) {
+		before[string(chunk)] = true
+	}
+	after := chunks(t, edited)
+	shared := 0
+	for _, chunk := range after {
+		if before[string(chunk)] {
+			shared++
+		}
+	}
+	// Only the chunk around the insertion changes
+	if shared < len(after)-1 {
+		t.Fatalf("%d of %d chunks changed after a small insertion", len(after)-shared, len(after))
+	}
+}
@@ -0,0 +1,267 @@
+// Package repository implements the deduplicating backup format. Files are
+// split into content-defined chunks which are stored encrypted once under
+// backups/<user>/chunks/, and each backup version is a small encrypted
+// snapshot listing the chunks of every file.
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/shah1011/obscure/utils"
+)
+
+const (
+	// SnapshotExtension is the file extension of snapshot manifests, which
+	// sit next to regular backups under backups/<user>/<tag>/
+	SnapshotExtension = "snapshot"
+
+	configVersion = 1
+	keySize       = 32
+	nonceSize     = 12
+)
+
+// ErrNoRepository is returned by Open when the user has no chunk store yet
+var ErrNoRepository = errors.New("no repository found")
+
+// repoConfig holds the random repository keys. It is stored encrypted with
+// the user's password, so the password protects every chunk and snapshot
+// while the keys themselves never change.
+type repoConfig struct {
+	Version  int    `json:"version"`
+	ChunkKey []byte `json:"chunk_key"`
+	IDKey    []byte `json:"id_key"`
+}
+
+// Repository is an open chunk store
+type Repository struct {
+	backend strg.Backend
+	prefix  string
+	gcm     cipher.AEAD
+	idKey   []byte
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+// ChunksPrefix returns the key prefix of username's chunk store
+func ChunksPrefix(username string) string {
+	return fmt.Sprintf("backups/%s/chunks/", username)
+}
+
+// SnapshotKey returns the object key of a snapshot manifest
+func SnapshotKey(username, tag, version string) string {
+	return fmt.Sprintf("backups/%s/%s/%s_%s.%s", username, tag, version, tag, SnapshotExtension)
+}
+
+// Open unlocks username's repository with password. If create is set and
+// there is no repository yet, a new one is initialized.
+func Open(ctx context.Context, backend strg.Backend, username, password string, create bool) (*Repository, error) {
+	prefix := ChunksPrefix(username)
+	configKey := prefix + "config"
+
+	var config repoConfig
+	reader, _, err := backend.Get(ctx, configKey)
+	switch {
+	case errors.Is(err, strg.ErrNotFound):
+		if !create {
+			return nil, ErrNoRepository
+		}
+		config = repoConfig{Version: configVersion, ChunkKey: make([]byte, keySize), IDKey: make([]byte, keySize)}
+		if _, err := rand.Read(config.ChunkKey); err != nil {
+			return nil, err
+		}
+		if _, err := rand.Read(config.IDKey); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := utils.EncryptBuffer(bytes.NewBuffer(data), password)
+		if err != nil {
+			return nil, err
+		}
+		if err := backend.Put(ctx, configKey, sealed, int64(sealed.Len()), nil); err != nil {
+			return nil, fmt.Errorf("failed to create repository: %w", err)
+		}
+	case err != nil:
+		return nil, err
+	default:
+		defer reader.Close()
+		plain, err := utils.DecryptStream(reader, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock repository: %w", err)
+		}
+		data, err := io.ReadAll(plain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock repository: %w", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("corrupt repository config: %w", err)
+		}
+		if config.Version != configVersion || len(config.ChunkKey) != keySize || len(config.IDKey) != keySize {
+			return nil, fmt.Errorf("unsupported repository config version %d", config.Version)
+		}
+	}
+
+	block, err := aes.NewCipher(config.ChunkKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Repository{
+		backend: backend,
+		prefix:  prefix,
+		gcm:     gcm,
+		idKey:   config.IDKey,
+		encoder: encoder,
+		decoder: decoder,
+	}, nil
+}
+
+// Close releases the compression state
+func (r *Repository) Close() {
+	r.encoder.Close()
+	r.decoder.Close()
+}
+
+// ChunkID returns the keyed hash identifying data. Keying the hash with a
+// repository secret keeps chunk names from revealing file contents.
+func (r *Repository) ChunkID(data []byte) string {
+	mac := hmac.New(sha256.New, r.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Repository) chunkKey(id string) string {
+	return r.prefix + id[:2] + "/" + id
+}
+
+// loadIndex lists the chunks already stored, once per repository
+func (r *Repository) loadIndex(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known != nil {
+		return nil
+	}
+
+	objects, err := r.backend.List(ctx, r.prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list chunks: %w", err)
+	}
+	r.known = make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		name := obj.Key[strings.LastIndex(obj.Key, "/")+1:]
+		if len(name) == 2*sha256.Size {
+			r.known[name] = true
+		}
+	}
+	return nil
+}
+
+// hasChunk reports whether id is stored, and marks it as stored so that
+// concurrent uploads of the same chunk only happen once
+func (r *Repository) hasChunk(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.known[id] {
+		return true
+	}
+	r.known[id] = true
+	return false
+}
+
+func (r *Repository) forgetChunk(id string) {
+	r.mu.Lock()
+	delete(r.known, id)
+	r.mu.Unlock()
+}
+
+// putChunk compresses, encrypts and uploads one chunk
+func (r *Repository) putChunk(ctx context.Context, id string, data []byte) (int64, error) {
+	sealed, err := r.seal(r.encoder.EncodeAll(data, nil), []byte(id))
+	if err != nil {
+		return 0, err
+	}
+	if err := r.backend.Put(ctx, r.chunkKey(id), bytes.NewReader(sealed), int64(len(sealed)), nil); err != nil {
+		return 0, fmt.Errorf("failed to upload chunk %s: %w", id[:12], err)
+	}
+	return int64(len(sealed)), nil
+}
+
+// getChunk downloads a chunk and checks that its contents match its ID
+func (r *Repository) getChunk(ctx context.Context, id string) ([]byte, error) {
+	reader, _, err := r.backend.Get(ctx, r.chunkKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download chunk %s: %w", id[:12], err)
+	}
+	defer reader.Close()
+
+	sealed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download chunk %s: %w", id[:12], err)
+	}
+	compressed, err := r.open(sealed, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", id[:12], err)
+	}
+	data, err := r.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", id[:12], err)
+	}
+	if !hmac.Equal([]byte(r.ChunkID(data)), []byte(id)) {
+		return nil, fmt.Errorf("chunk %s: content does not match its ID", id[:12])
+	}
+	return data, nil
+}
+
+// seal encrypts data as [nonce][ciphertext]. The nonce is random; with a
+// 96-bit nonce that is safe far beyond any realistic number of chunks.
+func (r *Repository) seal(data, aad []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize, nonceSize+len(data)+r.gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return r.gcm.Seal(nonce, nonce, data, aad), nil
+}
+
+func (r *Repository) open(sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < nonceSize+r.gcm.Overhead() {
+		return nil, errors.New("encrypted object is truncated")
+	}
+	plain, err := r.gcm.Open(nil, sealed[:nonceSize], sealed[nonceSize:], aad)
+	if err != nil {
+		return nil, errors.New("authentication failed: wrong repository or tampered data")
+	}
+	return plain, nil
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/shah1011/obscure/internal/ignore"
+	strg "github.com/shah1011/obscure/internal/storage"
+	"github.com/shah1011/obscure/utils"
+)
+
+const (
+	testUser     = "alice"
+	testPassword = "correct horse battery staple"
+)
+
+// memBackend is a Backend kept in memory
+type memBackend struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{objects: make(map[string][]byte)}
+}
+
+func (b *memBackend) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = data
+	return nil
+}
+
+func (b *memBackend) Get(ctx context.Context, key string) (io.ReadCloser, *strg.ObjectInfo, error) {
+	info, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return io.NopCloser(bytes.NewReader(b.objects[key])), info, nil
+}
+
+func (b *memBackend) Stat(ctx context.Context, key string) (*strg.ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[key]
+	if !ok {
+		return nil, strg.ErrNotFound
+	}
+	return &strg.ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (b *memBackend) List(ctx context.Context, prefix string) ([]strg.ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var objects []strg.ObjectInfo
+	for key, data := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, strg.ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	return objects, nil
+}
+
+func (b *memBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, key)
+	return nil
+}
+
+// chunkKeys returns the keys of the stored chunks
+func (b *memBackend) chunkKeys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var keys []string
+	for key := range b.objects {
+		if strings.HasPrefix(key, ChunksPrefix(testUser)) && !strings.HasSuffix(key, "/config") {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// makeSource creates a tree with a file large enough for several chunks, a
+// copy of it and a symlink
+func makeSource(t *testing.T) string {
+	t.Helper()
+	source := t.TempDir()
+	big := pseudoRandom(4, 3*MaxChunkSize)
+	files := map[string][]byte{
+		"big.bin":       big,
+		"sub/copy.bin":  big,
+		"sub/small.txt": []byte("hello"),
+		"empty/.keep":   nil,
+	}
+	for name, data := range files {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sub/small.txt", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+func openRepo(t *testing.T, backend strg.Backend, create bool) *Repository {
+	t.Helper()
+	r, err := Open(context.Background(), backend, testUser, testPassword, create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func backup(t *testing.T, r *Repository, source, version string) *Stats {
+	t.Helper()
+	m, err := ignore.New(source, ignore.Rules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := r.Backup(context.Background(), source, testUser, "tag", version, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	if _, err := Open(ctx, backend, testUser, testPassword, false); !errors.Is(err, ErrNoRepository) {
+		t.Fatalf("opening a missing repository: got %v, want ErrNoRepository", err)
+	}
+	created := openRepo(t, backend, true)
+	reopened := openRepo(t, backend, false)
+	if created.ChunkID([]byte("data")) != reopened.ChunkID([]byte("data")) {
+		t.Fatal("the reopened repository has different keys")
+	}
+	if _, err := Open(ctx, backend, testUser, "wrong password", false); err == nil {
+		t.Fatal("the repository opened with a wrong password")
+	}
+}
+
+func TestBackupDeduplicates(t *testing.T) {
+	backend := newMemBackend()
+	source := makeSource(t)
+
+	r := openRepo(t, backend, true)
+	first := backup(t, r, source, "1")
+	if first.Files != 7 {
+		t.Fatalf("backed up %d entries, want 7", first.Files)
+	}
+	// The copy of big.bin adds no chunks
+	stored := len(backend.chunkKeys())
+	if first.NewChunks != stored || first.NewChunks >= first.Chunks {
+		t.Fatalf("%d new chunks of %d, %d stored; the copy was not deduplicated", first.NewChunks, first.Chunks, stored)
+	}
+
+	// A later backup by another process finds the stored chunks
+	second := backup(t, openRepo(t, backend, false), source, "2")
+	if second.NewChunks != 0 || second.UploadedBytes != 0 {
+		t.Fatalf("the unchanged tree uploaded %d chunks", second.NewChunks)
+	}
+	if len(backend.chunkKeys()) != stored {
+		t.Fatal("the second backup stored more chunks")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	source := makeSource(t)
+	backup(t, openRepo(t, backend, true), source, "1")
+
+	r := openRepo(t, backend, false)
+	snapshot, err := r.LoadSnapshot(ctx, testUser, "tag", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Tag != "tag" || snapshot.Version != "1" || len(snapshot.Files) != 7 {
+		t.Fatalf("loaded snapshot %s/%s with %d entries", snapshot.Tag, snapshot.Version, len(snapshot.Files))
+	}
+
+	outputDir := t.TempDir()
+	if _, err := r.Restore(ctx, snapshot, outputDir, &utils.ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"big.bin", "sub/copy.bin", "sub/small.txt", "empty/.keep"} {
+		want, _ := os.ReadFile(filepath.Join(source, filepath.FromSlash(name)))
+		got, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("%s was not restored: %v", name, err)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(outputDir, "link")); err != nil || target != "sub/small.txt" {
+		t.Fatalf("link restored as %q, %v", target, err)
+	}
+}
+
+func TestSnapshotTampering(t *testing.T) {
+	ctx := context.Background()
+	backend := newMemBackend()
+	backup(t, openRepo(t, backend, true), makeSource(t), "1")
+	r := openRepo(t, backend, false)
+
+	// A snapshot moved to another version does not authenticate
+	backend.objects[SnapshotKey(testUser, "tag", "2")] = backend.objects[SnapshotKey(testUser, "tag", "1")]
+	if _, err := r.LoadSnapshot(ctx, testUser, "tag", "2"); err == nil {
+		t.Fatal("a snapshot stored under another version was loaded")
+	}
+
+	snapshot, err := r.LoadSnapshot(ctx, testUser, "tag", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, damaged, err := r.Verify(ctx, snapshot); err != nil || len(damaged) != 0 || verified != len(snapshot.Files) {
+		t.Fatalf("Verify of an intact snapshot: %d verified, damaged %v, %v", verified, damaged, err)
+	}
+
+	var small FileEntry
+	for _, entry := range snapshot.Files {
+		if entry.Path == "sub/small.txt" {
+			small = entry
+		}
+	}
+	key := r.chunkKey(small.Chunks[0])
+	backend.objects[key][len(backend.objects[key])-1] ^= 1
+	_, damaged, err := r.Verify(ctx, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(damaged) != 1 || damaged[0] != "sub/small.txt" {
+		t.Fatalf("damaged %v, want [sub/small.txt]", damaged)
+	}
+}
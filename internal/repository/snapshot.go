@@ -0,0 +1,432 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shah1011/obscure/internal/ignore"
+	"github.com/shah1011/obscure/utils"
+)
+
+// uploadConcurrency is the number of chunks uploaded in parallel
+const uploadConcurrency = 4
+
+// Snapshot is the manifest of one backup version
+type Snapshot struct {
+	Tag       string      `json:"tag"`
+	Version   string      `json:"version"`
+	Source    string      `json:"source"`
+	CreatedAt time.Time   `json:"created_at"`
+	Files     []FileEntry `json:"files"`
+}
+
+// FileEntry describes one file, directory or symlink in a snapshot. Paths
+// are slash-separated and relative to the backup root. A hard link to a
+// file earlier in the snapshot has HardLink set and no chunks.
+type FileEntry struct {
+	Path     string            `json:"path"`
+	Mode     fs.FileMode       `json:"mode"`
+	ModTime  time.Time         `json:"mtime"`
+	Size     int64             `json:"size,omitempty"`
+	Link     string            `json:"link,omitempty"`
+	HardLink string            `json:"hardlink,omitempty"`
+	Uid      int               `json:"uid"`
+	Gid      int               `json:"gid"`
+	Uname    string            `json:"uname,omitempty"`
+	Gname    string            `json:"gname,omitempty"`
+	Xattrs   map[string]string `json:"xattrs,omitempty"`
+	Chunks   []string          `json:"chunks,omitempty"`
+}
+
+// metadata returns what a restore applies to the entry besides its contents
+func (e FileEntry) metadata() utils.Metadata {
+	return utils.Metadata{
+		Mode:    e.Mode,
+		ModTime: e.ModTime,
+		Uid:     e.Uid,
+		Gid:     e.Gid,
+		Uname:   e.Uname,
+		Gname:   e.Gname,
+		Xattrs:  e.Xattrs,
+	}
+}
+
+// Stats summarizes a backup run
+type Stats struct {
+	Files         int
+	Bytes         int64
+	Chunks        int
+	NewChunks     int
+	UploadedBytes int64
+}
+
+// chunkUploader uploads new chunks in the background, with at most
+// uploadConcurrency chunks in flight
+type chunkUploader struct {
+	repo  *Repository
+	ctx   context.Context
+	sem   chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	err   error
+	stats *Stats
+}
+
+func (u *chunkUploader) add(id string, data []byte) error {
+	if err := u.failed(); err != nil {
+		return err
+	}
+	u.stats.Chunks++
+	if u.repo.hasChunk(id) {
+		return nil
+	}
+
+	u.sem <- struct{}{}
+	u.wg.Add(1)
+	data = append([]byte(nil), data...)
+	go func() {
+		defer func() { <-u.sem; u.wg.Done() }()
+		n, err := u.repo.putChunk(u.ctx, id, data)
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if err != nil {
+			u.repo.forgetChunk(id)
+			if u.err == nil {
+				u.err = err
+			}
+			return
+		}
+		u.stats.NewChunks++
+		u.stats.UploadedBytes += n
+	}()
+	return nil
+}
+
+func (u *chunkUploader) failed() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+func (u *chunkUploader) wait() error {
+	u.wg.Wait()
+	return u.err
+}
+
+// Backup chunks every file under source that m admits, uploads the chunks
+// the repository does not have yet and stores the snapshot manifest at
+// SnapshotKey(username, tag, version)
+func (r *Repository) Backup(ctx context.Context, source, username, tag, version string, m *ignore.Matcher) (*Stats, error) {
+	if err := r.loadIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	rootInfo, err := os.Lstat(absSource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	stats := &Stats{}
+	uploader := &chunkUploader{repo: r, ctx: ctx, sem: make(chan struct{}, uploadConcurrency), stats: stats}
+	snapshot := &Snapshot{Tag: tag, Version: version, Source: absSource, CreatedAt: time.Now().UTC()}
+
+	hardLinks := map[string]string{}
+	addFile := func(file, rel string, fi fs.FileInfo) error {
+		if !fi.IsDir() && !fi.Mode().IsRegular() && fi.Mode()&fs.ModeSymlink == 0 {
+			// Devices, sockets and pipes have no content to back up
+			return nil
+		}
+
+		// The tar header carries the ownership and extended attributes
+		header, err := utils.FileHeader(file, fi)
+		if err != nil {
+			return err
+		}
+		entry := FileEntry{
+			Path:    filepath.ToSlash(rel),
+			Mode:    fi.Mode(),
+			ModTime: fi.ModTime().UTC(),
+			Link:    header.Linkname,
+		}
+		meta := utils.HeaderMetadata(header)
+		entry.Uid, entry.Gid, entry.Uname, entry.Gname, entry.Xattrs = meta.Uid, meta.Gid, meta.Uname, meta.Gname, meta.Xattrs
+
+		if id := utils.HardLinkID(fi); id != "" {
+			if first, ok := hardLinks[id]; ok {
+				entry.HardLink = first
+				stats.Files++
+				snapshot.Files = append(snapshot.Files, entry)
+				return nil
+			}
+			hardLinks[id] = entry.Path
+		}
+
+		if fi.Mode().IsRegular() {
+			chunks, size, err := r.chunkFile(file, uploader)
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			entry.Chunks = chunks
+			entry.Size = size
+			stats.Bytes += size
+		}
+
+		stats.Files++
+		snapshot.Files = append(snapshot.Files, entry)
+		return nil
+	}
+
+	if !rootInfo.IsDir() {
+		err = addFile(absSource, filepath.Base(absSource), rootInfo)
+	} else {
+		err = m.Walk(func(file, rel string, fi os.FileInfo) error {
+			return addFile(file, rel, fi)
+		})
+	}
+	if waitErr := uploader.wait(); err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	if err := r.saveSnapshot(ctx, SnapshotKey(username, tag, version), snapshot); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// chunkFile splits a file into chunks and queues the new ones for upload
+func (r *Repository) chunkFile(path string, uploader *chunkUploader) ([]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var ids []string
+	var size int64
+	chunker := NewChunker(f)
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			return ids, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		id := r.ChunkID(data)
+		if err := uploader.add(id, data); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+		size += int64(len(data))
+	}
+}
+
+// snapshotAAD binds a manifest to its tag and version, so one snapshot
+// cannot be swapped for another
+func snapshotAAD(tag, version string) []byte {
+	return []byte("snapshot\x00" + tag + "\x00" + version)
+}
+
+func (r *Repository) saveSnapshot(ctx context.Context, key string, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	sealed, err := r.seal(r.encoder.EncodeAll(data, nil), snapshotAAD(snapshot.Tag, snapshot.Version))
+	if err != nil {
+		return err
+	}
+	metadata := map[string]string{
+		"tag":     snapshot.Tag,
+		"version": snapshot.Version,
+		"format":  SnapshotExtension,
+	}
+	if err := r.backend.Put(ctx, key, bytes.NewReader(sealed), int64(len(sealed)), metadata); err != nil {
+		return fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot downloads and decrypts the manifest of tag/version
+func (r *Repository) LoadSnapshot(ctx context.Context, username, tag, version string) (*Snapshot, error) {
+	reader, _, err := r.backend.Get(ctx, SnapshotKey(username, tag, version))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	sealed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := r.open(sealed, snapshotAAD(tag, version))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	data, err := r.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("corrupt snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// Restore reassembles the files of snapshot under outputDir, resolving
+// existing paths with opts, and returns how many entries were written
+func (r *Repository) Restore(ctx context.Context, snapshot *Snapshot, outputDir string, opts *utils.ExtractOptions) (int, error) {
+	if !opts.DryRun {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return 0, err
+		}
+	}
+
+	restored := 0
+	for _, entry := range snapshot.Files {
+		var linkname string
+		if entry.Mode&fs.ModeSymlink != 0 {
+			linkname = entry.Link
+		}
+		ok, err := opts.Admit(outputDir, entry.Path, linkname, entry.Size)
+		if err != nil {
+			return restored, err
+		}
+		if !ok {
+			continue
+		}
+
+		var linkSource string
+		if entry.HardLink != "" {
+			var ok bool
+			if linkSource, ok = opts.Restored(entry.HardLink); !ok {
+				if opts.OnWarning != nil {
+					opts.OnWarning(entry.Path, fmt.Errorf("hard link to %s, which was not restored", entry.HardLink))
+				}
+				continue
+			}
+		}
+		isDir := entry.Mode.IsDir()
+		target, action, err := opts.Resolve(outputDir, entry.Path, entry.ModTime, isDir)
+		if err != nil {
+			return restored, err
+		}
+		if isDir && action == utils.ActionSkip {
+			continue
+		}
+		if opts.OnEntry != nil {
+			opts.OnEntry(entry.Path, target, action)
+		}
+		if action == utils.ActionSkip {
+			continue
+		}
+		restored++
+		if opts.DryRun {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return restored, err
+		}
+		if action == utils.ActionOverwrite {
+			// Replace the old path rather than writing through it
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return restored, err
+			}
+		}
+
+		switch {
+		case isDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return restored, err
+			}
+			opts.DeferDir(entry.Path, target, entry.metadata())
+			continue
+		case linkSource != "":
+			// Shares its metadata with the file it links to
+			if err := os.Link(linkSource, target); err != nil {
+				return restored, err
+			}
+			continue
+		case entry.Mode&fs.ModeSymlink != 0:
+			if err := os.Symlink(entry.Link, target); err != nil {
+				return restored, err
+			}
+		default:
+			if err := r.restoreFile(ctx, entry, target); err != nil {
+				return restored, fmt.Errorf("%s: %w", entry.Path, err)
+			}
+		}
+		opts.ApplyMetadata(entry.Path, target, entry.metadata())
+	}
+
+	// Directories last, since creating their contents updates their times
+	opts.Finish()
+	return restored, nil
+}
+
+func (r *Repository) restoreFile(ctx context.Context, entry FileEntry, target string) error {
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	for _, id := range entry.Chunks {
+		data, err := r.getChunk(ctx, id)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// Verify downloads every chunk referenced by snapshot and checks that it
+// decrypts and matches its ID. It returns the paths of damaged files.
+func (r *Repository) Verify(ctx context.Context, snapshot *Snapshot) (int, []string, error) {
+	checked := map[string]error{}
+	verified := 0
+	var damaged []string
+	for _, entry := range snapshot.Files {
+		ok := true
+		for _, id := range entry.Chunks {
+			err, seen := checked[id]
+			if !seen {
+				_, err = r.getChunk(ctx, id)
+				if err != nil && ctx.Err() != nil {
+					return verified, damaged, ctx.Err()
+				}
+				checked[id] = err
+			}
+			if err != nil {
+				ok = false
+			}
+		}
+		if ok {
+			verified++
+		} else {
+			damaged = append(damaged, entry.Path)
+		}
+	}
+	return verified, damaged, nil
+}
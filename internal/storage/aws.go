@@ -2,13 +2,21 @@ package storage
 
 import (
 	"context"
+	"io"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	cfg "github.com/shah1011/obscure/internal/config"
 )
 
+func init() {
+	RegisterBackend("s3", "Amazon S3", func(ctx context.Context, provider string) (Backend, error) {
+		return NewS3Client(ctx, provider)
+	})
+}
+
 func NewAWSClient(ctx context.Context, provider string) (*aws.Config, error) {
 	// Get provider configuration
 	providerConfig, err := cfg.GetProviderConfig(provider)
@@ -34,3 +42,67 @@ func NewAWSClient(ctx context.Context, provider string) (*aws.Config, error) {
 
 	return &awsCfg, nil
 }
+
+// S3Client wraps the S3 client for Amazon S3
+type S3Client struct {
+	client *s3.Client
+	bucket string
+}
+
+// NewS3Client creates a new Amazon S3 client using AWS SDK v2
+func NewS3Client(ctx context.Context, provider string) (*S3Client, error) {
+	providerConfig, err := cfg.GetProviderConfig(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	awsCfg, err := NewAWSClient(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Client{
+		client: s3.NewFromConfig(*awsCfg),
+		bucket: providerConfig.Bucket,
+	}, nil
+}
+
+// Put uploads an object to S3
+func (s *S3Client) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	return s3PutObject(ctx, s.client, s.bucket, key, reader, size, metadata)
+}
+
+// PutMultipart uploads an object to S3 in parts
+func (s *S3Client) PutMultipart(ctx context.Context, key string, reader io.Reader, metadata map[string]string, opts UploadOptions) error {
+	return uploadMultipart(ctx, s3Multipart{s.client, s.bucket}, key, reader, metadata, opts)
+}
+
+// ListMultipartUploads lists unfinished uploads in S3 with a prefix
+func (s *S3Client) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	return s3Multipart{s.client, s.bucket}.listMultipartUploads(ctx, prefix)
+}
+
+// AbortMultipartUpload discards an unfinished upload and its parts
+func (s *S3Client) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return s3Multipart{s.client, s.bucket}.abortMultipartUpload(ctx, key, uploadID)
+}
+
+// Get downloads an object from S3
+func (s *S3Client) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	return s3GetObject(ctx, s.client, s.bucket, key)
+}
+
+// Stat returns an object's attributes from S3
+func (s *S3Client) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	return s3StatObject(ctx, s.client, s.bucket, key)
+}
+
+// List lists objects in S3 with a prefix
+func (s *S3Client) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	return s3ListObjects(ctx, s.client, s.bucket, prefix)
+}
+
+// Delete deletes an object from S3
+func (s *S3Client) Delete(ctx context.Context, key string) error {
+	return s3DeleteObject(ctx, s.client, s.bucket, key)
+}
@@ -8,6 +8,12 @@ import (
 	cfg "github.com/shah1011/obscure/internal/config"
 )
 
+func init() {
+	RegisterBackend("b2", "Backblaze B2", func(ctx context.Context, provider string) (Backend, error) {
+		return NewB2Client(ctx, provider)
+	})
+}
+
 // B2Client wraps the B2 client for easier use
 type B2Client struct {
 	client *b2.Client
@@ -44,7 +50,7 @@ func NewB2Client(ctx context.Context, provider string) (*B2Client, error) {
 func (b *B2Client) UploadFile(ctx context.Context, key string, reader io.Reader, metadata map[string]string) error {
 	// Create object writer
 	obj := b.bucket.Object(key)
-	writer := obj.NewWriter(ctx)
+	writer := obj.NewWriter(ctx).WithAttrs(&b2.Attrs{Info: metadata})
 
 	// Copy data
 	if _, err := io.Copy(writer, reader); err != nil {
@@ -63,14 +69,14 @@ func (b *B2Client) FileExists(ctx context.Context, key string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	
+
 	// Check if the exact key exists in the list
 	for _, file := range files {
 		if file == key {
 			return true, nil
 		}
 	}
-	
+
 	return false, nil
 }
 
@@ -98,16 +104,8 @@ func (b *B2Client) GetFileMetadata(ctx context.Context, key string) (map[string]
 		return nil, err
 	}
 
-	// Convert B2 custom headers to metadata
-	metadata := make(map[string]string)
-	for k, v := range attrs.Info {
-		// Remove "X-Bz-Info-" prefix
-		if len(k) > 11 && k[:11] == "X-Bz-Info-" {
-			metadata[k[11:]] = v
-		}
-	}
-
-	return metadata, nil
+	// The SDK already strips the "X-Bz-Info-" prefix from custom headers
+	return attrs.Info, nil
 }
 
 // DeleteFile deletes a file from B2
@@ -115,3 +113,82 @@ func (b *B2Client) DeleteFile(ctx context.Context, key string) error {
 	obj := b.bucket.Object(key)
 	return obj.Delete(ctx)
 }
+
+// Put uploads an object to B2
+func (b *B2Client) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	return b.UploadFile(ctx, key, reader, metadata)
+}
+
+// Get downloads an object from B2
+func (b *B2Client) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.bucket.Object(key).NewReader(ctx), info, nil
+}
+
+// Stat returns an object's attributes from B2. Like FileExists it goes
+// through a listing, since Attrs on an unlisted object can fail with 416.
+func (b *B2Client) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	iter := b.bucket.List(ctx, b2.ListPrefix(key))
+	for iter.Next() {
+		obj := iter.Object()
+		if obj.Name() != key {
+			continue
+		}
+		return b2ObjectInfo(ctx, obj)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrNotFound
+}
+
+// List lists objects in B2 with a prefix
+func (b *B2Client) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	iter := b.bucket.List(ctx, b2.ListPrefix(prefix))
+	for iter.Next() {
+		info, err := b2ObjectInfo(ctx, iter.Object())
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, *info)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// Delete deletes an object from B2
+func (b *B2Client) Delete(ctx context.Context, key string) error {
+	err := b.DeleteFile(ctx, key)
+	if b2.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// b2ObjectInfo converts a listed object; listed objects carry their
+// attributes, so this does not cost another request
+func b2ObjectInfo(ctx context.Context, obj *b2.Object) (*ObjectInfo, error) {
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modTime := attrs.LastModified
+	if modTime.IsZero() {
+		modTime = attrs.UploadTimestamp
+	}
+	return &ObjectInfo{
+		Key:      obj.Name(),
+		Size:     attrs.Size,
+		ModTime:  modTime,
+		Metadata: attrs.Info,
+	}, nil
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ErrNotFound is returned by a Backend when the requested object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key      string
+	Size     int64
+	ModTime  time.Time
+	Metadata map[string]string
+}
+
+// Backend is the common interface implemented by every storage client.
+// Keys are full object keys (e.g. "backups/<user>/<tag>/<file>").
+type Backend interface {
+	// Put uploads the reader to key. size may be -1 when the length is unknown.
+	Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error
+	// Get opens a streaming reader for key. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Stat returns the object's size, modification time and metadata.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns all objects whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes the object at key.
+	Delete(ctx context.Context, key string) error
+}
+
+// BackendFactory creates a Backend for the named provider configuration
+type BackendFactory func(ctx context.Context, provider string) (Backend, error)
+
+type registration struct {
+	displayName string
+	factory     BackendFactory
+}
+
+var backends = map[string]registration{}
+
+// RegisterBackend makes a provider available under name. It is called from
+// the init function of each provider file.
+func RegisterBackend(name, displayName string, factory BackendFactory) {
+	if _, exists := backends[name]; exists {
+		panic("storage: backend registered twice: " + name)
+	}
+	backends[name] = registration{displayName: displayName, factory: factory}
+}
+
+// OpenBackend creates the Backend registered for provider
+func OpenBackend(ctx context.Context, provider string) (Backend, error) {
+	reg, ok := backends[provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	}
+	return reg.factory(ctx, provider)
+}
+
+// IsRegistered reports whether a backend exists for provider
+func IsRegistered(provider string) bool {
+	_, ok := backends[provider]
+	return ok
+}
+
+// RegisteredBackends returns the names of all registered providers, sorted
+func RegisteredBackends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DisplayName returns the user-friendly name for provider
+func DisplayName(provider string) string {
+	if reg, ok := backends[provider]; ok && reg.displayName != "" {
+		return reg.displayName
+	}
+	return provider
+}
+
+// Exists reports whether key is present in the backend
+func Exists(ctx context.Context, b Backend, key string) (bool, error) {
+	_, err := b.Stat(ctx, key)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return false, err
+}
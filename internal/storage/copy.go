@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// CopyObject streams key from src to dst with its metadata. The bytes are
+// copied as they are, so encrypted backups are never decrypted on the way.
+// It returns the number of bytes copied.
+func CopyObject(ctx context.Context, src, dst Backend, key string) (int64, error) {
+	reader, info, err := src.Get(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	if err := dst.Put(ctx, key, reader, info.Size, info.Metadata); err != nil {
+		return 0, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	copied, err := dst.Stat(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check %s after copying: %w", key, err)
+	}
+	if copied.Size != info.Size {
+		return 0, fmt.Errorf("%s is %d bytes after copying, expected %d", key, copied.Size, info.Size)
+	}
+	return info.Size, nil
+}
+
+// ObjectHash downloads key and returns the SHA-256 of its content. Providers
+// hash objects in incompatible ways, multipart uploads especially, so this
+// is the only checksum that can be compared across them.
+func ObjectHash(ctx context.Context, b Backend, key string) (string, error) {
+	reader, _, err := b.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -2,49 +2,65 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
 	"cloud.google.com/go/storage"
 	cfg "github.com/shah1011/obscure/internal/config"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
+func init() {
+	RegisterBackend("gcs", "Google Cloud Storage", func(ctx context.Context, provider string) (Backend, error) {
+		return NewGCSClient(ctx, provider)
+	})
+}
+
+// GCSClient wraps the GCS client and the configured bucket
+type GCSClient struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
 // findGCSServiceAccount tries multiple locations to find the GCS service account file
 func findGCSServiceAccount(configuredPath string) string {
 	// List of paths to try in order
 	var pathsToTry []string
-	
+
 	// 1. User-configured path (if provided)
 	if configuredPath != "" {
 		pathsToTry = append(pathsToTry, configuredPath)
 	}
-	
+
 	// 2. Standard location in user's home directory
 	if homeDir, err := os.UserHomeDir(); err == nil {
 		pathsToTry = append(pathsToTry, filepath.Join(homeDir, ".obscure", "gcs-service-account.json"))
 	}
-	
+
 	// 3. Current working directory
 	pathsToTry = append(pathsToTry, "./gcs-service-account.json")
-	
+
 	// 4. Environment variable GOOGLE_APPLICATION_CREDENTIALS
 	if envPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); envPath != "" {
 		pathsToTry = append(pathsToTry, envPath)
 	}
-	
+
 	// Try each path and return the first one that exists
 	for _, path := range pathsToTry {
 		if _, err := os.Stat(path); err == nil {
 			return path
 		}
 	}
-	
+
 	return "" // No valid path found
 }
 
-func NewGCSClient(ctx context.Context, provider string) (*storage.Client, error) {
+// NewGCSClient creates a new GCS client using the configured service account
+func NewGCSClient(ctx context.Context, provider string) (*GCSClient, error) {
 	// Get provider configuration
 	providerConfig, err := cfg.GetProviderConfig(provider)
 	if err != nil {
@@ -54,11 +70,11 @@ func NewGCSClient(ctx context.Context, provider string) (*storage.Client, error)
 	// Try multiple locations for service account file
 	serviceAccountPath := findGCSServiceAccount(providerConfig.ServiceAccount)
 	if serviceAccountPath == "" {
-		return nil, fmt.Errorf("GCS service account file not found. Please place your service account JSON file in one of these locations:\n" +
-			"1. %s (as configured)\n" +
-			"2. ~/.obscure/gcs-service-account.json\n" +
-			"3. ./gcs-service-account.json\n" +
-			"4. Set GOOGLE_APPLICATION_CREDENTIALS environment variable", 
+		return nil, fmt.Errorf("GCS service account file not found. Please place your service account JSON file in one of these locations:\n"+
+			"1. %s (as configured)\n"+
+			"2. ~/.obscure/gcs-service-account.json\n"+
+			"3. ./gcs-service-account.json\n"+
+			"4. Set GOOGLE_APPLICATION_CREDENTIALS environment variable",
 			providerConfig.ServiceAccount)
 	}
 
@@ -70,5 +86,84 @@ func NewGCSClient(ctx context.Context, provider string) (*storage.Client, error)
 		return nil, fmt.Errorf("failed to create GCS client with service account %s: %w", serviceAccountPath, err)
 	}
 
-	return client, nil
+	return &GCSClient{
+		client: client,
+		bucket: client.Bucket(providerConfig.Bucket),
+	}, nil
+}
+
+// Close closes the underlying GCS client
+func (g *GCSClient) Close() error {
+	return g.client.Close()
+}
+
+// Put uploads an object to GCS
+func (g *GCSClient) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	writer := g.bucket.Object(key).NewWriter(ctx)
+	writer.Metadata = metadata
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// Get downloads an object from GCS
+func (g *GCSClient) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := g.Stat(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := g.bucket.Object(key).NewReader(ctx)
+	if err != nil {
+		return nil, nil, wrapGCSNotFound(err)
+	}
+	return reader, info, nil
+}
+
+// Stat returns an object's attributes from GCS
+func (g *GCSClient) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	attrs, err := g.bucket.Object(key).Attrs(ctx)
+	if err != nil {
+		return nil, wrapGCSNotFound(err)
+	}
+	return gcsObjectInfo(attrs), nil
+}
+
+// List lists objects in GCS with a prefix
+func (g *GCSClient) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	it := g.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, *gcsObjectInfo(attrs))
+	}
+	return objects, nil
+}
+
+// Delete deletes an object from GCS
+func (g *GCSClient) Delete(ctx context.Context, key string) error {
+	return wrapGCSNotFound(g.bucket.Object(key).Delete(ctx))
+}
+
+func gcsObjectInfo(attrs *storage.ObjectAttrs) *ObjectInfo {
+	return &ObjectInfo{
+		Key:      attrs.Name,
+		Size:     attrs.Size,
+		ModTime:  attrs.Updated,
+		Metadata: attrs.Metadata,
+	}
+}
+
+func wrapGCSNotFound(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return ErrNotFound
+	}
+	return err
 }
@@ -12,6 +12,12 @@ import (
 	cfg "github.com/shah1011/obscure/internal/config"
 )
 
+func init() {
+	RegisterBackend("idrive", "IDrive E2", func(ctx context.Context, provider string) (Backend, error) {
+		return NewIDriveClient(ctx, provider)
+	})
+}
+
 // IDriveClient wraps the S3 client configured for IDrive E2
 type IDriveClient struct {
 	client *s3.Client
@@ -135,3 +141,43 @@ func (i *IDriveClient) DownloadFile(ctx context.Context, key string) (io.ReadClo
 	}
 	return resp.Body, nil
 }
+
+// Put uploads an object to IDrive E2
+func (i *IDriveClient) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	return s3PutObject(ctx, i.client, i.bucket, key, reader, size, metadata)
+}
+
+// PutMultipart uploads an object to IDrive E2 in parts
+func (i *IDriveClient) PutMultipart(ctx context.Context, key string, reader io.Reader, metadata map[string]string, opts UploadOptions) error {
+	return uploadMultipart(ctx, s3Multipart{i.client, i.bucket}, key, reader, metadata, opts)
+}
+
+// ListMultipartUploads lists unfinished uploads in IDrive E2 with a prefix
+func (i *IDriveClient) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	return s3Multipart{i.client, i.bucket}.listMultipartUploads(ctx, prefix)
+}
+
+// AbortMultipartUpload discards an unfinished upload and its parts
+func (i *IDriveClient) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return s3Multipart{i.client, i.bucket}.abortMultipartUpload(ctx, key, uploadID)
+}
+
+// Get downloads an object from IDrive E2
+func (i *IDriveClient) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	return s3GetObject(ctx, i.client, i.bucket, key)
+}
+
+// Stat returns an object's attributes from IDrive E2
+func (i *IDriveClient) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	return s3StatObject(ctx, i.client, i.bucket, key)
+}
+
+// List lists objects in IDrive E2 with a prefix
+func (i *IDriveClient) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	return s3ListObjects(ctx, i.client, i.bucket, prefix)
+}
+
+// Delete deletes an object from IDrive E2
+func (i *IDriveClient) Delete(ctx context.Context, key string) error {
+	return s3DeleteObject(ctx, i.client, i.bucket, key)
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shah1011/obscure/internal/ignore"
+	"github.com/shah1011/obscure/utils"
+)
+
+// UploadJournal is the local record of an in-progress multipart upload,
+// stored under ~/.obscure/uploads/. It holds everything needed to rebuild
+// the same byte stream and continue where the upload stopped.
+type UploadJournal struct {
+	Provider    string        `json:"provider"`
+	Key         string        `json:"key"`
+	UploadID    string        `json:"upload_id"`
+	Tag         string        `json:"tag"`
+	Version     string        `json:"version"`
+	SourcePath  string        `json:"source_path"`
+	Sources     []string      `json:"sources,omitempty"`
+	IsDirect    bool          `json:"is_direct"`
+	Incremental bool          `json:"incremental,omitempty"`
+	Parent      string        `json:"parent,omitempty"`
+	Filter      *ignore.Rules `json:"filter,omitempty"`
+	PartSize    int64         `json:"part_size"`
+	Salt        string        `json:"salt,omitempty"`
+	NoncePrefix string        `json:"nonce_prefix,omitempty"`
+	// KDF is missing from journals written while scrypt was the default
+	KDF *utils.KDFParams `json:"kdf,omitempty"`
+	// KeySlots are the key of a backup sealed to a keyring, opened again
+	// with the keyring to resume. Backups encrypted to public keys are
+	// never journaled.
+	KeySlots string `json:"key_slots,omitempty"`
+	// MasterKey is only found in journals of earlier versions, which kept
+	// the backup key in plaintext
+	MasterKey string          `json:"master_key,omitempty"`
+	Parts     []CompletedPart `json:"parts"`
+	// Sent are parts handed to the provider but not acknowledged yet
+	Sent      []CompletedPart `json:"sent,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Paths returns the source paths of the upload
+func (j *UploadJournal) Paths() []string {
+	if len(j.Sources) > 0 {
+		return j.Sources
+	}
+	return []string{j.SourcePath}
+}
+
+func getJournalDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".obscure", "uploads")
+}
+
+func journalPath(provider, key string) string {
+	sum := sha256.Sum256([]byte(provider + "\x00" + key))
+	return filepath.Join(getJournalDir(), hex.EncodeToString(sum[:16])+".json")
+}
+
+// LoadJournal returns the journal for key in provider, or nil if there is none
+func LoadJournal(provider, key string) (*UploadJournal, error) {
+	return readJournal(journalPath(provider, key))
+}
+
+func readJournal(path string) (*UploadJournal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var journal UploadJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, err
+	}
+	return &journal, nil
+}
+
+// ListJournals returns the journals of provider, oldest first. An empty
+// provider returns the journals of every provider.
+func ListJournals(provider string) ([]*UploadJournal, error) {
+	entries, err := os.ReadDir(getJournalDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var journals []*UploadJournal
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		journal, err := readJournal(filepath.Join(getJournalDir(), entry.Name()))
+		if err != nil || journal == nil {
+			continue
+		}
+		if provider != "" && journal.Provider != provider {
+			continue
+		}
+		journals = append(journals, journal)
+	}
+
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].CreatedAt.Before(journals[j].CreatedAt)
+	})
+	return journals, nil
+}
+
+// Save writes the journal, replacing the previous copy atomically so that a
+// crash mid-write never leaves a truncated file
+func (j *UploadJournal) Save() error {
+	j.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := journalPath(j.Provider, j.Key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// HoldsKey reports whether the journal keeps a backup key in plaintext, as
+// earlier versions did. Such an upload is not resumed.
+func (j *UploadJournal) HoldsKey() bool {
+	return j.MasterKey != ""
+}
+
+// Remove deletes the journal file
+func (j *UploadJournal) Remove() error {
+	err := os.Remove(journalPath(j.Provider, j.Key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// UploadedBytes returns the total size of the acknowledged parts
+func (j *UploadJournal) UploadedBytes() int64 {
+	var total int64
+	for _, part := range j.Parts {
+		total += part.Size
+	}
+	return total
+}
+
+// Options returns upload options that resume from the journal and keep it
+// up to date as parts complete
+func (j *UploadJournal) Options(concurrency int) UploadOptions {
+	return UploadOptions{
+		PartSize:    j.PartSize,
+		Concurrency: concurrency,
+		UploadID:    j.UploadID,
+		Completed:   append([]CompletedPart(nil), j.Parts...),
+		Sent:        append([]CompletedPart(nil), j.Sent...),
+		OnCreate: func(uploadID string) error {
+			j.UploadID = uploadID
+			return j.Save()
+		},
+		OnSend: func(part CompletedPart) error {
+			for _, prev := range j.Sent {
+				if prev.Number == part.Number {
+					return nil
+				}
+			}
+			j.Sent = append(j.Sent, part)
+			return j.Save()
+		},
+		OnPart: func(part CompletedPart) error {
+			j.Parts = append(j.Parts, part)
+			for i, prev := range j.Sent {
+				if prev.Number == part.Number {
+					j.Sent = append(j.Sent[:i], j.Sent[i+1:]...)
+					break
+				}
+			}
+			return j.Save()
+		},
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	journal := &UploadJournal{Provider: "aws", Key: "backups/alice/tag/1_tag.obscure", Tag: "tag", Version: "1", PartSize: MinPartSize}
+	if err := journal.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadJournal("aws", journal.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil || loaded.Key != journal.Key || loaded.PartSize != MinPartSize {
+		t.Fatalf("loaded %+v, want %+v", loaded, journal)
+	}
+
+	other := &UploadJournal{Provider: "b2", Key: journal.Key}
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+	journals, err := ListJournals("aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journals) != 1 || journals[0].Provider != "aws" {
+		t.Fatalf("listed %d journals for aws, want 1", len(journals))
+	}
+	if journals, _ := ListJournals(""); len(journals) != 2 {
+		t.Fatalf("listed %d journals, want 2", len(journals))
+	}
+}
+
+func TestJournalRemove(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	journal := &UploadJournal{Provider: "aws", Key: "key"}
+	if err := journal.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadJournal("aws", "key"); err != nil || loaded != nil {
+		t.Fatalf("journal still loads after Remove: %v, %v", loaded, err)
+	}
+	// Removing twice is not an error
+	if err := journal.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if journals, _ := ListJournals(""); len(journals) != 0 {
+		t.Fatalf("listed %d journals after Remove, want 0", len(journals))
+	}
+}
+
+func TestJournalTracksUpload(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	api := newFakeAPI()
+	api.failPart = 2
+	data := randomBytes(t, 2*MinPartSize+10)
+	journal := &UploadJournal{Provider: "aws", Key: "key", PartSize: MinPartSize}
+	if err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, journal.Options(1)); err == nil {
+		t.Fatal("the interrupted upload succeeded")
+	}
+
+	saved, err := LoadJournal("aws", "key")
+	if err != nil || saved == nil {
+		t.Fatalf("no journal was saved: %v", err)
+	}
+	if saved.UploadID == "" {
+		t.Fatal("the journal has no upload ID")
+	}
+	if len(saved.Parts) != 1 || saved.Parts[0] != partOf(data, 1) {
+		t.Fatalf("journal parts %+v, want part 1", saved.Parts)
+	}
+	// Part 2 was handed to the provider but never acknowledged
+	if len(saved.Sent) != 1 || saved.Sent[0].Number != 2 {
+		t.Fatalf("journal sent parts %+v, want part 2", saved.Sent)
+	}
+
+	api.failPart = 0
+	if err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, saved.Options(1)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(api.objects["key"], data) {
+		t.Fatal("the resumed object differs from what was uploaded")
+	}
+	if len(saved.Parts) != 3 || len(saved.Sent) != 0 {
+		t.Fatalf("journal has %d parts and %d sent after completing, want 3 and 0", len(saved.Parts), len(saved.Sent))
+	}
+	if saved.UploadedBytes() != int64(len(data)) {
+		t.Fatalf("UploadedBytes = %d, want %d", saved.UploadedBytes(), len(data))
+	}
+}
@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPartSize is the size of each multipart upload part. S3 allows
+	// at most 10,000 parts, so this caps a single backup at about 156 GiB.
+	DefaultPartSize = 16 * 1024 * 1024
+	// DefaultUploadConcurrency is the number of parts uploaded in parallel.
+	// Memory use is bounded by PartSize * (Concurrency + 1).
+	DefaultUploadConcurrency = 4
+
+	// MinPartSize is the smallest part S3 accepts (except for the last one)
+	MinPartSize = 5 * 1024 * 1024
+	// MaxPartSize is the largest part S3 accepts
+	MaxPartSize = 5 * 1024 * 1024 * 1024
+
+	maxParts = 10000
+)
+
+// ErrResumeMismatch is returned when the data replayed for an already
+// uploaded part differs from what was uploaded, e.g. because the source
+// files or the password changed since the interrupted run
+var ErrResumeMismatch = errors.New("data does not match the interrupted upload")
+
+// CompletedPart records a part that the provider has acknowledged
+type CompletedPart struct {
+	Number int32  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// MultipartUpload is an upload that was started but never completed or aborted
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// UploadOptions tunes multipart uploads and lets callers persist progress
+type UploadOptions struct {
+	PartSize    int64
+	Concurrency int
+
+	// UploadID and Completed resume an earlier upload. Completed parts are
+	// still read from the stream and checked against their SHA256, but not
+	// sent again.
+	UploadID  string
+	Completed []CompletedPart
+	// Sent are parts that may have reached the provider without being
+	// acknowledged. They are sent again only if the replayed data is
+	// identical: encrypted data that differs would reuse nonces.
+	Sent []CompletedPart
+
+	// OnCreate is called once the provider assigns an upload ID, OnSend
+	// before a part is sent and OnPart after it is acknowledged. When any
+	// is set the upload is left in place on failure so that it can be
+	// resumed.
+	OnCreate func(uploadID string) error
+	OnSend   func(part CompletedPart) error
+	OnPart   func(part CompletedPart) error
+}
+
+// MultipartBackend is implemented by the S3-family backends, which can
+// upload in parts, resume an interrupted upload and clean up stale ones
+type MultipartBackend interface {
+	Backend
+	PutMultipart(ctx context.Context, key string, reader io.Reader, metadata map[string]string, opts UploadOptions) error
+	ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error)
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+var (
+	_ MultipartBackend = (*S3Client)(nil)
+	_ MultipartBackend = (*IDriveClient)(nil)
+	_ MultipartBackend = (*S3CompatibleClient)(nil)
+	_ MultipartBackend = (*StorjClient)(nil)
+)
+
+// multipartAPI is the set of S3 multipart calls, implemented once for the
+// AWS SDK v2 clients and once for the SDK v1 Storj client
+type multipartAPI interface {
+	putObject(ctx context.Context, key string, body []byte, metadata map[string]string) error
+	createMultipartUpload(ctx context.Context, key string, metadata map[string]string) (string, error)
+	uploadPart(ctx context.Context, key, uploadID string, number int32, body []byte) (string, error)
+	completeMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	abortMultipartUpload(ctx context.Context, key, uploadID string) error
+	listMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error)
+}
+
+// withDefaults fills in zero values and checks the provider limits
+func (o UploadOptions) withDefaults() (UploadOptions, error) {
+	if o.PartSize == 0 {
+		o.PartSize = DefaultPartSize
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = DefaultUploadConcurrency
+	}
+	if o.PartSize < MinPartSize || o.PartSize > MaxPartSize {
+		return o, fmt.Errorf("part size must be between %d MiB and %d GiB", MinPartSize>>20, MaxPartSize>>30)
+	}
+	if o.Concurrency < 1 {
+		return o, fmt.Errorf("upload parallelism must be at least 1")
+	}
+	return o, nil
+}
+
+// uploadMultipart streams reader to key without knowing its length up
+// front. Objects smaller than one part go up with a single PutObject; larger
+// ones are split into parts uploaded concurrently. Reading stops while all
+// part buffers are in flight, so a slow upload applies backpressure to the
+// producer. On failure the multipart upload is aborted unless the caller is
+// journaling it.
+func uploadMultipart(ctx context.Context, api multipartAPI, key string, reader io.Reader, metadata map[string]string, opts UploadOptions) error {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return err
+	}
+	journaled := opts.OnCreate != nil || opts.OnSend != nil || opts.OnPart != nil
+
+	done := make(map[int32]CompletedPart, len(opts.Completed))
+	for _, part := range opts.Completed {
+		done[part.Number] = part
+	}
+	sent := make(map[int32]CompletedPart, len(opts.Sent))
+	for _, part := range opts.Sent {
+		if _, ok := done[part.Number]; !ok {
+			sent[part.Number] = part
+		}
+	}
+
+	first := make([]byte, opts.PartSize)
+	n, err := io.ReadFull(reader, first)
+	if (err == io.EOF || err == io.ErrUnexpectedEOF) && opts.UploadID == "" {
+		return api.putObject(ctx, key, first[:n], metadata)
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	uploadID := opts.UploadID
+	if uploadID == "" {
+		uploadID, err = api.createMultipartUpload(ctx, key, metadata)
+		if err != nil {
+			return err
+		}
+		if opts.OnCreate != nil {
+			if err := opts.OnCreate(uploadID); err != nil {
+				api.abortMultipartUpload(context.Background(), key, uploadID)
+				return err
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		number int32
+		data   []byte
+		sum    string
+	}
+
+	var (
+		mu       sync.Mutex
+		parts    []CompletedPart
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+
+	// Buffers circulate between the reader loop and the workers; together
+	// with first there are Concurrency+1 of them
+	pool := make(chan []byte, opts.Concurrency+1)
+	for i := 0; i < opts.Concurrency; i++ {
+		pool <- make([]byte, opts.PartSize)
+	}
+
+	jobs := make(chan job)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				var err error
+				if opts.OnSend != nil {
+					mu.Lock()
+					err = opts.OnSend(CompletedPart{Number: j.number, Size: int64(len(j.data)), SHA256: j.sum})
+					mu.Unlock()
+				}
+				var etag string
+				if err == nil {
+					etag, err = api.uploadPart(ctx, key, uploadID, j.number, j.data)
+				}
+				if err != nil {
+					fail(fmt.Errorf("failed to upload part %d: %w", j.number, err))
+				} else {
+					part := CompletedPart{Number: j.number, ETag: etag, Size: int64(len(j.data)), SHA256: j.sum}
+					mu.Lock()
+					parts = append(parts, part)
+					if opts.OnPart != nil {
+						err = opts.OnPart(part)
+					}
+					mu.Unlock()
+					if err != nil {
+						fail(err)
+					}
+				}
+				pool <- j.data[:cap(j.data)]
+			}
+		}()
+	}
+
+	// dispatch skips a part that was already uploaded, after checking that
+	// the replayed data is identical, or hands it to a worker. A part that
+	// may have been sent before must be identical too.
+	replayed := 0
+	dispatch := func(number int32, data []byte) bool {
+		sum := sha256.Sum256(data)
+		digest := hex.EncodeToString(sum[:])
+		if prev, ok := sent[number]; ok {
+			if prev.SHA256 != digest || prev.Size != int64(len(data)) {
+				fail(fmt.Errorf("part %d: %w", number, ErrResumeMismatch))
+				return false
+			}
+			replayed++
+		}
+		if prev, ok := done[number]; ok {
+			if prev.SHA256 != digest || prev.Size != int64(len(data)) {
+				fail(fmt.Errorf("part %d: %w", number, ErrResumeMismatch))
+				return false
+			}
+			mu.Lock()
+			parts = append(parts, prev)
+			mu.Unlock()
+			pool <- data[:cap(data)]
+			return true
+		}
+		select {
+		case jobs <- job{number: number, data: data, sum: digest}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	current := first[:n]
+	number := int32(1)
+	for {
+		if !dispatch(number, current) {
+			break
+		}
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			break
+		}
+		if number == maxParts {
+			fail(fmt.Errorf("backup exceeds the maximum of %d parts of %d bytes; use a larger part size", maxParts, opts.PartSize))
+			break
+		}
+
+		var buf []byte
+		select {
+		case buf = <-pool:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		n, err = io.ReadFull(reader, buf)
+		if err == io.EOF {
+			// The previous part was the last one
+			pool <- buf
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			fail(err)
+			break
+		}
+		current = buf[:n]
+		number++
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr == nil && (len(parts) < len(done) || replayed < len(sent)) {
+		// The stream ended before all previously sent parts were replayed
+		firstErr = ErrResumeMismatch
+	}
+	if firstErr != nil {
+		if journaled {
+			return firstErr
+		}
+		// Use a fresh context: ctx is already cancelled
+		if abortErr := api.abortMultipartUpload(context.Background(), key, uploadID); abortErr != nil {
+			return errors.Join(firstErr, fmt.Errorf("failed to abort multipart upload: %w", abortErr))
+		}
+		return firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return api.completeMultipartUpload(ctx, key, uploadID, parts)
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// fakeAPI keeps multipart uploads in memory
+type fakeAPI struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	parts    map[string]map[int32][]byte
+	uploaded []int32
+	aborted  []string
+	failPart int32
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{objects: make(map[string][]byte), parts: make(map[string]map[int32][]byte)}
+}
+
+func (f *fakeAPI) putObject(ctx context.Context, key string, body []byte, metadata map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = append([]byte(nil), body...)
+	return nil
+}
+
+func (f *fakeAPI) createMultipartUpload(ctx context.Context, key string, metadata map[string]string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	uploadID := fmt.Sprintf("upload-%d", len(f.parts)+1)
+	f.parts[uploadID] = make(map[int32][]byte)
+	return uploadID, nil
+}
+
+func (f *fakeAPI) uploadPart(ctx context.Context, key, uploadID string, number int32, body []byte) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if number == f.failPart {
+		return "", errors.New("connection reset")
+	}
+	f.parts[uploadID][number] = append([]byte(nil), body...)
+	f.uploaded = append(f.uploaded, number)
+	return fmt.Sprintf("etag-%d", number), nil
+}
+
+func (f *fakeAPI) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var body []byte
+	for i, part := range parts {
+		if part.Number != int32(i+1) {
+			return fmt.Errorf("part %d listed as number %d", i+1, part.Number)
+		}
+		data, ok := f.parts[uploadID][part.Number]
+		if !ok {
+			return fmt.Errorf("part %d was never uploaded", part.Number)
+		}
+		body = append(body, data...)
+	}
+	f.objects[key] = body
+	delete(f.parts, uploadID)
+	return nil
+}
+
+func (f *fakeAPI) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aborted = append(f.aborted, uploadID)
+	delete(f.parts, uploadID)
+	return nil
+}
+
+func (f *fakeAPI) listMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	return nil, nil
+}
+
+// sortedUploads returns the numbers of the parts sent to the provider
+func (f *fakeAPI) sortedUploads() []int32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	numbers := append([]int32(nil), f.uploaded...)
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func partOf(data []byte, number int32) CompletedPart {
+	start := int64(number-1) * MinPartSize
+	end := min(start+MinPartSize, int64(len(data)))
+	sum := sha256.Sum256(data[start:end])
+	return CompletedPart{Number: number, ETag: fmt.Sprintf("etag-%d", number), Size: end - start, SHA256: hex.EncodeToString(sum[:])}
+}
+
+// interrupt uploads data until part failPart fails, and returns the upload
+// ID and the parts the provider acknowledged
+func interrupt(t *testing.T, api *fakeAPI, data []byte, failPart int32) (string, []CompletedPart) {
+	t.Helper()
+	api.failPart = failPart
+	defer func() { api.failPart = 0 }()
+
+	var uploadID string
+	var parts []CompletedPart
+	opts := UploadOptions{
+		PartSize:    MinPartSize,
+		Concurrency: 1,
+		OnCreate:    func(id string) error { uploadID = id; return nil },
+		OnPart:      func(part CompletedPart) error { parts = append(parts, part); return nil },
+	}
+	err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, opts)
+	if err == nil {
+		t.Fatal("the interrupted upload succeeded")
+	}
+	if len(api.aborted) != 0 {
+		t.Fatal("a journaled upload was aborted")
+	}
+	return uploadID, parts
+}
+
+func TestUploadMultipartSmallObject(t *testing.T) {
+	api := newFakeAPI()
+	data := randomBytes(t, 1000)
+	if err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, UploadOptions{PartSize: MinPartSize}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(api.objects["key"], data) {
+		t.Fatal("the object differs from what was uploaded")
+	}
+	if len(api.uploaded) != 0 {
+		t.Fatal("an object smaller than a part was uploaded in parts")
+	}
+}
+
+func TestUploadMultipartParts(t *testing.T) {
+	api := newFakeAPI()
+	data := randomBytes(t, 2*MinPartSize+1234)
+	if err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, UploadOptions{PartSize: MinPartSize, Concurrency: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(api.objects["key"], data) {
+		t.Fatal("the object differs from what was uploaded")
+	}
+	if got := api.sortedUploads(); len(got) != 3 {
+		t.Fatalf("uploaded parts %v, want 3", got)
+	}
+}
+
+func TestUploadMultipartAbortsOnFailure(t *testing.T) {
+	api := newFakeAPI()
+	api.failPart = 2
+	data := randomBytes(t, 3*MinPartSize)
+	err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, UploadOptions{PartSize: MinPartSize, Concurrency: 1})
+	if err == nil {
+		t.Fatal("the upload succeeded although a part failed")
+	}
+	if len(api.aborted) != 1 {
+		t.Fatalf("aborted %d uploads, want 1", len(api.aborted))
+	}
+}
+
+func TestUploadMultipartResume(t *testing.T) {
+	api := newFakeAPI()
+	data := randomBytes(t, 3*MinPartSize+100)
+	uploadID, parts := interrupt(t, api, data, 3)
+	if len(parts) != 2 {
+		t.Fatalf("%d parts were acknowledged before the failure, want 2", len(parts))
+	}
+
+	api.uploaded = nil
+	opts := UploadOptions{PartSize: MinPartSize, Concurrency: 2, UploadID: uploadID, Completed: parts}
+	if err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.sortedUploads(); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("resuming uploaded parts %v, want [3 4]", got)
+	}
+	if !bytes.Equal(api.objects["key"], data) {
+		t.Fatal("the resumed object differs from what was uploaded")
+	}
+}
+
+func TestUploadMultipartResumeMismatch(t *testing.T) {
+	data := randomBytes(t, 3*MinPartSize)
+	changed := append([]byte(nil), data...)
+	changed[MinPartSize+10] ^= 1
+
+	tests := []struct {
+		name   string
+		replay []byte
+		opts   func(uploadID string, parts []CompletedPart) UploadOptions
+	}{
+		{
+			name:   "changed completed part",
+			replay: changed,
+			opts: func(uploadID string, parts []CompletedPart) UploadOptions {
+				return UploadOptions{UploadID: uploadID, Completed: parts}
+			},
+		},
+		{
+			name:   "stream ends before the completed parts",
+			replay: data[:MinPartSize+10],
+			opts: func(uploadID string, parts []CompletedPart) UploadOptions {
+				return UploadOptions{UploadID: uploadID, Completed: parts}
+			},
+		},
+		{
+			name:   "changed sent part",
+			replay: changed,
+			opts: func(uploadID string, parts []CompletedPart) UploadOptions {
+				return UploadOptions{UploadID: uploadID, Completed: parts[:1], Sent: []CompletedPart{partOf(data, 2)}}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI()
+			uploadID, parts := interrupt(t, api, data, 3)
+
+			opts := tt.opts(uploadID, parts)
+			opts.PartSize = MinPartSize
+			opts.Concurrency = 1
+			opts.OnPart = func(CompletedPart) error { return nil }
+			err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(tt.replay), nil, opts)
+			if !errors.Is(err, ErrResumeMismatch) {
+				t.Fatalf("got %v, want ErrResumeMismatch", err)
+			}
+			if _, ok := api.objects["key"]; ok {
+				t.Fatal("a mismatched upload was completed")
+			}
+		})
+	}
+}
+
+func TestUploadMultipartResendsIdenticalSentPart(t *testing.T) {
+	api := newFakeAPI()
+	data := randomBytes(t, 2*MinPartSize)
+	uploadID, parts := interrupt(t, api, data, 2)
+
+	api.uploaded = nil
+	opts := UploadOptions{PartSize: MinPartSize, Concurrency: 1, UploadID: uploadID, Completed: parts, Sent: []CompletedPart{partOf(data, 2)}}
+	if err := uploadMultipart(context.Background(), api, "key", bytes.NewReader(data), nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.sortedUploads(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("resuming uploaded parts %v, want [2]", got)
+	}
+	if !bytes.Equal(api.objects["key"], data) {
+		t.Fatal("the resumed object differs from what was uploaded")
+	}
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Shared object operations for every client built on the AWS SDK v2 S3 API.
+// Uploads go through uploadMultipart so that streams of unknown length work.
+
+func s3PutObject(ctx context.Context, client *s3.Client, bucket, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	return uploadMultipart(ctx, s3Multipart{client: client, bucket: bucket}, key, reader, metadata, UploadOptions{})
+}
+
+// s3Multipart implements multipartAPI for AWS SDK v2 clients
+type s3Multipart struct {
+	client *s3.Client
+	bucket string
+}
+
+func (m s3Multipart) putObject(ctx context.Context, key string, body []byte, metadata map[string]string) error {
+	_, err := m.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(m.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+		Metadata:      metadata,
+	})
+	return err
+}
+
+func (m s3Multipart) createMultipartUpload(ctx context.Context, key string, metadata map[string]string) (string, error) {
+	resp, err := m.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(key),
+		Metadata: metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.UploadId), nil
+}
+
+func (m s3Multipart) uploadPart(ctx context.Context, key, uploadID string, number int32, body []byte) (string, error) {
+	resp, err := m.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(m.bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(number),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+func (m s3Multipart) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	completed := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = types.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int32(part.Number),
+		}
+	}
+	_, err := m.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+func (m s3Multipart) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := m.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+func (m s3Multipart) listMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(m.bucket),
+		Prefix: aws.String(prefix),
+	}
+	for {
+		resp, err := m.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, upload := range resp.Uploads {
+			uploads = append(uploads, MultipartUpload{
+				Key:       aws.ToString(upload.Key),
+				UploadID:  aws.ToString(upload.UploadId),
+				Initiated: aws.ToTime(upload.Initiated),
+			})
+		}
+		if !aws.ToBool(resp.IsTruncated) {
+			return uploads, nil
+		}
+		input.KeyMarker = resp.NextKeyMarker
+		input.UploadIdMarker = resp.NextUploadIdMarker
+	}
+}
+
+func s3GetObject(ctx context.Context, client *s3.Client, bucket, key string) (io.ReadCloser, *ObjectInfo, error) {
+	resp, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, wrapS3NotFound(err)
+	}
+	info := &ObjectInfo{
+		Key:      key,
+		Size:     aws.ToInt64(resp.ContentLength),
+		ModTime:  aws.ToTime(resp.LastModified),
+		Metadata: resp.Metadata,
+	}
+	return resp.Body, info, nil
+}
+
+func s3StatObject(ctx context.Context, client *s3.Client, bucket, key string) (*ObjectInfo, error) {
+	resp, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapS3NotFound(err)
+	}
+	return &ObjectInfo{
+		Key:      key,
+		Size:     aws.ToInt64(resp.ContentLength),
+		ModTime:  aws.ToTime(resp.LastModified),
+		Metadata: resp.Metadata,
+	}, nil
+}
+
+func s3ListObjects(ctx context.Context, client *s3.Client, bucket, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     aws.ToString(obj.Key),
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+func s3DeleteObject(ctx context.Context, client *s3.Client, bucket, key string) error {
+	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// wrapS3NotFound converts the SDK's 404 errors into ErrNotFound
+func wrapS3NotFound(err error) error {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+		return ErrNotFound
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == 404 {
+		return ErrNotFound
+	}
+	return err
+}
@@ -13,6 +13,15 @@ import (
 	cfg "github.com/shah1011/obscure/internal/config"
 )
 
+func init() {
+	RegisterBackend("s3-compatible", "S3-compatible", func(ctx context.Context, provider string) (Backend, error) {
+		return NewS3CompatibleClient(ctx, provider)
+	})
+	RegisterBackend("filebase-ipfs", "Filebase+IPFS", func(ctx context.Context, provider string) (Backend, error) {
+		return NewS3CompatibleClient(ctx, provider)
+	})
+}
+
 // S3CompatibleClient wraps the S3 client configured for any S3-compatible service
 type S3CompatibleClient struct {
 	client *s3.Client
@@ -69,32 +78,7 @@ func NewS3CompatibleClient(ctx context.Context, provider string) (*S3CompatibleC
 
 // UploadFile uploads a file to S3-compatible storage
 func (s *S3CompatibleClient) UploadFile(ctx context.Context, key string, reader io.Reader, metadata map[string]string) error {
-	// Convert metadata to AWS format
-	awsMetadata := make(map[string]string)
-	for k, v := range metadata {
-		awsMetadata[k] = v
-	}
-
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:   aws.String(s.bucket),
-		Key:      aws.String(key),
-		Body:     reader,
-		Metadata: awsMetadata,
-	})
-	if err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "AccessDenied") {
-			return fmt.Errorf("access denied: please check your Filebase credentials, bucket name, and permissions. The bucket must exist and your access key must have write permissions")
-		}
-		if strings.Contains(errMsg, "NoSuchBucket") {
-			return fmt.Errorf("bucket not found: the specified Filebase bucket does not exist. Please create it in the Filebase dashboard and check the name")
-		}
-		if strings.Contains(errMsg, "InvalidAccessKeyId") || strings.Contains(errMsg, "SignatureDoesNotMatch") {
-			return fmt.Errorf("invalid credentials: the provided Filebase access key or secret key is incorrect. Please verify your credentials")
-		}
-		return fmt.Errorf("filebase upload error: %v", err)
-	}
-	return nil
+	return s.Put(ctx, key, reader, -1, metadata)
 }
 
 // FileExists checks if a file exists in S3-compatible storage
@@ -155,3 +139,57 @@ func (s *S3CompatibleClient) DownloadFile(ctx context.Context, key string) (io.R
 	}
 	return resp.Body, nil
 }
+
+// Put uploads an object to S3-compatible storage
+func (s *S3CompatibleClient) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	err := s3PutObject(ctx, s.client, s.bucket, key, reader, size, metadata)
+	if err != nil {
+		errMsg := err.Error()
+		if strings.Contains(errMsg, "AccessDenied") {
+			return fmt.Errorf("access denied: please check your Filebase credentials, bucket name, and permissions. The bucket must exist and your access key must have write permissions")
+		}
+		if strings.Contains(errMsg, "NoSuchBucket") {
+			return fmt.Errorf("bucket not found: the specified Filebase bucket does not exist. Please create it in the Filebase dashboard and check the name")
+		}
+		if strings.Contains(errMsg, "InvalidAccessKeyId") || strings.Contains(errMsg, "SignatureDoesNotMatch") {
+			return fmt.Errorf("invalid credentials: the provided Filebase access key or secret key is incorrect. Please verify your credentials")
+		}
+		return fmt.Errorf("filebase upload error: %v", err)
+	}
+	return nil
+}
+
+// PutMultipart uploads an object to S3-compatible storage in parts
+func (s *S3CompatibleClient) PutMultipart(ctx context.Context, key string, reader io.Reader, metadata map[string]string, opts UploadOptions) error {
+	return uploadMultipart(ctx, s3Multipart{s.client, s.bucket}, key, reader, metadata, opts)
+}
+
+// ListMultipartUploads lists unfinished uploads in S3-compatible storage with a prefix
+func (s *S3CompatibleClient) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	return s3Multipart{s.client, s.bucket}.listMultipartUploads(ctx, prefix)
+}
+
+// AbortMultipartUpload discards an unfinished upload and its parts
+func (s *S3CompatibleClient) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return s3Multipart{s.client, s.bucket}.abortMultipartUpload(ctx, key, uploadID)
+}
+
+// Get downloads an object from S3-compatible storage
+func (s *S3CompatibleClient) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	return s3GetObject(ctx, s.client, s.bucket, key)
+}
+
+// Stat returns an object's attributes from S3-compatible storage
+func (s *S3CompatibleClient) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	return s3StatObject(ctx, s.client, s.bucket, key)
+}
+
+// List lists objects in S3-compatible storage with a prefix
+func (s *S3CompatibleClient) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	return s3ListObjects(ctx, s.client, s.bucket, prefix)
+}
+
+// Delete deletes an object from S3-compatible storage
+func (s *S3CompatibleClient) Delete(ctx context.Context, key string) error {
+	return s3DeleteObject(ctx, s.client, s.bucket, key)
+}
@@ -14,6 +14,12 @@ import (
 	cfg "github.com/shah1011/obscure/internal/config"
 )
 
+func init() {
+	RegisterBackend("storj", "Storj", func(ctx context.Context, provider string) (Backend, error) {
+		return NewStorjClient(ctx, provider)
+	})
+}
+
 // StorjClient wraps the S3 client configured for Storj
 type StorjClient struct {
 	client *s3.S3
@@ -142,3 +148,179 @@ func (s *StorjClient) DeleteFile(ctx context.Context, key string) error {
 	})
 	return err
 }
+
+// Put uploads an object to Storj
+func (s *StorjClient) Put(ctx context.Context, key string, reader io.Reader, size int64, metadata map[string]string) error {
+	return uploadMultipart(ctx, storjMultipart{s}, key, reader, metadata, UploadOptions{})
+}
+
+// PutMultipart uploads an object to Storj in parts
+func (s *StorjClient) PutMultipart(ctx context.Context, key string, reader io.Reader, metadata map[string]string, opts UploadOptions) error {
+	return uploadMultipart(ctx, storjMultipart{s}, key, reader, metadata, opts)
+}
+
+// ListMultipartUploads lists unfinished uploads in Storj with a prefix
+func (s *StorjClient) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	return storjMultipart{s}.listMultipartUploads(ctx, prefix)
+}
+
+// AbortMultipartUpload discards an unfinished upload and its parts
+func (s *StorjClient) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return storjMultipart{s}.abortMultipartUpload(ctx, key, uploadID)
+}
+
+// Get downloads an object from Storj
+func (s *StorjClient) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	result, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, wrapStorjNotFound(err)
+	}
+	info := &ObjectInfo{
+		Key:      key,
+		Size:     aws.Int64Value(result.ContentLength),
+		ModTime:  aws.TimeValue(result.LastModified),
+		Metadata: aws.StringValueMap(result.Metadata),
+	}
+	return result.Body, info, nil
+}
+
+// Stat returns an object's attributes from Storj
+func (s *StorjClient) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	result, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapStorjNotFound(err)
+	}
+	return &ObjectInfo{
+		Key:      key,
+		Size:     aws.Int64Value(result.ContentLength),
+		ModTime:  aws.TimeValue(result.LastModified),
+		Metadata: aws.StringValueMap(result.Metadata),
+	}, nil
+}
+
+// List lists objects in Storj with a prefix
+func (s *StorjClient) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	err := s.client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:     aws.StringValue(obj.Key),
+				Size:    aws.Int64Value(obj.Size),
+				ModTime: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return !lastPage
+	})
+
+	return objects, err
+}
+
+// Delete deletes an object from Storj
+func (s *StorjClient) Delete(ctx context.Context, key string) error {
+	return s.DeleteFile(ctx, key)
+}
+
+func wrapStorjNotFound(err error) error {
+	if strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "NoSuchKey") || strings.Contains(err.Error(), "not found") {
+		return ErrNotFound
+	}
+	return err
+}
+
+// storjMultipart implements multipartAPI for the AWS SDK v1 client
+type storjMultipart struct {
+	s *StorjClient
+}
+
+func (m storjMultipart) putObject(ctx context.Context, key string, body []byte, metadata map[string]string) error {
+	_, err := m.s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String(m.s.bucket),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(body),
+		Metadata: aws.StringMap(metadata),
+	})
+	return err
+}
+
+func (m storjMultipart) createMultipartUpload(ctx context.Context, key string, metadata map[string]string) (string, error) {
+	resp, err := m.s.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(m.s.bucket),
+		Key:      aws.String(key),
+		Metadata: aws.StringMap(metadata),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.UploadId), nil
+}
+
+func (m storjMultipart) uploadPart(ctx context.Context, key, uploadID string, number int32, body []byte) (string, error) {
+	resp, err := m.s.client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(m.s.bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(int64(number)),
+		Body:       bytes.NewReader(body),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.ETag), nil
+}
+
+func (m storjMultipart) completeMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	completed := make([]*s3.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(int64(part.Number)),
+		}
+	}
+	_, err := m.s.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	return err
+}
+
+func (m storjMultipart) abortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := m.s.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+func (m storjMultipart) listMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(m.s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	err := m.s.client.ListMultipartUploadsPagesWithContext(ctx, input, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, upload := range page.Uploads {
+			uploads = append(uploads, MultipartUpload{
+				Key:       aws.StringValue(upload.Key),
+				UploadID:  aws.StringValue(upload.UploadId),
+				Initiated: aws.TimeValue(upload.Initiated),
+			})
+		}
+		return !lastPage
+	})
+	return uploads, err
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// paxXattr prefixes the PAX records that hold extended attributes, the same
+// convention GNU tar and bsdtar use. POSIX ACLs are stored as the
+// system.posix_acl_* attributes.
+const paxXattr = "SCHILY.xattr."
+
+// ArchiveWriter writes files to a tar stream with their full metadata:
+// symlinks, hard links, permissions, nanosecond mtimes, ownership and
+// extended attributes
+type ArchiveWriter struct {
+	tw *tar.Writer
+	// links maps the identity of each multiply-linked file already written
+	// to its name in the archive
+	links map[fileID]string
+}
+
+// NewArchiveWriter starts a tar stream on w
+func NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	return &ArchiveWriter{tw: tar.NewWriter(w), links: map[fileID]string{}}
+}
+
+// Writer exposes the underlying tar writer for entries that are not files
+// on disk
+func (a *ArchiveWriter) Writer() *tar.Writer {
+	return a.tw
+}
+
+// Add writes file to the archive as name. A file with several links is
+// written once, and later names become hard links to the first.
+func (a *ArchiveWriter) Add(file, name string) error {
+	fi, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+	header, err := FileHeader(file, fi)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+
+	if fi.Mode().IsRegular() {
+		if id, nlink, ok := statFileID(fi); ok && nlink > 1 {
+			if first, seen := a.links[id]; seen {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+				return a.tw.WriteHeader(header)
+			}
+			a.links[id] = header.Name
+		}
+	}
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	data, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	_, err = io.Copy(a.tw, data)
+	return err
+}
+
+// HardLinkID identifies a regular file with more than one link, so its other
+// names can be stored as links to the first. It is empty for anything else.
+func HardLinkID(fi fs.FileInfo) string {
+	if !fi.Mode().IsRegular() {
+		return ""
+	}
+	id, nlink, ok := statFileID(fi)
+	if !ok || nlink < 2 {
+		return ""
+	}
+	return fmt.Sprintf("%v", id)
+}
+
+// Close writes the end of the archive
+func (a *ArchiveWriter) Close() error {
+	return a.tw.Close()
+}
+
+// FileHeader builds the tar header of file, including its symlink target and
+// extended attributes. The header uses the PAX format so mtimes keep their
+// nanoseconds; access and change times are left out, since reading a file
+// changes them and the archive would no longer be reproducible.
+func FileHeader(file string, fi fs.FileInfo) (*tar.Header, error) {
+	var link string
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return nil, err
+		}
+	}
+	header, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Format = tar.FormatPAX
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+
+	xattrs, err := ReadXattrs(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extended attributes of %s: %w", file, err)
+	}
+	for name, value := range xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+		header.PAXRecords[paxXattr+name] = value
+	}
+	return header, nil
+}
+
+// Metadata is what a restore applies to a path besides its contents
+type Metadata struct {
+	Mode    fs.FileMode
+	ModTime time.Time
+	Uid     int
+	Gid     int
+	Uname   string
+	Gname   string
+	Xattrs  map[string]string
+}
+
+// HeaderMetadata extracts the metadata recorded in a tar header
+func HeaderMetadata(header *tar.Header) Metadata {
+	m := Metadata{
+		Mode:    header.FileInfo().Mode(),
+		ModTime: header.ModTime,
+		Uid:     header.Uid,
+		Gid:     header.Gid,
+		Uname:   header.Uname,
+		Gname:   header.Gname,
+	}
+	for key, value := range header.PAXRecords {
+		if name, ok := strings.CutPrefix(key, paxXattr); ok {
+			if m.Xattrs == nil {
+				m.Xattrs = map[string]string{}
+			}
+			m.Xattrs[name] = value
+		}
+	}
+	return m
+}
+
+// ApplyMetadata sets ownership (if opts.Ownership), extended attributes,
+// permissions and mtime on target, in that order since changing the owner
+// clears setuid bits. Failures are reported to opts.OnWarning rather than
+// aborting the restore; a filesystem without xattr support should not
+// stop the files themselves from being restored.
+func (o *ExtractOptions) ApplyMetadata(name, target string, m Metadata) {
+	isLink := m.Mode&fs.ModeSymlink != 0
+
+	if o.Ownership {
+		uid, gid := lookupOwner(m)
+		if err := os.Lchown(target, uid, gid); err != nil {
+			o.warn(name, err)
+		}
+	}
+	for attr, value := range m.Xattrs {
+		if err := setXattr(target, attr, value); err != nil {
+			o.warn(name, fmt.Errorf("extended attribute %s: %w", attr, err))
+		}
+	}
+	if !isLink {
+		if err := os.Chmod(target, m.Mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			o.warn(name, err)
+		}
+	}
+	if !m.ModTime.IsZero() {
+		if err := lchtimes(target, m.ModTime, isLink); err != nil {
+			o.warn(name, err)
+		}
+	}
+}
+
+func (o *ExtractOptions) warn(name string, err error) {
+	if o.OnWarning != nil {
+		o.OnWarning(name, err)
+	}
+}
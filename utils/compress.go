@@ -1,12 +1,10 @@
 package utils
 
 import (
-	"archive/tar"
 	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
 	"path/filepath"
 
 	"github.com/klauspost/compress/zstd"
@@ -21,17 +19,15 @@ func CompressDirectoryToZstd(srcDir string) (*bytes.Buffer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create zstd writer: %w", err)
 	}
-	defer encoder.Close()
-
-	tarWriter := tar.NewWriter(encoder)
-	defer tarWriter.Close()
+	aw := NewArchiveWriter(encoder)
 
 	err = filepath.Walk(srcDir, func(file string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
+		// Skip the root directory itself
+		if file == srcDir {
 			return nil
 		}
 
@@ -39,74 +35,37 @@ func CompressDirectoryToZstd(srcDir string) (*bytes.Buffer, error) {
 		if err != nil {
 			return err
 		}
-
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
-		}
-		header.Name = relPath
-
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return err
-		}
-
-		f, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-
-		_, err = io.Copy(tarWriter, f)
-		return err
+		return aw.Add(file, relPath)
 	})
+	if err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
 
-	return &buf, err
+	return &buf, nil
 }
 
 // DecompressZstdToDirectory extracts a .tar.zst archive into a directory
 func DecompressZstdToDirectory(reader io.Reader, outputDir string) error {
+	return DecompressZstdWithOptions(reader, outputDir, &ExtractOptions{})
+}
+
+// DecompressZstdWithOptions extracts the selected entries of a .tar.zst archive
+func DecompressZstdWithOptions(reader io.Reader, outputDir string, opts *ExtractOptions) error {
 	decoder, err := zstd.NewReader(reader)
 	if err != nil {
 		return fmt.Errorf("failed to create zstd decoder: %w", err)
 	}
 	defer decoder.Close()
 
-	tarReader := tar.NewReader(decoder)
-
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break // done
-		}
-		if err != nil {
-			return fmt.Errorf("failed to read tar entry: %w", err)
-		}
-
-		targetPath := filepath.Join(outputDir, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, 0755); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return err
-			}
-			outFile, err := os.Create(targetPath)
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(outFile, tarReader); err != nil {
-				outFile.Close()
-				return err
-			}
-			outFile.Close()
-		default:
-			// Skip special files like symlinks
-			continue
-		}
+	if _, err := ExtractTar(decoder, outputDir, opts); err != nil {
+		return err
 	}
-
+	opts.Finish()
 	return nil
 }
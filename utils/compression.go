@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"archive/tar"
 	"bytes"
 	"fmt"
 	"io"
@@ -15,8 +14,7 @@ func ReadDirectoryAsBytes(dirPath string) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// Create a single tar writer for the entire archive
-	tw := tar.NewWriter(&buf)
-	defer tw.Close()
+	aw := NewArchiveWriter(&buf)
 
 	// Walk through the directory
 	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
@@ -35,31 +33,9 @@ func ReadDirectoryAsBytes(dirPath string) ([]byte, error) {
 			return fmt.Errorf("failed to get relative path: %w", err)
 		}
 
-		// Write file header
-		header := &tar.Header{
-			Name:    relPath,
-			Mode:    int64(info.Mode()),
-			Size:    info.Size(),
-			ModTime: info.ModTime(),
+		if err := aw.Add(path, relPath); err != nil {
+			return fmt.Errorf("failed to add %s to tar: %w", relPath, err)
 		}
-
-		if err := tw.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write tar header: %w", err)
-		}
-
-		// If it's a regular file, write its contents
-		if info.Mode().IsRegular() {
-			file, err := os.Open(path)
-			if err != nil {
-				return fmt.Errorf("failed to open file: %w", err)
-			}
-			defer file.Close()
-
-			if _, err := io.Copy(tw, file); err != nil {
-				return fmt.Errorf("failed to write file to tar: %w", err)
-			}
-		}
-
 		return nil
 	})
 
@@ -68,7 +44,7 @@ func ReadDirectoryAsBytes(dirPath string) ([]byte, error) {
 	}
 
 	// Close the tar writer to flush any remaining data
-	if err := tw.Close(); err != nil {
+	if err := aw.Close(); err != nil {
 		return nil, fmt.Errorf("failed to close tar writer: %w", err)
 	}
 
@@ -77,64 +53,10 @@ func ReadDirectoryAsBytes(dirPath string) ([]byte, error) {
 
 // ExtractTarArchive extracts a tar archive from a reader to the specified directory
 func ExtractTarArchive(reader io.Reader, outputDir string) error {
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
-	}
-
-	// Create tar reader
-	tr := tar.NewReader(reader)
-
-	// Extract each file
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break // End of archive
-		}
-		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
-		}
-
-		// Construct full path
-		target := filepath.Join(outputDir, header.Name)
-
-		// Create parent directories if needed
-		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-			return fmt.Errorf("failed to create parent directory: %w", err)
-		}
-
-		// Handle different types of files
-		switch header.Typeflag {
-		case tar.TypeDir:
-			// Create directory
-			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
-				return fmt.Errorf("failed to create directory: %w", err)
-			}
-
-		case tar.TypeReg:
-			// Create file
-			file, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return fmt.Errorf("failed to create file: %w", err)
-			}
-
-			// Copy file contents
-			if _, err := io.Copy(file, tr); err != nil {
-				file.Close()
-				return fmt.Errorf("failed to write file contents: %w", err)
-			}
-			file.Close()
-
-		case tar.TypeSymlink:
-			// Create symlink
-			if err := os.Symlink(header.Linkname, target); err != nil {
-				return fmt.Errorf("failed to create symlink: %w", err)
-			}
-
-		default:
-			return fmt.Errorf("unsupported file type: %v", header.Typeflag)
-		}
+	opts := &ExtractOptions{}
+	if _, err := ExtractTar(reader, outputDir, opts); err != nil {
+		return err
 	}
-
+	opts.Finish()
 	return nil
 }
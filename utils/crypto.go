@@ -1,84 +1,153 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/klauspost/compress/zstd"
 )
 
+// .obscure v2 and later layout:
+//
+//	[header][chunk 0][chunk 1]...[final chunk]
+//
+// The header is described in header.go. Each chunk is ChunkSize bytes of
+// plaintext sealed with AES-GCM (so ChunkSize+16 bytes on disk); only the
+// final chunk may be shorter. The 12-byte nonce of chunk i is
+// [nonce prefix][uint32 i][final flag], and the header is passed as
+// additional data to every chunk. Dropping, reordering or truncating chunks
+// therefore fails authentication.
+//
+// v1 files have no header: [16-byte salt][12-byte nonce][ciphertext], sealed
+// in a single gcm.Seal call.
+const (
+	FormatVersion = 3
+	ChunkSize     = 64 * 1024
+
+	noncePrefixSize = 7
+	tagSize         = 16
+	saltSize        = 16
+)
+
+var magic = []byte("OBSCURE")
+
+// ErrTruncated is returned when an encrypted stream ends before its final chunk
+var ErrTruncated = errors.New("encrypted stream is truncated")
+
+// EncryptBuffer encrypts an in-memory buffer without compression
 func EncryptBuffer(plainBuf *bytes.Buffer, password string) (*bytes.Buffer, error) {
-	salt, err := GenerateSalt()
+	return EncryptBufferWithKey(plainBuf, password, nil)
+}
+
+// EncryptBufferWithKey is EncryptBuffer that encrypts to the public keys of
+// key when it is set, and to password otherwise
+func EncryptBufferWithKey(plainBuf *bytes.Buffer, password string, key *WrappedKey) (*bytes.Buffer, error) {
+	finalBuf := new(bytes.Buffer)
+
+	opts := DefaultEncryptOptions()
+	opts.Compression = CompressionNone
+	opts.Key = key
+	encWriter, err := EncryptStreamWithOptions(finalBuf, password, opts)
 	if err != nil {
 		return nil, err
 	}
-
-	key, err := DeriveKey(password, salt)
-	if err != nil {
+	if _, err := encWriter.Write(plainBuf.Bytes()); err != nil {
 		return nil, err
 	}
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
+	if err := encWriter.Close(); err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	return finalBuf, nil
+}
+
+// DecryptStream decrypts a streamed .obscure backup. v2 and later files are
+// decrypted one chunk at a time; v1 files are read fully into memory.
+func DecryptStream(encStream io.Reader, password string) (io.Reader, error) {
+	reader, _, err := DecryptStreamWithHeader(encStream, password)
+	return reader, err
+}
+
+// DecryptStreamWithHeader is DecryptStream that also returns the parsed
+// header, so callers can tell how the plaintext was compressed
+func DecryptStreamWithHeader(encStream io.Reader, password string) (io.Reader, *Header, error) {
+	return DecryptStreamWithKeys(encStream, PasswordKeys(password))
+}
+
+// DecryptStreamWithKeys is DecryptStreamWithHeader for backups that may be
+// encrypted either to a password or to public keys. The password is only
+// asked for when the backup needs it.
+func DecryptStreamWithKeys(encStream io.Reader, keys Keys) (io.Reader, *Header, error) {
+	br := bufio.NewReader(encStream)
+
+	header, err := readHeader(br)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
+	if header.Version == 1 {
+		password, err := keys.password()
+		if err != nil {
+			return nil, nil, err
+		}
+		reader, err := decryptV1(br, password)
+		return reader, header, err
 	}
 
-	ciphertext := gcm.Seal(nil, nonce, plainBuf.Bytes(), nil)
+	master, err := header.masterKey(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey, macKey, err := header.subkeys(master)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := header.verify(macKey); err != nil {
+		return nil, nil, err
+	}
 
-	finalBuf := new(bytes.Buffer)
-	finalBuf.Write(salt)
-	finalBuf.Write(nonce)
-	finalBuf.Write(ciphertext)
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return finalBuf, nil
+	return &decryptReader{
+		reader:      br,
+		gcm:         gcm,
+		header:      header.raw,
+		noncePrefix: header.NoncePrefix,
+		chunk:       make([]byte, int(header.ChunkSize)+tagSize),
+	}, header, nil
 }
 
-// DecryptStream decrypts a streamed .obscure backup from S3.
-// Format assumed: [16-byte salt][12-byte nonce][ciphertext...]
-func DecryptStream(encStream io.Reader, password string) (io.Reader, error) {
+// decryptV1 handles the legacy single-shot format: [16-byte salt][12-byte nonce][ciphertext...]
+func decryptV1(encStream io.Reader, password string) (io.Reader, error) {
 	// Read salt (16 bytes)
-	salt := make([]byte, 16)
+	salt := make([]byte, saltSize)
 	if _, err := io.ReadFull(encStream, salt); err != nil {
 		return nil, fmt.Errorf("failed to read salt: %w", err)
 	}
 
-	// Derive key
 	key, err := DeriveKey(password, salt)
 	if err != nil {
 		return nil, fmt.Errorf("key derivation failed: %w", err)
 	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
 
 	// Read nonce (12 bytes)
-	nonce := make([]byte, 12)
+	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(encStream, nonce); err != nil {
 		return nil, fmt.Errorf("failed to read nonce: %w", err)
 	}
 
-	// Create cipher
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
-	}
-
 	// Read all ciphertext
 	var ciphertextBuf bytes.Buffer
 	if _, err := io.Copy(&ciphertextBuf, encStream); err != nil {
@@ -95,77 +164,203 @@ func DecryptStream(encStream io.Reader, password string) (io.Reader, error) {
 	return bytes.NewReader(plaintext), nil
 }
 
-// EncryptStream creates a writer that encrypts data using AES-GCM
+// EncryptStream creates a writer that encrypts zstd-compressed data using
+// chunked AES-GCM. Memory use is bounded by ChunkSize regardless of the
+// stream length. The caller must Close the writer to emit the final chunk.
 func EncryptStream(w io.Writer, password string) (io.WriteCloser, error) {
-	salt, err := GenerateSalt()
-	if err != nil {
-		return nil, err
-	}
-
-	// Write salt to output
-	if _, err := w.Write(salt); err != nil {
-		return nil, fmt.Errorf("failed to write salt: %w", err)
-	}
+	return EncryptStreamWithOptions(w, password, DefaultEncryptOptions())
+}
 
-	key, err := DeriveKey(password, salt)
+// EncryptStreamWithOptions is EncryptStream with explicit header options
+func EncryptStreamWithOptions(w io.Writer, password string, opts EncryptOptions) (io.WriteCloser, error) {
+	header, err := newHeader(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
+	var master []byte
+	if opts.Key != nil {
+		master = opts.Key.Key
+	} else if master, err = header.masterKey(PasswordKeys(password)); err != nil {
 		return nil, err
 	}
-
-	gcm, err := cipher.NewGCM(block)
+	dataKey, macKey, err := header.subkeys(master)
 	if err != nil {
 		return nil, err
 	}
+	raw := header.marshal(macKey)
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	gcm, err := newGCM(dataKey)
+	if err != nil {
 		return nil, err
 	}
 
-	// Write nonce to output
-	if _, err := w.Write(nonce); err != nil {
-		return nil, fmt.Errorf("failed to write nonce: %w", err)
+	// Write header to output
+	if _, err := w.Write(append(raw[:len(raw):len(raw)], header.slotsRaw...)); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
 	}
 
-	// Create a buffer to store all plaintext
-	var plaintextBuf bytes.Buffer
-
 	return &encryptWriter{
-		writer:       w,
-		gcm:          gcm,
-		nonce:        nonce,
-		plaintextBuf: &plaintextBuf,
+		writer:      w,
+		gcm:         gcm,
+		header:      raw,
+		noncePrefix: header.NoncePrefix,
+		chunkSize:   int(header.ChunkSize),
+		buf:         make([]byte, 0, header.ChunkSize),
+		sealed:      make([]byte, 0, int(header.ChunkSize)+tagSize),
 	}, nil
 }
 
 type encryptWriter struct {
-	writer       io.Writer
-	gcm          cipher.AEAD
-	nonce        []byte
-	plaintextBuf *bytes.Buffer
+	writer      io.Writer
+	gcm         cipher.AEAD
+	header      []byte
+	noncePrefix []byte
+	chunkSize   int
+	counter     uint32
+	buf         []byte
+	sealed      []byte
+	closed      bool
 }
 
 func (w *encryptWriter) Write(p []byte) (int, error) {
-	// Write to plaintext buffer
-	n, err := w.plaintextBuf.Write(p)
+	if w.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only flushed once more data arrives, so that the
+		// last chunk is always the one sealed with the final flag in Close
+		if len(w.buf) == w.chunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):w.chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *encryptWriter) flush(final bool) error {
+	nonce, err := chunkNonce(w.noncePrefix, w.counter, final)
 	if err != nil {
-		return n, err
+		return err
+	}
+	w.sealed = w.gcm.Seal(w.sealed[:0], nonce, w.buf, w.header)
+	if _, err := w.writer.Write(w.sealed); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.counter++
+	return nil
+}
+
+type decryptReader struct {
+	reader      *bufio.Reader
+	gcm         cipher.AEAD
+	header      []byte
+	noncePrefix []byte
+	counter     uint32
+	chunk       []byte
+	plaintext   []byte
+	done        bool
+	err         error
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.nextChunk()
 	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
 	return n, nil
 }
 
-func (w *encryptWriter) Close() error {
-	// Encrypt the entire plaintext at once
-	ciphertext := w.gcm.Seal(nil, w.nonce, w.plaintextBuf.Bytes(), nil)
+func (r *decryptReader) nextChunk() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	final := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		// A short chunk can only be the last one
+		final = true
+	case err != nil:
+		return fmt.Errorf("failed to read ciphertext: %w", err)
+	default:
+		// A full chunk is the last one if nothing follows it
+		if _, err := r.reader.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return fmt.Errorf("failed to read ciphertext: %w", err)
+		}
+	}
+	if n < tagSize {
+		return ErrTruncated
+	}
+
+	nonce, err := chunkNonce(r.noncePrefix, r.counter, final)
+	if err != nil {
+		return err
+	}
+	plaintext, err := r.gcm.Open(r.chunk[:0], nonce, r.chunk[:n], r.header)
+	if err != nil {
+		if final {
+			// Either the data was tampered with or the stream was cut at a
+			// chunk boundary and this chunk was never sealed as final
+			return fmt.Errorf("decryption failed (wrong password, corrupted or truncated data): %w", err)
+		}
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	r.plaintext = plaintext
+	r.counter++
+	r.done = final
+	return nil
+}
 
-	// Write the ciphertext
-	_, err := w.writer.Write(ciphertext)
-	return err
+// chunkNonce builds [prefix][big-endian counter][final flag]
+func chunkNonce(prefix []byte, counter uint32, final bool) ([]byte, error) {
+	if counter == ^uint32(0) {
+		return nil, errors.New("encrypted stream exceeds the maximum number of chunks")
+	}
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+	return gcm, nil
 }
 
 // NewCompressWriter creates a zstd compression writer
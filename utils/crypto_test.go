@@ -0,0 +1,400 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testOptions encrypts with a KDF cheap enough for tests
+func testOptions() EncryptOptions {
+	opts := DefaultEncryptOptions()
+	opts.KDF = Argon2idParams(64, 1, 1)
+	return opts
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encrypt(t *testing.T, plaintext []byte, opts EncryptOptions) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := EncryptStreamWithOptions(&out, testPassword, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decrypt(data []byte, keys Keys) ([]byte, error) {
+	r, _, err := DecryptStreamWithKeys(bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// splitChunks returns the header of an encrypted stream and its chunks
+func splitChunks(t *testing.T, data []byte) ([]byte, [][]byte) {
+	t.Helper()
+	header, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, rest := data[:header.Size()], data[header.Size():]
+	var chunks [][]byte
+	for len(rest) > 0 {
+		n := min(len(rest), int(header.ChunkSize)+tagSize)
+		chunks = append(chunks, rest[:n])
+		rest = rest[n:]
+	}
+	return head, chunks
+}
+
+func join(head []byte, chunks ...[]byte) []byte {
+	out := append([]byte(nil), head...)
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		plaintext := randomBytes(t, size)
+		got, err := decrypt(encrypt(t, plaintext, testOptions()), PasswordKeys(testPassword))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("%d bytes: plaintext does not round trip", size)
+		}
+	}
+}
+
+func TestStreamTruncatedAfterChunk(t *testing.T) {
+	// Three full chunks and a short final one
+	head, chunks := splitChunks(t, encrypt(t, randomBytes(t, 3*ChunkSize+100), testOptions()))
+	if len(chunks) != 4 {
+		t.Fatalf("expected 4 chunks, got %d", len(chunks))
+	}
+	for keep := 0; keep < len(chunks); keep++ {
+		if _, err := decrypt(join(head, chunks[:keep]...), PasswordKeys(testPassword)); err == nil {
+			t.Errorf("stream cut after %d of %d chunks decrypted without error", keep, len(chunks))
+		}
+	}
+
+	// A plaintext of exactly two chunks: cutting off the final chunk leaves
+	// a stream that ends on a full chunk that was not sealed as final
+	head, chunks = splitChunks(t, encrypt(t, randomBytes(t, 2*ChunkSize), testOptions()))
+	if _, err := decrypt(join(head, chunks[0]), PasswordKeys(testPassword)); err == nil {
+		t.Error("stream cut at a chunk boundary decrypted without error")
+	}
+}
+
+func TestStreamChunkOrderEnforced(t *testing.T) {
+	head, chunks := splitChunks(t, encrypt(t, randomBytes(t, 3*ChunkSize+100), testOptions()))
+	cases := map[string][][]byte{
+		"swapped":     {chunks[1], chunks[0], chunks[2], chunks[3]},
+		"repeated":    {chunks[0], chunks[0], chunks[1], chunks[2], chunks[3]},
+		"dropped":     {chunks[0], chunks[2], chunks[3]},
+		"final early": {chunks[0], chunks[3]},
+	}
+	for name, order := range cases {
+		if _, err := decrypt(join(head, order...), PasswordKeys(testPassword)); err == nil {
+			t.Errorf("%s chunks decrypted without error", name)
+		}
+	}
+}
+
+func TestChunkNonceCounter(t *testing.T) {
+	prefix := bytes.Repeat([]byte{0xab}, noncePrefixSize)
+	a, err := chunkNonce(prefix, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := chunkNonce(prefix, 2, false)
+	final, _ := chunkNonce(prefix, 1, true)
+	if bytes.Equal(a, b) || bytes.Equal(a, final) {
+		t.Fatal("chunk nonces repeat")
+	}
+	if _, err := chunkNonce(prefix, ^uint32(0), false); err == nil {
+		t.Fatal("counter overflow accepted")
+	}
+}
+
+// encryptV1 builds a file in the original single-shot format
+func encryptV1(t *testing.T, plaintext []byte) []byte {
+	t.Helper()
+	salt := randomBytes(t, saltSize)
+	key, err := DeriveKey(testPassword, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := randomBytes(t, gcm.NonceSize())
+	out := append(append(salt, nonce...), gcm.Seal(nil, nonce, plaintext, nil)...)
+	return out
+}
+
+// encryptV2 builds a file with the fixed v2 header, whose chunks are sealed
+// with the password-derived key itself
+func encryptV2(t *testing.T, plaintext []byte) []byte {
+	t.Helper()
+	salt := randomBytes(t, saltSize)
+	key, err := DeriveKey(testPassword, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := append(append(append(append([]byte(nil), magic...), 2), salt...), randomBytes(t, noncePrefixSize)...)
+
+	out := bytes.NewBuffer(append([]byte(nil), raw...))
+	w := &encryptWriter{
+		writer:      out,
+		gcm:         gcm,
+		header:      raw,
+		noncePrefix: raw[len(raw)-noncePrefixSize:],
+		chunkSize:   ChunkSize,
+		buf:         make([]byte, 0, ChunkSize),
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestFormatVersionsRoundTrip(t *testing.T) {
+	plaintext := randomBytes(t, 2*ChunkSize+5)
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientKey, err := WrapKey([]age.Recipient{identity.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterID, master := randomBytes(t, MasterKeyIDSize), randomBytes(t, KeyLength)
+	keyringKey, err := WrapKeyWithMaster(masterID, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipientOpts, keyringOpts := testOptions(), testOptions()
+	recipientOpts.Key = recipientKey
+	keyringOpts.Key = keyringKey
+
+	cases := []struct {
+		name    string
+		data    []byte
+		version byte
+		keys    Keys
+	}{
+		{"v1", encryptV1(t, plaintext), 1, PasswordKeys(testPassword)},
+		{"v2", encryptV2(t, plaintext), 2, PasswordKeys(testPassword)},
+		{"v3 password", encrypt(t, plaintext, testOptions()), 3, PasswordKeys(testPassword)},
+		{"v3 scrypt", encrypt(t, plaintext, EncryptOptions{KDF: LegacyKDFParams(), Compression: CompressionZstd, ChunkSize: ChunkSize}), 3, PasswordKeys(testPassword)},
+		{"v3 recipient", encrypt(t, plaintext, recipientOpts), 3, Keys{Identities: []age.Identity{identity}}},
+		{"v3 keyring", encrypt(t, plaintext, keyringOpts), 3, Keys{MasterKey: func(id []byte) ([]byte, error) {
+			if !bytes.Equal(id, masterID) {
+				return nil, errors.New("unknown master key")
+			}
+			return master, nil
+		}}},
+	}
+	for _, c := range cases {
+		r, header, err := DecryptStreamWithKeys(bytes.NewReader(c.data), c.keys)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if header.Version != c.version || !bytes.Equal(got, plaintext) {
+			t.Fatalf("%s: version %d, plaintext matches: %v", c.name, header.Version, bytes.Equal(got, plaintext))
+		}
+	}
+}
+
+func TestWrongKeysRejected(t *testing.T) {
+	data := encrypt(t, randomBytes(t, 100), testOptions())
+	if _, err := decrypt(data, PasswordKeys("wrong")); !errors.Is(err, ErrHeaderAuth) {
+		t.Fatalf("wrong password: %v", err)
+	}
+
+	identity, _ := age.GenerateX25519Identity()
+	other, _ := age.GenerateX25519Identity()
+	opts := testOptions()
+	opts.Key, _ = WrapKey([]age.Recipient{identity.Recipient()})
+	data = encrypt(t, randomBytes(t, 100), opts)
+	if _, err := decrypt(data, Keys{Identities: []age.Identity{other}}); !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("wrong identity: %v", err)
+	}
+}
+
+func TestHeaderTampering(t *testing.T) {
+	data := encrypt(t, randomBytes(t, ChunkSize+10), testOptions())
+	header, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// magic, version, body length, KDF id, params length, Argon2id params,
+	// salt length: the salt follows
+	saltOffset := len(magic) + 3 + 2 + 9 + 1
+	for name, offset := range map[string]int{
+		"salt":         saltOffset,
+		"nonce prefix": header.Size() - headerMACSize - 1,
+		"MAC":          header.Size() - 1,
+	} {
+		tampered := append([]byte(nil), data...)
+		tampered[offset] ^= 1
+		if _, err := decrypt(tampered, PasswordKeys(testPassword)); !errors.Is(err, ErrHeaderAuth) {
+			t.Errorf("tampered %s: %v", name, err)
+		}
+	}
+
+	// Any other header byte either fails to parse or to authenticate
+	for offset := 0; offset < header.Size(); offset++ {
+		tampered := append([]byte(nil), data...)
+		tampered[offset] ^= 0x80
+		if _, err := decrypt(tampered, PasswordKeys(testPassword)); err == nil {
+			t.Errorf("header byte %d tampered without error", offset)
+		}
+	}
+}
+
+func TestKeySlotTampering(t *testing.T) {
+	masterID, master := randomBytes(t, MasterKeyIDSize), randomBytes(t, KeyLength)
+	opts := testOptions()
+	opts.Key, _ = WrapKeyWithMaster(masterID, master)
+	data := encrypt(t, randomBytes(t, 100), opts)
+	header, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := Keys{MasterKey: func([]byte) ([]byte, error) { return master, nil }}
+	// The last header byte is the end of the sealed key
+	data[header.Size()-1] ^= 1
+	if _, err := decrypt(data, keys); err == nil {
+		t.Fatal("tampered key slot accepted")
+	}
+}
+
+func TestChunkTampering(t *testing.T) {
+	data := encrypt(t, randomBytes(t, 2*ChunkSize+10), testOptions())
+	header, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int{header.Size(), header.Size() + ChunkSize + tagSize + 7, len(data) - 1} {
+		tampered := append([]byte(nil), data...)
+		tampered[offset] ^= 1
+		if _, err := decrypt(tampered, PasswordKeys(testPassword)); err == nil {
+			t.Errorf("chunk byte %d tampered without error", offset)
+		}
+	}
+}
+
+func TestV2TruncatedAndReordered(t *testing.T) {
+	data := encryptV2(t, randomBytes(t, 2*ChunkSize+10))
+	head, chunks := splitChunks(t, data)
+	for name, order := range map[string][][]byte{
+		"truncated": {chunks[0], chunks[1]},
+		"swapped":   {chunks[1], chunks[0], chunks[2]},
+	} {
+		if _, err := decrypt(join(head, order...), PasswordKeys(testPassword)); err == nil {
+			t.Errorf("v2 %s stream decrypted without error", name)
+		}
+	}
+}
+
+func TestUnwrapKey(t *testing.T) {
+	masterID, master := randomBytes(t, MasterKeyIDSize), randomBytes(t, KeyLength)
+	wrapped, err := WrapKeyWithMaster(masterID, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := Keys{MasterKey: func([]byte) ([]byte, error) { return master, nil }}
+	got, err := UnwrapKey(wrapped.Slots, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Key, wrapped.Key) || !bytes.Equal(got.Slots, wrapped.Slots) {
+		t.Fatal("unwrapped key differs")
+	}
+
+	other := randomBytes(t, KeyLength)
+	keys = Keys{MasterKey: func([]byte) ([]byte, error) { return other, nil }}
+	if _, err := UnwrapKey(wrapped.Slots, keys); err == nil {
+		t.Fatal("key slots opened with the wrong master key")
+	}
+}
+
+func TestKeySealedToSeveralKeyrings(t *testing.T) {
+	masters := map[string][]byte{}
+	var ids [][]byte
+	for range 2 {
+		id, master := randomBytes(t, MasterKeyIDSize), randomBytes(t, KeyLength)
+		masters[string(id)] = master
+		ids = append(ids, id)
+	}
+	opts := testOptions()
+	var err error
+	if opts.Key, err = WrapKeyWithMaster(ids[0], masters[string(ids[0])]); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.Key.AddMasterSlot(ids[1], masters[string(ids[1])]); err != nil {
+		t.Fatal(err)
+	}
+	plaintext := randomBytes(t, 1000)
+	data := encrypt(t, plaintext, opts)
+
+	// Each keyring only knows its own master key
+	for _, id := range ids {
+		keys := Keys{MasterKey: func(want []byte) ([]byte, error) {
+			if !bytes.Equal(want, id) {
+				return nil, ErrUnknownMasterKey
+			}
+			return masters[string(id)], nil
+		}}
+		got, err := decrypt(data, keys)
+		if err != nil {
+			t.Fatalf("keyring %x: %v", id, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("keyring %x: plaintext differs", id)
+		}
+	}
+
+	unknown := Keys{MasterKey: func([]byte) ([]byte, error) { return nil, ErrUnknownMasterKey }}
+	if _, err := decrypt(data, unknown); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Fatalf("unknown keyring: %v", err)
+	}
+}
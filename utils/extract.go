@@ -0,0 +1,545 @@
+package utils
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what happens to a path that already exists in the
+// restore directory
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictRename    ConflictPolicy = "rename"
+	ConflictNewer     ConflictPolicy = "newer"
+)
+
+// ParseConflictPolicy validates an --on-conflict value
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return ConflictOverwrite, nil
+	case ConflictOverwrite, ConflictSkip, ConflictRename, ConflictNewer:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q (use skip, overwrite, rename or newer)", s)
+	}
+}
+
+var (
+	// ErrUnsafeEntry marks an archive entry that would write outside the
+	// restore directory
+	ErrUnsafeEntry = errors.New("unsafe archive entry")
+	// ErrLimitExceeded aborts a restore that goes over MaxEntries or MaxBytes
+	ErrLimitExceeded = errors.New("restore limit exceeded")
+)
+
+// ExtractAction is what a restore does with one path
+type ExtractAction string
+
+const (
+	ActionCreate    ExtractAction = "create"
+	ActionOverwrite ExtractAction = "overwrite"
+	ActionSkip      ExtractAction = "skip"
+	ActionRename    ExtractAction = "rename"
+	ActionDelete    ExtractAction = "delete"
+)
+
+// ExtractOptions selects which archive entries are restored and how. Patterns
+// are slash-separated globs relative to the backup root; "**" matches any
+// number of directories. A pattern without a slash matches the base name at
+// any depth, and a pattern matching a directory selects everything under it.
+//
+// Reuse the same options for every archive of an incremental chain: paths
+// written earlier in the restore are always replaced, and the conflict
+// policy only applies to files that were there before it started.
+type ExtractOptions struct {
+	Include []string
+	Exclude []string
+
+	OnConflict ConflictPolicy
+	// DryRun only reports what would happen, through OnEntry
+	DryRun  bool
+	OnEntry func(name, target string, action ExtractAction)
+
+	// Ownership restores the recorded owner and group, which needs root
+	Ownership bool
+	// OnWarning is told about metadata that could not be restored
+	OnWarning func(name string, err error)
+
+	// MaxEntries and MaxBytes abort the restore once it has written more
+	// entries or bytes of file contents; zero means no limit
+	MaxEntries int
+	MaxBytes   int64
+	// AllowUnsafeLinks restores symlinks that point outside the restore
+	// directory, which are rejected by default
+	AllowUnsafeLinks bool
+	// OnReject is told about every entry refused as unsafe
+	OnReject func(name string, err error)
+
+	// Roots sends the top-level entries of a multi-source backup back to
+	// their own paths: entry root, and everything under root/, is restored
+	// at Roots[root] instead of under the output directory. Entries outside
+	// every root are rejected.
+	Roots map[string]string
+
+	// Handlers read the entries with these names instead of extracting them
+	Handlers map[string]func(r io.Reader) error
+
+	// restored maps the archive names written so far to their paths on disk
+	restored map[string]string
+	// dirs holds the metadata of created directories until Finish
+	dirs []pendingDir
+	// links holds the names of the symlinks restored so far, so nothing is
+	// written through them even in a dry run
+	links   map[string]bool
+	entries int
+	bytes   int64
+}
+
+type pendingDir struct {
+	name   string
+	target string
+	meta   Metadata
+}
+
+// Filtered reports whether any include or exclude pattern is set
+func (o *ExtractOptions) Filtered() bool {
+	return len(o.Include) > 0 || len(o.Exclude) > 0
+}
+
+// Selects reports whether the entry name should be restored
+func (o *ExtractOptions) Selects(name string) bool {
+	name = cleanName(name)
+	if len(o.Include) > 0 && !matchAny(o.Include, name) {
+		return false
+	}
+	return !matchAny(o.Exclude, name)
+}
+
+// Admit checks that entry name, of size bytes, can safely be restored under
+// outputDir and counts it against the limits. The name has to be relative
+// and stay inside outputDir, and no directory above it may be a symlink, so
+// an archive cannot plant a link and then write through it. linkname is the
+// target of a symlink entry and empty otherwise; unless AllowUnsafeLinks is
+// set it may only climb out of the entry's directory with leading "..", and
+// never above outputDir.
+//
+// Rejected entries are reported to OnReject and Admit returns false; the
+// error is only set when the restore has to stop.
+func (o *ExtractOptions) Admit(outputDir, name, linkname string, size int64) (bool, error) {
+	if err := o.checkEntry(outputDir, name, linkname); err != nil {
+		if !errors.Is(err, ErrUnsafeEntry) {
+			return false, err
+		}
+		if o.OnReject != nil {
+			o.OnReject(name, err)
+		}
+		return false, nil
+	}
+
+	o.entries++
+	o.bytes += size
+	if o.MaxEntries > 0 && o.entries > o.MaxEntries {
+		return false, fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, o.MaxEntries)
+	}
+	if o.MaxBytes > 0 && o.bytes > o.MaxBytes {
+		return false, fmt.Errorf("%w: more than %d bytes", ErrLimitExceeded, o.MaxBytes)
+	}
+	if linkname != "" {
+		if o.links == nil {
+			o.links = map[string]bool{}
+		}
+		o.links[cleanName(name)] = true
+	} else {
+		delete(o.links, cleanName(name))
+	}
+	return true, nil
+}
+
+func (o *ExtractOptions) checkEntry(outputDir, name, linkname string) error {
+	local := filepath.FromSlash(name)
+	if filepath.IsAbs(local) || strings.HasPrefix(filepath.ToSlash(name), "/") {
+		return fmt.Errorf("%w: absolute path", ErrUnsafeEntry)
+	}
+	if !filepath.IsLocal(local) {
+		return fmt.Errorf("%w: path escapes the restore directory", ErrUnsafeEntry)
+	}
+
+	name = cleanName(name)
+	dir, rel, ok := o.base(outputDir, name)
+	if !ok {
+		return fmt.Errorf("%w: not under any restored source", ErrUnsafeEntry)
+	}
+	parts := strings.Split(name, "/")
+	for i := range parts[:len(parts)-1] {
+		if parent := strings.Join(parts[:i+1], "/"); o.links[parent] {
+			return fmt.Errorf("%w: %s is a symlink", ErrUnsafeEntry, parent)
+		}
+	}
+	parts = strings.Split(rel, "/")
+	for i := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, parts[i])
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a symlink", ErrUnsafeEntry, dir)
+		}
+	}
+
+	if linkname == "" || o.AllowUnsafeLinks {
+		return nil
+	}
+	if filepath.IsAbs(linkname) || path.IsAbs(filepath.ToSlash(linkname)) {
+		return fmt.Errorf("%w: symlink to absolute path %s", ErrUnsafeEntry, linkname)
+	}
+	named := false
+	for _, segment := range strings.Split(filepath.ToSlash(linkname), "/") {
+		if segment != ".." {
+			named = named || (segment != "." && segment != "")
+			continue
+		}
+		// ".." after a name could climb back out of a directory symlink
+		if named {
+			return fmt.Errorf("%w: symlink target %s has .. after a directory name", ErrUnsafeEntry, linkname)
+		}
+	}
+	if resolved := path.Join(path.Dir(rel), filepath.ToSlash(linkname)); !filepath.IsLocal(filepath.FromSlash(resolved)) {
+		return fmt.Errorf("%w: symlink to %s points outside the restore directory", ErrUnsafeEntry, linkname)
+	}
+	return nil
+}
+
+// base returns the directory entry name is restored under and the name's
+// path relative to it: outputDir and the name itself, unless Roots moves
+// the entry's source elsewhere
+func (o *ExtractOptions) base(outputDir, name string) (string, string, bool) {
+	if o.Roots == nil {
+		return outputDir, name, true
+	}
+	root, rest, _ := strings.Cut(name, "/")
+	dest, ok := o.Roots[root]
+	if !ok {
+		return "", "", false
+	}
+	if rest == "" {
+		return filepath.Dir(dest), filepath.Base(dest), true
+	}
+	return dest, rest, true
+}
+
+// Resolve decides where archive entry name goes under outputDir and what
+// happens to whatever is already there. With ConflictRename the entry is
+// written next to the existing path as name.restored, name.restored.2, ...
+func (o *ExtractOptions) Resolve(outputDir, name string, modTime time.Time, isDir bool) (string, ExtractAction, error) {
+	if o.restored == nil {
+		o.restored = map[string]string{}
+	}
+	name = cleanName(name)
+	if target, ok := o.restored[name]; ok {
+		if isDir {
+			return target, ActionSkip, nil
+		}
+		return target, ActionOverwrite, nil
+	}
+
+	dir, rel, ok := o.base(outputDir, name)
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s is not under any restored source", ErrUnsafeEntry, name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		o.restored[name] = target
+		return target, ActionCreate, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if isDir && fi.IsDir() {
+		// Existing directories are merged into, never replaced
+		return target, ActionSkip, nil
+	}
+
+	action := ActionOverwrite
+	switch o.OnConflict {
+	case ConflictSkip:
+		return target, ActionSkip, nil
+	case ConflictNewer:
+		if !modTime.After(fi.ModTime()) {
+			return target, ActionSkip, nil
+		}
+	case ConflictRename:
+		action = ActionRename
+		base := target + ".restored"
+		target = base
+		for i := 2; ; i++ {
+			if _, err := os.Lstat(target); os.IsNotExist(err) {
+				break
+			}
+			target = fmt.Sprintf("%s.%d", base, i)
+		}
+	}
+	o.restored[name] = target
+	return target, action, nil
+}
+
+// Restored returns the path archive entry name was written to by this
+// restore, if it was
+func (o *ExtractOptions) Restored(name string) (string, bool) {
+	target, ok := o.restored[cleanName(name)]
+	return target, ok
+}
+
+// Remove deletes name, and anything under it, if this restore wrote it.
+// Files that were in the restore directory beforehand are left alone.
+func (o *ExtractOptions) Remove(name string) error {
+	name = cleanName(name)
+	var names []string
+	for restored := range o.restored {
+		if restored == name || strings.HasPrefix(restored, name+"/") {
+			names = append(names, restored)
+		}
+	}
+	sort.Strings(names)
+	for _, restored := range names {
+		target := o.restored[restored]
+		delete(o.restored, restored)
+		delete(o.links, restored)
+		o.report(restored, target, ActionDelete)
+		if o.DryRun {
+			continue
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeferDir records the metadata of a created directory. It is applied by
+// Finish, once nothing more will be written inside the directory.
+func (o *ExtractOptions) DeferDir(name, target string, m Metadata) {
+	o.dirs = append(o.dirs, pendingDir{name: name, target: target, meta: m})
+}
+
+// Finish applies the metadata of the directories created by the restore,
+// deepest first. Call it after the last archive has been extracted.
+func (o *ExtractOptions) Finish() {
+	for i := len(o.dirs) - 1; i >= 0; i-- {
+		// Skip directories an increment has deleted since
+		if _, err := os.Lstat(o.dirs[i].target); err != nil {
+			continue
+		}
+		o.ApplyMetadata(o.dirs[i].name, o.dirs[i].target, o.dirs[i].meta)
+	}
+	o.dirs = nil
+}
+
+func (o *ExtractOptions) report(name, target string, action ExtractAction) {
+	if o.OnEntry != nil {
+		o.OnEntry(name, target, action)
+	}
+}
+
+func cleanName(name string) string {
+	return strings.Trim(path.Clean(filepath.ToSlash(name)), "/")
+}
+
+// matchAny reports whether name, or one of its parent directories, matches
+// any of the patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = cleanName(pattern)
+		for p := name; p != "." && p != ""; p = path.Dir(p) {
+			if MatchPattern(pattern, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchPattern matches a slash-separated name against a glob that may use
+// "**" for any number of path segments
+func MatchPattern(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return MatchPath(pattern, name)
+}
+
+// MatchPath matches a slash-separated name against a glob segment by
+// segment, from the top, whether or not the pattern has a slash
+func MatchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ExtractTar extracts the selected entries of a tar stream into outputDir,
+// or to opts.Roots, and returns how many were written, or would be in a
+// dry run. Unselected
+// entries are skipped without being buffered, so a single file can be
+// pulled out of a large archive. Directory metadata is applied by
+// opts.Finish, which the caller runs after the last archive.
+func ExtractTar(reader io.Reader, outputDir string, opts *ExtractOptions) (int, error) {
+	if !opts.DryRun && opts.Roots == nil {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return 0, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	tr := tar.NewReader(reader)
+	extracted := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, fmt.Errorf("failed to read tar entry: %w", err)
+		}
+		if handler, ok := opts.Handlers[cleanName(header.Name)]; ok {
+			if err := handler(tr); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+		if !opts.Selects(header.Name) {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+		default:
+			// Skip special files
+			continue
+		}
+
+		var linkname string
+		if header.Typeflag == tar.TypeSymlink {
+			linkname = header.Linkname
+		}
+		ok, err := opts.Admit(outputDir, header.Name, linkname, header.Size)
+		if err != nil {
+			return extracted, err
+		}
+		if !ok {
+			continue
+		}
+
+		isDir := header.Typeflag == tar.TypeDir
+		var linkSource string
+		if header.Typeflag == tar.TypeLink {
+			// The first name of a hard link carries the contents, so it has
+			// to have been restored too
+			var ok bool
+			if linkSource, ok = opts.Restored(header.Linkname); !ok {
+				if !filepath.IsLocal(filepath.FromSlash(header.Linkname)) && opts.OnReject != nil {
+					opts.OnReject(header.Name, fmt.Errorf("%w: hard link to %s", ErrUnsafeEntry, header.Linkname))
+				} else {
+					opts.warn(header.Name, fmt.Errorf("hard link to %s, which was not restored", header.Linkname))
+				}
+				continue
+			}
+		}
+		target, action, err := opts.Resolve(outputDir, header.Name, header.ModTime, isDir)
+		if err != nil {
+			return extracted, err
+		}
+		if isDir && action == ActionSkip {
+			continue
+		}
+		opts.report(header.Name, target, action)
+		if action == ActionSkip {
+			continue
+		}
+		extracted++
+		if opts.DryRun {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return extracted, fmt.Errorf("failed to create parent directory: %w", err)
+		}
+		if action == ActionOverwrite {
+			// Replace the old path rather than writing through it, in case
+			// it is a symlink
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return extracted, err
+			}
+		}
+
+		meta := HeaderMetadata(header)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return extracted, err
+			}
+			opts.DeferDir(header.Name, target, meta)
+			continue
+		case tar.TypeReg:
+			outFile, err := os.Create(target)
+			if err != nil {
+				return extracted, err
+			}
+			if _, err := io.Copy(outFile, tr); err != nil {
+				outFile.Close()
+				return extracted, err
+			}
+			if err := outFile.Close(); err != nil {
+				return extracted, err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return extracted, fmt.Errorf("failed to create symlink: %w", err)
+			}
+		case tar.TypeLink:
+			// Shares its metadata with the file it links to
+			if err := os.Link(linkSource, target); err != nil {
+				return extracted, fmt.Errorf("failed to create hard link: %w", err)
+			}
+			continue
+		}
+		opts.ApplyMetadata(header.Name, target, meta)
+	}
+
+	return extracted, nil
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func writeTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.body)), ModTime: time.Unix(1700000000, 0)}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestCheckEntry(t *testing.T) {
+	outputDir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(outputDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		entry       string
+		linkname    string
+		allowUnsafe bool
+		links       map[string]bool
+		unsafe      bool
+	}{
+		{name: "relative file", entry: "dir/file.txt"},
+		{name: "absolute path", entry: "/etc/passwd", unsafe: true},
+		{name: "dot dot", entry: "../escape.txt", unsafe: true},
+		{name: "dot dot inside name", entry: "dir/../../escape.txt", unsafe: true},
+		{name: "dot dot staying inside", entry: "dir/../file.txt"},
+		{name: "symlinked parent on disk", entry: "link/file.txt", unsafe: true},
+		{name: "symlinked parent in archive", entry: "planted/file.txt", links: map[string]bool{"planted": true}, unsafe: true},
+		{name: "symlink inside", entry: "dir/link", linkname: "../file.txt"},
+		{name: "symlink to sibling", entry: "dir/link", linkname: "other/file.txt"},
+		{name: "symlink to absolute path", entry: "dir/link", linkname: "/etc/passwd", unsafe: true},
+		{name: "symlink above the restore directory", entry: "dir/link", linkname: "../../escape.txt", unsafe: true},
+		{name: "symlink with dot dot after a name", entry: "dir/link", linkname: "other/../../escape.txt", unsafe: true},
+		{name: "unsafe symlink allowed", entry: "dir/link", linkname: "/etc/passwd", allowUnsafe: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &ExtractOptions{AllowUnsafeLinks: tt.allowUnsafe, links: tt.links}
+			err := opts.checkEntry(outputDir, tt.entry, tt.linkname)
+			if tt.unsafe && !errors.Is(err, ErrUnsafeEntry) {
+				t.Fatalf("got %v, want ErrUnsafeEntry", err)
+			}
+			if !tt.unsafe && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractTarRejectsUnsafeEntries(t *testing.T) {
+	outputDir := t.TempDir()
+	archive := writeTar(t,
+		tarEntry{name: "../escape.txt", typeflag: tar.TypeReg, body: "x"},
+		tarEntry{name: "absolute", typeflag: tar.TypeSymlink, linkname: "/tmp"},
+		tarEntry{name: "planted", typeflag: tar.TypeSymlink, linkname: "sub"},
+		tarEntry{name: "planted/file.txt", typeflag: tar.TypeReg, body: "x"},
+		tarEntry{name: "hardlink", typeflag: tar.TypeLink, linkname: "../../etc/passwd"},
+		tarEntry{name: "safe.txt", typeflag: tar.TypeReg, body: "safe"},
+		tarEntry{name: "safe-link", typeflag: tar.TypeLink, linkname: "safe.txt"},
+	)
+
+	var rejected []string
+	opts := &ExtractOptions{OnReject: func(name string, err error) { rejected = append(rejected, name) }}
+	n, err := ExtractTar(archive, outputDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("extracted %d entries, want 3", n)
+	}
+	want := []string{"../escape.txt", "absolute", "planted/file.txt", "hardlink"}
+	if len(rejected) != len(want) {
+		t.Fatalf("rejected %v, want %v", rejected, want)
+	}
+	for i := range want {
+		if rejected[i] != want[i] {
+			t.Fatalf("rejected %v, want %v", rejected, want)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(outputDir, "safe-link")); err != nil || string(data) != "safe" {
+		t.Fatalf("hard link to a restored file: %q, %v", data, err)
+	}
+}
+
+func TestExtractConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy  ConflictPolicy
+		modTime time.Time
+		action  ExtractAction
+		want    map[string]string
+	}{
+		{policy: ConflictOverwrite, action: ActionOverwrite, want: map[string]string{"file.txt": "new"}},
+		{policy: ConflictSkip, action: ActionSkip, want: map[string]string{"file.txt": "old"}},
+		{policy: ConflictRename, action: ActionRename, want: map[string]string{"file.txt": "old", "file.txt.restored": "older", "file.txt.restored.2": "new"}},
+		{policy: ConflictNewer, modTime: time.Unix(1, 0), action: ActionSkip, want: map[string]string{"file.txt": "old"}},
+		{policy: ConflictNewer, modTime: time.Now().Add(time.Hour), action: ActionOverwrite, want: map[string]string{"file.txt": "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			outputDir := t.TempDir()
+			for name, data := range map[string]string{"file.txt": "old", "file.txt.restored": "older"} {
+				if err := os.WriteFile(filepath.Join(outputDir, name), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			modTime := tt.modTime
+			if modTime.IsZero() {
+				modTime = time.Unix(1700000000, 0)
+			}
+			if err := tw.WriteHeader(&tar.Header{Name: "file.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3, ModTime: modTime}); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write([]byte("new"))
+			tw.Close()
+
+			var action ExtractAction
+			opts := &ExtractOptions{OnConflict: tt.policy, OnEntry: func(name, target string, a ExtractAction) { action = a }}
+			if _, err := ExtractTar(&buf, outputDir, opts); err != nil {
+				t.Fatal(err)
+			}
+			if action != tt.action {
+				t.Fatalf("action %q, want %q", action, tt.action)
+			}
+			for name, want := range tt.want {
+				data, err := os.ReadFile(filepath.Join(outputDir, name))
+				if err != nil || string(data) != want {
+					t.Fatalf("%s = %q, %v; want %q", name, data, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractReplacesOwnPaths(t *testing.T) {
+	// A later archive of a chain replaces what the restore wrote, whatever
+	// the policy
+	outputDir := t.TempDir()
+	opts := &ExtractOptions{OnConflict: ConflictSkip}
+	for _, body := range []string{"first", "second"} {
+		if _, err := ExtractTar(writeTar(t, tarEntry{name: "file.txt", typeflag: tar.TypeReg, body: body}), outputDir, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "file.txt")); string(data) != "second" {
+		t.Fatalf("file.txt = %q, want %q", data, "second")
+	}
+}
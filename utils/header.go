@@ -0,0 +1,487 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// .obscure v3 layout:
+//
+//	[7-byte magic "OBSCURE"][1-byte version][uint16 body length][body][32-byte HMAC-SHA256]
+//
+// body:
+//
+//	[KDF id][params length][KDF params...]
+//	[salt length][salt...]
+//	[cipher id][uint32 chunk size][compression id]
+//	[nonce prefix length][nonce prefix...]
+//
+// All integers are big-endian. The HMAC covers every byte before it and is
+// keyed by a subkey of the password-derived key, so a wrong password or an
+// edited header is detected before any data is decrypted. The whole header,
+// HMAC included, is also the additional data of every chunk. The body length
+// lets ReadHeader skip fields added by later versions.
+//
+// When the KDF is KDFWrapped, the key slots described in recipients.go follow
+// the HMAC.
+const (
+	headerMACSize  = sha256.Size
+	maxChunkSize   = 16 * 1024 * 1024
+	maxScryptLogN  = 22
+	maxScryptR     = 32
+	maxScryptP     = 16
+	maxArgon2Mem   = 4 * 1024 * 1024 // KiB
+	maxArgon2Time  = 64
+	headerDataInfo = "obscure v3 data key"
+	headerMACInfo  = "obscure v3 header key"
+)
+
+// CipherID identifies the chunk cipher of a backup
+type CipherID byte
+
+const (
+	CipherAES256GCM CipherID = 1
+)
+
+func (c CipherID) String() string {
+	switch c {
+	case CipherAES256GCM:
+		return "AES-256-GCM"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+// CompressionID identifies how the plaintext was compressed before encryption
+type CompressionID byte
+
+const (
+	CompressionNone CompressionID = 0
+	CompressionZstd CompressionID = 1
+)
+
+func (c CompressionID) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+// ErrHeaderAuth is returned when the header HMAC does not verify
+var ErrHeaderAuth = errors.New("header authentication failed: wrong password or corrupted header")
+
+// Header describes how a .obscure file was produced
+type Header struct {
+	Version     byte
+	KDF         KDFParams
+	Salt        []byte
+	Cipher      CipherID
+	ChunkSize   uint32
+	Compression CompressionID
+	NoncePrefix []byte
+	// Slots hold the sealed master key of a backup encrypted to public keys
+	Slots []KeySlot
+
+	raw      []byte // header bytes as written, used as additional data
+	slotsRaw []byte // key slots as written after the header
+}
+
+// Authenticated reports whether the header carries its own HMAC
+func (h *Header) Authenticated() bool {
+	return h.Version >= 3
+}
+
+// Size returns the number of bytes the header occupies in the file
+func (h *Header) Size() int {
+	if h.Version == 1 {
+		return saltSize + 12
+	}
+	return len(h.raw) + len(h.slotsRaw)
+}
+
+// EncryptOptions controls the header written by EncryptStreamWithOptions
+type EncryptOptions struct {
+	KDF         KDFParams
+	Compression CompressionID
+	ChunkSize   uint32
+
+	// Salt and NoncePrefix are random when empty. Setting them makes the
+	// output reproducible, which resuming an interrupted upload relies on;
+	// never reuse them for different plaintext.
+	Salt        []byte
+	NoncePrefix []byte
+
+	// Key, when set, encrypts to the public keys it was wrapped for instead
+	// of a password, and KDF is ignored
+	Key *WrappedKey
+}
+
+// DefaultEncryptOptions matches what the backup command produces: zstd
+// compressed data encrypted with the default KDF
+func DefaultEncryptOptions() EncryptOptions {
+	return EncryptOptions{
+		KDF:         DefaultKDFParams(),
+		Compression: CompressionZstd,
+		ChunkSize:   ChunkSize,
+	}
+}
+
+// newHeader creates a v3 header, with a fresh salt and nonce prefix unless
+// opts specifies them
+func newHeader(opts EncryptOptions) (*Header, error) {
+	salt := opts.Salt
+	if len(salt) == 0 {
+		var err error
+		if salt, err = GenerateSalt(); err != nil {
+			return nil, err
+		}
+	}
+
+	noncePrefix := opts.NoncePrefix
+	if len(noncePrefix) == 0 {
+		var err error
+		if noncePrefix, err = GenerateNoncePrefix(); err != nil {
+			return nil, err
+		}
+	}
+
+	kdf := opts.KDF
+	var slotsRaw []byte
+	if opts.Key != nil {
+		kdf = KDFParams{Algorithm: KDFWrapped}
+		slotsRaw = opts.Key.Slots
+	}
+
+	h := &Header{
+		Version:     FormatVersion,
+		KDF:         kdf,
+		Salt:        salt,
+		Cipher:      CipherAES256GCM,
+		ChunkSize:   opts.ChunkSize,
+		Compression: opts.Compression,
+		NoncePrefix: noncePrefix,
+		slotsRaw:    slotsRaw,
+	}
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// marshal serializes a v3 header and appends its HMAC
+func (h *Header) marshal(macKey []byte) []byte {
+	var body bytes.Buffer
+	kdfParams := h.KDF.marshal()
+	body.WriteByte(byte(h.KDF.Algorithm))
+	body.WriteByte(byte(len(kdfParams)))
+	body.Write(kdfParams)
+	body.WriteByte(byte(len(h.Salt)))
+	body.Write(h.Salt)
+	body.WriteByte(byte(h.Cipher))
+	binary.Write(&body, binary.BigEndian, h.ChunkSize)
+	body.WriteByte(byte(h.Compression))
+	body.WriteByte(byte(len(h.NoncePrefix)))
+	body.Write(h.NoncePrefix)
+
+	raw := make([]byte, 0, len(magic)+3+body.Len()+headerMACSize)
+	raw = append(raw, magic...)
+	raw = append(raw, h.Version)
+	raw = binary.BigEndian.AppendUint16(raw, uint16(body.Len()))
+	raw = append(raw, body.Bytes()...)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(raw)
+	h.raw = mac.Sum(raw)
+	return h.raw
+}
+
+// masterKey derives the master key from the password or, for a backup
+// encrypted to public keys or a keyring, opens it from the key slots
+func (h *Header) masterKey(keys Keys) ([]byte, error) {
+	if h.KDF.Algorithm == KDFWrapped {
+		return unwrapKey(h.Slots, keys)
+	}
+	password, err := keys.password()
+	if err != nil {
+		return nil, err
+	}
+	master, err := DeriveKeyWithParams(password, h.Salt, h.KDF)
+	if err != nil {
+		return nil, fmt.Errorf("key derivation failed: %w", err)
+	}
+	return master, nil
+}
+
+// subkeys derives the chunk key and header MAC key from the master key.
+// v2 files used the master key directly as the chunk key.
+func (h *Header) subkeys(master []byte) (dataKey, macKey []byte, err error) {
+	if !h.Authenticated() {
+		return master, nil, nil
+	}
+
+	dataKey, err = hkdf.Key(sha256.New, master, h.Salt, headerDataInfo, KeyLength)
+	if err != nil {
+		return nil, nil, err
+	}
+	macKey, err = hkdf.Key(sha256.New, master, h.Salt, headerMACInfo, KeyLength)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, macKey, nil
+}
+
+// verify checks the header HMAC
+func (h *Header) verify(macKey []byte) error {
+	if !h.Authenticated() {
+		return nil
+	}
+	body, tag := h.raw[:len(h.raw)-headerMACSize], h.raw[len(h.raw)-headerMACSize:]
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), tag) {
+		return ErrHeaderAuth
+	}
+	return nil
+}
+
+// validate rejects parameters that are unsupported or would let a crafted
+// header exhaust memory
+func (h *Header) validate() error {
+	if h.Cipher != CipherAES256GCM {
+		return fmt.Errorf("unsupported cipher: %s", h.Cipher)
+	}
+	if h.ChunkSize == 0 || h.ChunkSize > maxChunkSize {
+		return fmt.Errorf("invalid chunk size: %d", h.ChunkSize)
+	}
+	if len(h.NoncePrefix) != noncePrefixSize {
+		return fmt.Errorf("invalid nonce prefix length: %d", len(h.NoncePrefix))
+	}
+	if len(h.Salt) == 0 {
+		return errors.New("salt is required")
+	}
+	switch h.Compression {
+	case CompressionNone, CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported compression: %s", h.Compression)
+	}
+	return h.KDF.Validate()
+}
+
+func (p KDFParams) marshal() []byte {
+	switch p.Algorithm {
+	case KDFScrypt:
+		buf := make([]byte, 0, 12)
+		buf = binary.BigEndian.AppendUint32(buf, p.N)
+		buf = binary.BigEndian.AppendUint32(buf, p.R)
+		buf = binary.BigEndian.AppendUint32(buf, p.P)
+		return buf
+	case KDFArgon2id:
+		buf := make([]byte, 0, 9)
+		buf = binary.BigEndian.AppendUint32(buf, p.Memory)
+		buf = binary.BigEndian.AppendUint32(buf, p.Time)
+		buf = append(buf, p.Threads)
+		return buf
+	default:
+		return nil
+	}
+}
+
+func parseKDFParams(algorithm KDFAlgorithm, data []byte) (KDFParams, error) {
+	p := KDFParams{Algorithm: algorithm}
+	switch algorithm {
+	case KDFScrypt:
+		if len(data) != 12 {
+			return p, fmt.Errorf("invalid scrypt parameters")
+		}
+		p.N = binary.BigEndian.Uint32(data[0:4])
+		p.R = binary.BigEndian.Uint32(data[4:8])
+		p.P = binary.BigEndian.Uint32(data[8:12])
+	case KDFWrapped:
+		if len(data) != 0 {
+			return p, fmt.Errorf("invalid key slot parameters")
+		}
+	case KDFArgon2id:
+		if len(data) != 9 {
+			return p, fmt.Errorf("invalid argon2id parameters")
+		}
+		p.Memory = binary.BigEndian.Uint32(data[0:4])
+		p.Time = binary.BigEndian.Uint32(data[4:8])
+		p.Threads = data[8]
+	default:
+		return p, fmt.Errorf("unsupported KDF: %s", algorithm)
+	}
+	return p, p.Validate()
+}
+
+// Validate rejects KDF parameters that are unsupported or too costly to
+// accept from a file header
+func (p KDFParams) Validate() error {
+	switch p.Algorithm {
+	case KDFScrypt:
+		if p.N < 2 || p.N&(p.N-1) != 0 || p.N > 1<<maxScryptLogN {
+			return fmt.Errorf("invalid scrypt N: %d", p.N)
+		}
+		if p.R == 0 || p.R > maxScryptR || p.P == 0 || p.P > maxScryptP {
+			return fmt.Errorf("invalid scrypt parameters: r=%d p=%d", p.R, p.P)
+		}
+		return nil
+	case KDFWrapped:
+		return nil
+	case KDFArgon2id:
+		if p.Threads == 0 || p.Time == 0 || p.Time > maxArgon2Time {
+			return fmt.Errorf("invalid argon2id parameters: t=%d p=%d", p.Time, p.Threads)
+		}
+		if p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Mem {
+			return fmt.Errorf("invalid argon2id memory: %d KiB", p.Memory)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported KDF: %s", p.Algorithm)
+	}
+}
+
+// ReadHeader reads and parses the header of a .obscure file. It needs no
+// password; the header is only authenticated once the file is decrypted.
+func ReadHeader(r io.Reader) (*Header, error) {
+	return readHeader(bufio.NewReader(r))
+}
+
+func readHeader(br *bufio.Reader) (*Header, error) {
+	prefix, err := br.Peek(len(magic) + 1)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.Equal(prefix[:min(len(prefix), len(magic))], magic) {
+		return readLegacyHeader(br)
+	}
+
+	version := prefix[len(magic)]
+	switch version {
+	case 2:
+		return readV2Header(br)
+	case 3:
+		return readV3Header(br)
+	default:
+		return nil, fmt.Errorf("unsupported .obscure format version: %d", version)
+	}
+}
+
+// readLegacyHeader reads the salt of a v1 file, leaving the nonce unread
+func readLegacyHeader(br *bufio.Reader) (*Header, error) {
+	salt, err := br.Peek(saltSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+	return &Header{
+		Version:     1,
+		KDF:         LegacyKDFParams(),
+		Salt:        append([]byte(nil), salt...),
+		Cipher:      CipherAES256GCM,
+		Compression: CompressionZstd,
+	}, nil
+}
+
+// readV2Header reads the fixed [magic][version][salt][nonce prefix] header
+func readV2Header(br *bufio.Reader) (*Header, error) {
+	raw := make([]byte, len(magic)+1+saltSize+noncePrefixSize)
+	if _, err := io.ReadFull(br, raw); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return &Header{
+		Version:     2,
+		KDF:         LegacyKDFParams(),
+		Salt:        raw[len(magic)+1 : len(magic)+1+saltSize],
+		Cipher:      CipherAES256GCM,
+		ChunkSize:   ChunkSize,
+		Compression: CompressionZstd,
+		NoncePrefix: raw[len(magic)+1+saltSize:],
+		raw:         raw,
+	}, nil
+}
+
+func readV3Header(br *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, len(magic)+3)
+	if _, err := io.ReadFull(br, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	bodyLen := int(binary.BigEndian.Uint16(fixed[len(magic)+1:]))
+
+	raw := make([]byte, len(fixed)+bodyLen+headerMACSize)
+	copy(raw, fixed)
+	if _, err := io.ReadFull(br, raw[len(fixed):]); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	h := &Header{Version: fixed[len(magic)], raw: raw}
+	body := raw[len(fixed) : len(fixed)+bodyLen]
+
+	next := func(n int) ([]byte, error) {
+		if len(body) < n {
+			return nil, errors.New("malformed header")
+		}
+		field := body[:n]
+		body = body[n:]
+		return field, nil
+	}
+	nextVar := func() ([]byte, error) {
+		length, err := next(1)
+		if err != nil {
+			return nil, err
+		}
+		return next(int(length[0]))
+	}
+
+	kdfID, err := next(1)
+	if err != nil {
+		return nil, err
+	}
+	kdfParams, err := nextVar()
+	if err != nil {
+		return nil, err
+	}
+	if h.KDF, err = parseKDFParams(KDFAlgorithm(kdfID[0]), kdfParams); err != nil {
+		return nil, err
+	}
+	if h.Salt, err = nextVar(); err != nil {
+		return nil, err
+	}
+	cipherID, err := next(1)
+	if err != nil {
+		return nil, err
+	}
+	h.Cipher = CipherID(cipherID[0])
+	chunkSize, err := next(4)
+	if err != nil {
+		return nil, err
+	}
+	h.ChunkSize = binary.BigEndian.Uint32(chunkSize)
+	compression, err := next(1)
+	if err != nil {
+		return nil, err
+	}
+	h.Compression = CompressionID(compression[0])
+	if h.NoncePrefix, err = nextVar(); err != nil {
+		return nil, err
+	}
+
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+	if h.KDF.Algorithm == KDFWrapped {
+		if h.Slots, h.slotsRaw, err = readSlots(br); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
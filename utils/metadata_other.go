@@ -0,0 +1,28 @@
+//go:build !unix
+
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+// fileID is unavailable here, so hard links are archived as separate files
+type fileID struct{}
+
+func statFileID(fi os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
+
+// lchtimes leaves symlink times alone, since they cannot be set without
+// following the link
+func lchtimes(path string, mtime time.Time, isLink bool) error {
+	if isLink {
+		return nil
+	}
+	return os.Chtimes(path, mtime, mtime)
+}
+
+func lookupOwner(m Metadata) (int, int) {
+	return m.Uid, m.Gid
+}
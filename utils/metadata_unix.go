@@ -0,0 +1,76 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileID identifies a file across its hard links
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+func statFileID(fi os.FileInfo) (fileID, uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, uint64(st.Nlink), true
+}
+
+// lchtimes sets the mtime of path without following a symlink
+func lchtimes(path string, mtime time.Time, isLink bool) error {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+var (
+	ownerMu sync.Mutex
+	uids    = map[string]int{}
+	gids    = map[string]int{}
+)
+
+// lookupOwner maps the recorded user and group names to local IDs, falling
+// back to the recorded numeric IDs, as tar does
+func lookupOwner(m Metadata) (int, int) {
+	ownerMu.Lock()
+	defer ownerMu.Unlock()
+
+	uid, gid := m.Uid, m.Gid
+	if m.Uname != "" {
+		id, ok := uids[m.Uname]
+		if !ok {
+			id = -1
+			if u, err := user.Lookup(m.Uname); err == nil {
+				id, _ = strconv.Atoi(u.Uid)
+			}
+			uids[m.Uname] = id
+		}
+		if id >= 0 {
+			uid = id
+		}
+	}
+	if m.Gname != "" {
+		id, ok := gids[m.Gname]
+		if !ok {
+			id = -1
+			if g, err := user.LookupGroup(m.Gname); err == nil {
+				id, _ = strconv.Atoi(g.Gid)
+			}
+			gids[m.Gname] = id
+		}
+		if id >= 0 {
+			gid = id
+		}
+	}
+	return uid, gid
+}
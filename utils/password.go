@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// PasswordEnv names the environment variable holding the backup password
+const PasswordEnv = "OBSCURE_PASSWORD"
+
+// PasswordSource is where the backup password comes from when nobody is
+// there to type it. At most one of the fields may be set; with none, the
+// OBSCURE_PASSWORD environment variable is used.
+type PasswordSource struct {
+	// File holds the password on its first line
+	File string
+	// Command prints the password on stdout, e.g. "pass show obscure"
+	Command string
+	// Keyfile is a file made by WriteKeyfile
+	Keyfile string
+}
+
+// Configured reports whether a password can be read without prompting
+func (s PasswordSource) Configured() bool {
+	return s.File != "" || s.Command != "" || s.Keyfile != "" || os.Getenv(PasswordEnv) != ""
+}
+
+// Read returns the password from the configured source
+func (s PasswordSource) Read() (string, error) {
+	set := 0
+	for _, v := range []string{s.File, s.Command, s.Keyfile} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", fmt.Errorf("use only one of --password-file, --password-command and --keyfile")
+	}
+
+	var password string
+	switch {
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		password, _, _ = strings.Cut(string(data), "\n")
+	case s.Keyfile != "":
+		data, err := os.ReadFile(s.Keyfile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read keyfile: %w", err)
+		}
+		password = strings.TrimSpace(string(data))
+	case s.Command != "":
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", s.Command)
+		} else {
+			cmd = exec.Command("sh", "-c", s.Command)
+		}
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("password command failed: %w", err)
+		}
+		password, _, _ = strings.Cut(string(out), "\n")
+	default:
+		password = os.Getenv(PasswordEnv)
+	}
+
+	password = strings.TrimRight(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("the configured password source is empty")
+	}
+	return password, nil
+}
+
+// WriteKeyfile creates a keyfile holding a random 256-bit secret, readable
+// only by the current user. An existing file is never replaced.
+func WriteKeyfile(path string) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, base64.RawURLEncoding.EncodeToString(secret)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
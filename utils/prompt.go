@@ -30,10 +30,18 @@ func PromptPasswordConfirm(prompt string) (string, error) {
 	return password, nil
 }
 
-// PromptPassword prompts for a password
+// PromptPassword prompts for a password. When stdin is a pipe, such as a
+// database dump being backed up, the password is read from the terminal.
 func PromptPassword(prompt string) (string, error) {
+	fd := int(syscall.Stdin)
+	if !term.IsTerminal(fd) {
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			defer tty.Close()
+			fd = int(tty.Fd())
+		}
+	}
 	fmt.Print(prompt)
-	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+	bytePassword, err := term.ReadPassword(fd)
 	fmt.Println() // newline after password input
 	if err != nil {
 		return "", err
@@ -6,24 +6,10 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/manifoldco/promptui"
 )
 
-type BackupInfo struct {
-	Key      string
-	Size     int64
-	Modified time.Time
-	Metadata map[string]string
-}
-
-type StorageProvider interface {
-	Upload(filePath string, metadata map[string]string) (string, error)
-	Download(objectKey string, destinationPath string) error
-	List(prefix string) ([]BackupInfo, error)
-}
-
 func FetchUserDefaultProvider(email string) (string, error) {
 	url := fmt.Sprintf("http://localhost:8080/api/users/default-provider?email=%s", email)
 
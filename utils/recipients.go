@@ -0,0 +1,364 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// A backup encrypted to public keys or to a keyring master key has no
+// password. Its master key is random, and a copy of it is sealed to each
+// recipient as a small age file, or to the master key with AES-GCM, stored
+// right after the header HMAC:
+//
+//	[uint16 slots length][slot type][uint16 slot length][slot data]...
+//
+// The slots are neither covered by the HMAC nor part of the chunks'
+// additional data, so recipients can be changed without re-encrypting the
+// backup. A slot that yields the wrong key still fails the HMAC.
+
+// KeySlotType identifies how a key slot seals the master key
+type KeySlotType byte
+
+const (
+	// KeySlotAge is an age file holding the master key, readable with the
+	// recipient's identity, e.g. `age -d -i key.txt`
+	KeySlotAge KeySlotType = 1
+	// KeySlotMaster is [8-byte master key ID][12-byte nonce][AES-GCM
+	// sealed key], opened by the keyring master key with that ID
+	KeySlotMaster KeySlotType = 2
+)
+
+const (
+	MasterKeyIDSize = 8
+
+	masterSlotInfo = "obscure master key slot"
+)
+
+// KeySlot is one sealed copy of a backup's master key
+type KeySlot struct {
+	Type KeySlotType
+	Data []byte
+}
+
+// ErrNoIdentity is returned when none of the available identities opens
+// any key slot of a backup
+var ErrNoIdentity = errors.New("this backup is encrypted to public keys and none of your identities can open it")
+
+// ErrUnknownMasterKey is returned by Keys.MasterKey for a master key ID it
+// does not have. A backup sealed to several keyrings has a slot for each,
+// and the other slots are tried.
+var ErrUnknownMasterKey = errors.New("the keyring has no master key")
+
+// Keys are what a backup may be decrypted with
+type Keys struct {
+	// Password is only called for password-encrypted backups
+	Password func() (string, error)
+	// Identities are tried on the key slots of backups encrypted to public
+	// keys
+	Identities []age.Identity
+	// MasterKey returns the keyring master key with the given ID. It is
+	// only called for backups encrypted to a keyring.
+	MasterKey func(id []byte) ([]byte, error)
+}
+
+// PasswordKeys returns Keys holding just a password
+func PasswordKeys(password string) Keys {
+	return Keys{Password: func() (string, error) { return password, nil }}
+}
+
+func (k Keys) password() (string, error) {
+	if k.Password == nil {
+		return "", errors.New("this backup is encrypted with a password")
+	}
+	return k.Password()
+}
+
+// WrappedKey is a random master key together with its key slots
+type WrappedKey struct {
+	Key   []byte
+	Slots []byte
+}
+
+// WrapKey creates a master key and seals it to every recipient
+func WrapKey(recipients []age.Recipient) (*WrappedKey, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	key := make([]byte, KeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	var slots []KeySlot
+	for _, recipient := range recipients {
+		var sealed bytes.Buffer
+		w, err := age.Encrypt(&sealed, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal the key: %w", err)
+		}
+		if _, err := w.Write(key); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		slots = append(slots, KeySlot{Type: KeySlotAge, Data: sealed.Bytes()})
+	}
+	raw, err := marshalSlots(slots)
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedKey{Key: key, Slots: raw}, nil
+}
+
+// WrapKeyWithMaster creates a master key for one backup and seals it to a
+// keyring master key, so that the backup's password can change without
+// touching the backup
+func WrapKeyWithMaster(id, master []byte) (*WrappedKey, error) {
+	key := make([]byte, KeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	slot, err := sealMasterSlot(key, id, master)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := marshalSlots([]KeySlot{slot})
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedKey{Key: key, Slots: raw}, nil
+}
+
+// AddMasterSlot seals the key to one more keyring master key, so that the
+// backup opens with either keyring, e.g. in buckets that each have their own
+func (w *WrappedKey) AddMasterSlot(id, master []byte) error {
+	slots, err := parseSlots(w.Slots)
+	if err != nil {
+		return err
+	}
+	slot, err := sealMasterSlot(w.Key, id, master)
+	if err != nil {
+		return err
+	}
+	raw, err := marshalSlots(append(slots, slot))
+	if err != nil {
+		return err
+	}
+	w.Slots = raw
+	return nil
+}
+
+func sealMasterSlot(key, id, master []byte) (KeySlot, error) {
+	if len(id) != MasterKeyIDSize {
+		return KeySlot{}, errors.New("invalid master key ID")
+	}
+	gcm, err := masterSlotCipher(master)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	data := make([]byte, MasterKeyIDSize+gcm.NonceSize(), MasterKeyIDSize+gcm.NonceSize()+KeyLength+gcm.Overhead())
+	copy(data, id)
+	if _, err := rand.Read(data[MasterKeyIDSize:]); err != nil {
+		return KeySlot{}, err
+	}
+	data = gcm.Seal(data, data[MasterKeyIDSize:], key, append([]byte(masterSlotInfo), id...))
+	return KeySlot{Type: KeySlotMaster, Data: data}, nil
+}
+
+func masterSlotCipher(master []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// openMasterSlot asks keys for the master key a KeySlotMaster names and
+// opens the slot with it
+func openMasterSlot(data []byte, keys Keys) ([]byte, error) {
+	if keys.MasterKey == nil {
+		return nil, errors.New("this backup is encrypted with a keyring")
+	}
+	if len(data) < MasterKeyIDSize {
+		return nil, errors.New("corrupt key slot")
+	}
+	id := data[:MasterKeyIDSize]
+	master, err := keys.MasterKey(id)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := masterSlotCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != MasterKeyIDSize+gcm.NonceSize()+KeyLength+gcm.Overhead() {
+		return nil, errors.New("corrupt key slot")
+	}
+	nonce := data[MasterKeyIDSize : MasterKeyIDSize+gcm.NonceSize()]
+	key, err := gcm.Open(nil, nonce, data[MasterKeyIDSize+gcm.NonceSize():], append([]byte(masterSlotInfo), id...))
+	if err != nil {
+		return nil, errors.New("failed to open key slot: wrong keyring or corrupted header")
+	}
+	return key, nil
+}
+
+// unwrapKey opens the first key slot that keys can open
+func unwrapKey(slots []KeySlot, keys Keys) ([]byte, error) {
+	var unknown error
+	for _, slot := range slots {
+		if slot.Type == KeySlotMaster {
+			key, err := openMasterSlot(slot.Data, keys)
+			if errors.Is(err, ErrUnknownMasterKey) {
+				unknown = err
+				continue
+			}
+			return key, err
+		}
+		if slot.Type != KeySlotAge || len(keys.Identities) == 0 {
+			continue
+		}
+		r, err := age.Decrypt(bytes.NewReader(slot.Data), keys.Identities...)
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open key slot: %w", err)
+		}
+		key, err := io.ReadAll(io.LimitReader(r, KeyLength+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open key slot: %w", err)
+		}
+		if len(key) != KeyLength {
+			return nil, errors.New("corrupt key slot")
+		}
+		return key, nil
+	}
+	if unknown != nil {
+		return nil, unknown
+	}
+	return nil, ErrNoIdentity
+}
+
+func marshalSlots(slots []KeySlot) ([]byte, error) {
+	var body []byte
+	for _, slot := range slots {
+		if len(slot.Data) > 0xffff {
+			return nil, errors.New("key slot too large")
+		}
+		body = append(body, byte(slot.Type))
+		body = binary.BigEndian.AppendUint16(body, uint16(len(slot.Data)))
+		body = append(body, slot.Data...)
+	}
+	if len(body) > 0xffff {
+		return nil, errors.New("too many recipients")
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(body))), body...), nil
+}
+
+// UnwrapKey opens the key slots of a WrappedKey with keys
+func UnwrapKey(raw []byte, keys Keys) (*WrappedKey, error) {
+	slots, err := parseSlots(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := unwrapKey(slots, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &WrappedKey{Key: key, Slots: raw}, nil
+}
+
+// parseSlots parses the key slots of a WrappedKey
+func parseSlots(raw []byte) ([]KeySlot, error) {
+	r := bytes.NewReader(raw)
+	slots, _, err := readSlots(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("malformed key slots")
+	}
+	return slots, nil
+}
+
+// readSlots reads the key slots section that follows a header
+func readSlots(r io.Reader) ([]KeySlot, []byte, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, nil, fmt.Errorf("failed to read key slots: %w", err)
+	}
+	raw := make([]byte, 2+int(binary.BigEndian.Uint16(length)))
+	copy(raw, length)
+	if _, err := io.ReadFull(r, raw[2:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to read key slots: %w", err)
+	}
+
+	var slots []KeySlot
+	for body := raw[2:]; len(body) > 0; {
+		if len(body) < 3 {
+			return nil, nil, errors.New("malformed key slots")
+		}
+		n := int(binary.BigEndian.Uint16(body[1:3]))
+		if len(body) < 3+n {
+			return nil, nil, errors.New("malformed key slots")
+		}
+		slots = append(slots, KeySlot{Type: KeySlotType(body[0]), Data: body[3 : 3+n]})
+		body = body[3+n:]
+	}
+	if len(slots) == 0 {
+		return nil, nil, errors.New("backup has no key slots")
+	}
+	return slots, raw, nil
+}
+
+// ParseRecipients parses age public keys (age1...) given on the command
+// line and read from recipients files, one per line, # for comments
+func ParseRecipients(keys, files []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, key := range keys {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", key, err)
+		}
+		recipients = append(recipients, r)
+	}
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		recipients = append(recipients, parsed...)
+	}
+	return recipients, nil
+}
+
+// ReadIdentityFile reads the age identities (AGE-SECRET-KEY-1...) in path
+func ReadIdentityFile(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return identities, nil
+}
@@ -1,190 +0,0 @@
-package utils
-
-import (
-	"context"
-	"errors"
-	"fmt"
-	"io"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	cfg "github.com/shah1011/obscure/internal/config"
-)
-
-// GetS3CompatibleClient creates an S3 client configured for any S3-compatible service
-func GetS3CompatibleClient(providerKey string) (*s3.Client, error) {
-	// Get S3-compatible provider configuration
-	providerConfig, err := cfg.GetProviderConfig(providerKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get %s provider config: %w", providerKey, err)
-	}
-
-	// Create custom credentials
-	customCredentials := credentials.NewStaticCredentialsProvider(
-		providerConfig.AccessKeyID,
-		providerConfig.SecretAccessKey,
-		"", // session token (optional)
-	)
-
-	// Create custom endpoint resolver
-	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		var endpoint string
-		if providerKey == "filebase-ipfs" {
-			endpoint = providerConfig.FilebaseEndpoint
-		} else {
-			endpoint = providerConfig.S3CompatibleEndpoint
-		}
-		return aws.Endpoint{
-			URL:               endpoint,
-			SigningRegion:     providerConfig.Region,
-			HostnameImmutable: true,
-		}, nil
-	})
-
-	// Load AWS configuration with custom settings
-	awsCfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(providerConfig.Region),
-		config.WithCredentialsProvider(customCredentials),
-		config.WithEndpointResolverWithOptions(customResolver),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config for %s: %w", providerKey, err)
-	}
-
-	return s3.NewFromConfig(awsCfg), nil
-}
-
-// DownloadFromS3CompatibleStream downloads a file from S3-compatible storage and returns a reader
-func DownloadFromS3CompatibleStream(providerKey, bucket, key string) (io.ReadCloser, error) {
-	client, err := GetS3CompatibleClient(providerKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create %s client: %w", providerKey, err)
-	}
-
-	input := &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	}
-
-	resp, err := client.GetObject(context.TODO(), input)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get %s object: %w", providerKey, err)
-	}
-
-	return resp.Body, nil // stream — remember to defer Close()
-}
-
-// CheckIfS3CompatibleObjectExists checks if an object exists in S3-compatible storage
-func CheckIfS3CompatibleObjectExists(providerKey, bucket, key string) (bool, error) {
-	client, err := GetS3CompatibleClient(providerKey)
-	if err != nil {
-		return false, fmt.Errorf("failed to create %s client: %w", providerKey, err)
-	}
-
-	_, err = client.HeadObject(context.TODO(), &s3.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-
-	if err != nil {
-		var notFound *types.NotFound
-		if errors.As(err, &notFound) {
-			return false, nil // doesn't exist
-		}
-		return false, fmt.Errorf("failed to check %s object existence: %w", providerKey, err)
-	}
-
-	return true, nil // exists
-}
-
-// GetS3CompatibleObjectSize gets the size of an object in S3-compatible storage
-func GetS3CompatibleObjectSize(providerKey, bucket, key string) (int64, error) {
-	client, err := GetS3CompatibleClient(providerKey)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create %s client: %w", providerKey, err)
-	}
-
-	headResp, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return 0, fmt.Errorf("failed to get %s object size: %w", providerKey, err)
-	}
-
-	return *headResp.ContentLength, nil
-}
-
-// UploadToS3Compatible uploads a file to S3-compatible storage
-func UploadToS3Compatible(providerKey, bucket, key string, reader io.Reader, metadata map[string]string) error {
-	client, err := GetS3CompatibleClient(providerKey)
-	if err != nil {
-		return fmt.Errorf("failed to create %s client: %w", providerKey, err)
-	}
-
-	// Convert metadata to AWS format
-	awsMetadata := make(map[string]string)
-	for k, v := range metadata {
-		awsMetadata[k] = v
-	}
-
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:   aws.String(bucket),
-		Key:      aws.String(key),
-		Body:     reader,
-		Metadata: awsMetadata,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to upload to %s: %w", providerKey, err)
-	}
-
-	return nil
-}
-
-// DeleteFromS3Compatible deletes an object from S3-compatible storage
-func DeleteFromS3Compatible(providerKey, bucket, key string) error {
-	client, err := GetS3CompatibleClient(providerKey)
-	if err != nil {
-		return fmt.Errorf("failed to create %s client: %w", providerKey, err)
-	}
-
-	_, err = client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return fmt.Errorf("failed to delete from %s: %w", providerKey, err)
-	}
-
-	return nil
-}
-
-// ListS3CompatibleObjects lists objects in S3-compatible storage with a prefix
-func ListS3CompatibleObjects(providerKey, bucket, prefix string) ([]string, error) {
-	client, err := GetS3CompatibleClient(providerKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create %s client: %w", providerKey, err)
-	}
-
-	var objects []string
-	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	})
-
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(context.TODO())
-		if err != nil {
-			return nil, fmt.Errorf("failed to list %s objects: %w", providerKey, err)
-		}
-
-		for _, obj := range page.Contents {
-			objects = append(objects, *obj.Key)
-		}
-	}
-
-	return objects, nil
-}
@@ -3,8 +3,10 @@ package utils
 import (
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"os"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
@@ -12,6 +14,75 @@ const (
 	KeyLength = 32
 )
 
+// KDFAlgorithm identifies the password-based key derivation function of a backup
+type KDFAlgorithm byte
+
+const (
+	KDFScrypt KDFAlgorithm = 1
+	// KDFWrapped marks a backup with no password: its key is random and
+	// sealed to public keys or a keyring master key in the key slots
+	KDFWrapped KDFAlgorithm = 2
+	// KDFArgon2id is the default for new backups
+	KDFArgon2id KDFAlgorithm = 3
+)
+
+func (k KDFAlgorithm) String() string {
+	switch k {
+	case KDFScrypt:
+		return "scrypt"
+	case KDFWrapped:
+		return "none (key slots)"
+	case KDFArgon2id:
+		return "argon2id"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(k))
+	}
+}
+
+// KDFParams records the KDF and its cost parameters so that files stay
+// decryptable when the defaults change
+type KDFParams struct {
+	Algorithm KDFAlgorithm `json:"algorithm"`
+	// scrypt
+	N uint32 `json:"n,omitempty"`
+	R uint32 `json:"r,omitempty"`
+	P uint32 `json:"p,omitempty"`
+	// argon2id; Memory is in KiB
+	Memory  uint32 `json:"memory,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+// DefaultKDFParams returns the parameters used for new backups unless
+// others are configured: Argon2id with 64 MiB, 3 passes and 4 lanes, as
+// recommended by RFC 9106 for memory-constrained machines
+func DefaultKDFParams() KDFParams {
+	return Argon2idParams(64*1024, 3, 4)
+}
+
+// Argon2idParams returns Argon2id parameters; memory is in KiB
+func Argon2idParams(memory, time uint32, threads uint8) KDFParams {
+	return KDFParams{Algorithm: KDFArgon2id, Memory: memory, Time: time, Threads: threads}
+}
+
+// LegacyKDFParams returns the scrypt parameters that were the default
+// before Argon2id. Files written before the self-describing header rely on
+// these exact values.
+func LegacyKDFParams() KDFParams {
+	return KDFParams{Algorithm: KDFScrypt, N: 1 << 15, R: 8, P: 1}
+}
+
+func (p KDFParams) String() string {
+	switch p.Algorithm {
+	case KDFScrypt:
+		return fmt.Sprintf("scrypt (N=%d, r=%d, p=%d)", p.N, p.R, p.P)
+	case KDFArgon2id:
+		return fmt.Sprintf("argon2id (m=%d MiB, t=%d, p=%d)", p.Memory/1024, p.Time, p.Threads)
+	default:
+		return p.Algorithm.String()
+	}
+}
+
 func GenerateSalt() ([]byte, error) {
 	salt := make([]byte, 16)
 	_, err := rand.Read(salt)
@@ -21,11 +92,36 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
+// GenerateNoncePrefix returns the random per-file part of the chunk nonces
+func GenerateNoncePrefix() ([]byte, error) {
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+// DeriveKey derives a key with the legacy scrypt parameters, which is what
+// files written before the self-describing header use
 func DeriveKey(password string, salt []byte) ([]byte, error) {
+	return DeriveKeyWithParams(password, salt, LegacyKDFParams())
+}
+
+// DeriveKeyWithParams derives a key using the KDF recorded in a file header
+func DeriveKeyWithParams(password string, salt []byte, params KDFParams) ([]byte, error) {
 	if len(salt) == 0 {
 		return nil, errors.New("salt is required")
 	}
-	return scrypt.Key([]byte(password), salt, 1<<15, 8, 1, KeyLength)
+	switch params.Algorithm {
+	case KDFScrypt:
+		return scrypt.Key([]byte(password), salt, int(params.N), int(params.R), int(params.P), KeyLength)
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, KeyLength), nil
+	case KDFWrapped:
+		return nil, errors.New("this backup's key is sealed in key slots, not derived from a password")
+	default:
+		return nil, fmt.Errorf("unsupported KDF: %s", params.Algorithm)
+	}
 }
 
 func ExtractSaltFromEncryptedFile(filepath string) ([]byte, error) {
@@ -35,11 +131,10 @@ func ExtractSaltFromEncryptedFile(filepath string) ([]byte, error) {
 	}
 	defer f.Close()
 
-	salt := make([]byte, 16) // assuming 16-byte salt
-	_, err = f.Read(salt)
+	header, err := ReadHeader(f)
 	if err != nil {
 		return nil, err
 	}
 
-	return salt, nil
+	return header.Salt, nil
 }
@@ -0,0 +1,62 @@
+//go:build linux
+
+package utils
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReadXattrs returns the extended attributes of path, without following a
+// symlink. POSIX ACLs are among them, as system.posix_acl_access and
+// system.posix_acl_default.
+func ReadXattrs(path string) (map[string]string, error) {
+	names, err := xattrCall(func(buf []byte) (int, error) { return unix.Llistxattr(path, buf) })
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, name := range bytes.Split(bytes.TrimRight(names, "\x00"), []byte{0}) {
+		attr := string(name)
+		value, err := xattrCall(func(buf []byte) (int, error) { return unix.Lgetxattr(path, attr, buf) })
+		if errors.Is(err, unix.ENODATA) {
+			// Removed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		xattrs[attr] = string(value)
+	}
+	return xattrs, nil
+}
+
+// xattrCall sizes the buffer for a listxattr or getxattr call, retrying if
+// the attribute grows in between
+func xattrCall(call func(buf []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := call(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+func setXattr(path, name, value string) error {
+	return unix.Lsetxattr(path, name, []byte(value), 0)
+}
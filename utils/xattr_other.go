@@ -0,0 +1,15 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+// ReadXattrs is only implemented on Linux; elsewhere files are archived
+// without extended attributes
+func ReadXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func setXattr(path, name, value string) error {
+	return errors.ErrUnsupported
+}